@@ -0,0 +1,43 @@
+package acmedns
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// ExecProvider runs a command to create and remove the TXT records. The
+// command is called with the arguments
+//
+//	present <fqdn> <value>
+//	cleanup <fqdn> <value>
+//
+// and must exit with status 0 on success.
+type ExecProvider struct {
+	command string
+}
+
+// NewExecProvider returns a provider that runs command
+func NewExecProvider(command string) *ExecProvider {
+	return &ExecProvider{command: command}
+}
+
+// Present runs the command with present
+func (p *ExecProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+// CleanUp runs the command with cleanup
+func (p *ExecProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+func (p *ExecProvider) run(ctx context.Context, action, fqdn, value string) error {
+	cmd := exec.CommandContext(ctx, p.command, action, fqdn, value)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "%s %s failed: %s", p.command, action, bytes.TrimSpace(out))
+	}
+	return nil
+}
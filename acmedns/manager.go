@@ -0,0 +1,337 @@
+package acmedns
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+const (
+	defaultRenewBefore = 30 * 24 * time.Hour
+	renewCheckInterval = time.Hour
+	// accountKeyName is the cache entry of the account key. It is the same
+	// as the one of autocert, so both use the same account.
+	accountKeyName = "acme_account+key"
+)
+
+// Manager gets a certificate for Domains with the DNS-01 challenge and
+// renews it before it expires. Certificates and the account key are kept
+// in Cache.
+type Manager struct {
+	Client   *acme.Client // Key is set by the Manager
+	Provider Provider
+	Domains  []string
+	Cache    autocert.Cache
+	// ExternalAccountBinding is sent when the account is registered
+	ExternalAccountBinding *acme.ExternalAccountBinding
+	// PropagationDelay is the time the TXT records need to reach all DNS
+	// servers of the zone
+	PropagationDelay time.Duration
+	RenewBefore      time.Duration // 0 renews 30 days before expiry
+	Logger           *slog.Logger
+
+	mu         sync.RWMutex
+	cert       *tls.Certificate
+	registered bool
+}
+
+// Start loads the certificate from the cache or gets a new one. It keeps
+// renewing the certificate in the background until ctx is done.
+func (m *Manager) Start(ctx context.Context) error {
+	if m.Logger == nil {
+		m.Logger = logging.Discard()
+	}
+	if err := m.load(ctx); err != nil && err != autocert.ErrCacheMiss {
+		m.Logger.Warn("Error loading cached certificate", logging.Err(err))
+	}
+	if m.needsRenewal(time.Now()) {
+		if err := m.obtain(ctx); err != nil {
+			if m.current() == nil {
+				return err
+			}
+			m.Logger.Error("Error renewing certificate, using the cached one", logging.Err(err))
+		}
+	}
+	go m.renewLoop(ctx)
+	return nil
+}
+
+// TLSConfig returns a tls.Config that serves the certificate of the Manager
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// GetCertificate returns the certificate if it is valid for the requested
+// server name
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := m.current()
+	if cert == nil {
+		return nil, errors.New("No certificate available")
+	}
+	if hello.ServerName != "" {
+		if err := cert.Leaf.VerifyHostname(hello.ServerName); err != nil {
+			return nil, fmt.Errorf("No certificate for %s", hello.ServerName)
+		}
+	}
+	return cert, nil
+}
+
+func (m *Manager) current() *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert
+}
+
+func (m *Manager) needsRenewal(now time.Time) bool {
+	cert := m.current()
+	if cert == nil {
+		return true
+	}
+	renewBefore := m.RenewBefore
+	if renewBefore == 0 {
+		renewBefore = defaultRenewBefore
+	}
+	return now.Add(renewBefore).After(cert.Leaf.NotAfter)
+}
+
+func (m *Manager) renewLoop(ctx context.Context) {
+	ticker := time.NewTicker(renewCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !m.needsRenewal(now) {
+				continue
+			}
+			if err := m.obtain(ctx); err != nil {
+				m.Logger.Error("Error renewing certificate", logging.Err(err))
+			}
+		}
+	}
+}
+
+// cacheName returns the name of the certificate in the cache
+func (m *Manager) cacheName() string {
+	return "dns01+" + strings.Replace(strings.Join(m.Domains, ","), "*", "wildcard", -1)
+}
+
+func (m *Manager) load(ctx context.Context) error {
+	data, err := m.Cache.Get(ctx, m.cacheName())
+	if err != nil {
+		return err
+	}
+	cert, err := decodeCert(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	return nil
+}
+
+// obtain gets a new certificate from the ACME CA
+func (m *Manager) obtain(ctx context.Context) error {
+	if err := m.register(ctx); err != nil {
+		return err
+	}
+	order, err := m.Client.AuthorizeOrder(ctx, acme.DomainIDs(m.Domains...))
+	if err != nil {
+		return errors.Wrap(err, "Error creating ACME order")
+	}
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, u); err != nil {
+			return err
+		}
+	}
+	order, err = m.Client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return errors.Wrap(err, "ACME order failed")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "Error generating certificate key")
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.Domains[0]},
+		DNSNames: m.Domains,
+	}, key)
+	if err != nil {
+		return errors.Wrap(err, "Error creating certificate request")
+	}
+	der, _, err := m.Client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return errors.Wrap(err, "Error getting certificate")
+	}
+	data, err := encodeCert(key, der)
+	if err != nil {
+		return err
+	}
+	cert, err := decodeCert(data)
+	if err != nil {
+		return err
+	}
+	if err := m.Cache.Put(ctx, m.cacheName(), data); err != nil {
+		m.Logger.Warn("Error caching certificate", logging.Err(err))
+	}
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	m.Logger.Info("Got certificate", "domains", m.Domains, "notAfter", cert.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// register creates the ACME account if it does not exist yet
+func (m *Manager) register(ctx context.Context) error {
+	if m.registered {
+		return nil
+	}
+	key, err := m.accountKey(ctx)
+	if err != nil {
+		return err
+	}
+	m.Client.Key = key
+	acct := &acme.Account{ExternalAccountBinding: m.ExternalAccountBinding}
+	if _, err := m.Client.Register(ctx, acct, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return errors.Wrap(err, "Error registering ACME account")
+	}
+	m.registered = true
+	return nil
+}
+
+// accountKey returns the cached account key or creates a new one
+func (m *Manager) accountKey(ctx context.Context) (*ecdsa.PrivateKey, error) {
+	data, err := m.Cache.Get(ctx, accountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, errors.New("Invalid cached ACME account key")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if err != autocert.ErrCacheMiss {
+		return nil, errors.Wrap(err, "Error reading ACME account key")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error generating ACME account key")
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := m.Cache.Put(ctx, accountKeyName, data); err != nil {
+		return nil, errors.Wrap(err, "Error caching ACME account key")
+	}
+	return key, nil
+}
+
+// authorize solves the DNS-01 challenge of the authorization at url
+func (m *Manager) authorize(ctx context.Context, url string) error {
+	z, err := m.Client.GetAuthorization(ctx, url)
+	if err != nil {
+		return errors.Wrap(err, "Error getting ACME authorization")
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("ACME CA offers no dns-01 challenge for %s", z.Identifier.Value)
+	}
+	value, err := m.Client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := challengeRecord(z.Identifier.Value)
+	if err := m.Provider.Present(ctx, fqdn, value); err != nil {
+		return errors.Wrap(err, "Error creating TXT record "+fqdn)
+	}
+	defer func() {
+		if err := m.Provider.CleanUp(context.Background(), fqdn, value); err != nil {
+			m.Logger.Warn("Error removing TXT record", "name", fqdn, logging.Err(err))
+		}
+	}()
+	select {
+	case <-time.After(m.PropagationDelay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if _, err := m.Client.Accept(ctx, chal); err != nil {
+		return errors.Wrap(err, "Error accepting ACME challenge")
+	}
+	if _, err := m.Client.WaitAuthorization(ctx, z.URI); err != nil {
+		return errors.Wrap(err, "ACME authorization of "+z.Identifier.Value+" failed")
+	}
+	return nil
+}
+
+// encodeCert returns key and the certificate chain in PEM format
+func encodeCert(key *ecdsa.PrivateKey, der [][]byte) ([]byte, error) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error encoding certificate key")
+	}
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	for _, b := range der {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: b})
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeCert parses the output of encodeCert
+func decodeCert(data []byte) (*tls.Certificate, error) {
+	var keyPEM, certPEM []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if strings.Contains(block.Type, "PRIVATE KEY") {
+			keyPEM = pem.EncodeToMemory(block)
+		} else {
+			certPEM = append(certPEM, pem.EncodeToMemory(block)...)
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid certificate")
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid certificate")
+	}
+	return &cert, nil
+}
@@ -0,0 +1,98 @@
+package acmedns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestManager_cachedCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmedns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "*.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeCert(key, [][]byte{der})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{
+		Domains: []string{"example.com", "*.example.com"},
+		Cache:   autocert.DirCache(dir),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Cache.Put(ctx, m.cacheName(), data); err != nil {
+		t.Fatal(err)
+	}
+	// The cached certificate is valid long enough, no ACME request is sent
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	tests := []struct {
+		name       string
+		serverName string
+		wantErr    bool
+	}{
+		{
+			name:       "NoServerName",
+			serverName: "",
+		},
+		{
+			name:       "Domain",
+			serverName: "example.com",
+		},
+		{
+			name:       "Wildcard",
+			serverName: "loxwebhook.example.com",
+		},
+		{
+			name:       "OtherDomain",
+			serverName: "example.org",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cert.Leaf.Subject.CommonName != "example.com" {
+				t.Errorf("GetCertificate() returned certificate for %s", cert.Leaf.Subject.CommonName)
+			}
+		})
+	}
+	if m.needsRenewal(time.Now()) {
+		t.Errorf("needsRenewal() = true for a certificate valid for 90 days")
+	}
+	if !m.needsRenewal(time.Now().Add(61 * 24 * time.Hour)) {
+		t.Errorf("needsRenewal() = false 29 days before expiry")
+	}
+}
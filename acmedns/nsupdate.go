@@ -0,0 +1,72 @@
+package acmedns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// recordTTL is the TTL of the challenge records in seconds
+const recordTTL = 60
+
+// RFC2136Provider sends dynamic DNS updates (RFC 2136) with nsupdate
+type RFC2136Provider struct {
+	server  string // Host of the DNS server
+	port    int
+	keyFile string // TSIG key, empty sends unsigned updates
+	command string // nsupdate binary
+}
+
+// NewRFC2136Provider returns a provider that updates records on server
+// (host[:port]). keyFile is passed to nsupdate -k.
+func NewRFC2136Provider(server, keyFile string) (*RFC2136Provider, error) {
+	host, port := server, 53
+	if h, p, err := net.SplitHostPort(server); err == nil {
+		host = h
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid port of DNS server "+server)
+		}
+	}
+	if host == "" {
+		return nil, errors.New("DNS server must not be empty")
+	}
+	return &RFC2136Provider{
+		server:  host,
+		port:    port,
+		keyFile: keyFile,
+		command: "nsupdate",
+	}, nil
+}
+
+// Present adds the TXT record
+func (p *RFC2136Provider) Present(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fmt.Sprintf("update add %s %d TXT %q", fqdn, recordTTL, value))
+}
+
+// CleanUp deletes the TXT record
+func (p *RFC2136Provider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fmt.Sprintf("update delete %s TXT %q", fqdn, value))
+}
+
+func (p *RFC2136Provider) update(ctx context.Context, command string) error {
+	var script bytes.Buffer
+	fmt.Fprintf(&script, "server %s %d\n", p.server, p.port)
+	fmt.Fprintln(&script, command)
+	fmt.Fprintln(&script, "send")
+	var args []string
+	if p.keyFile != "" {
+		args = append(args, "-k", p.keyFile)
+	}
+	cmd := exec.CommandContext(ctx, p.command, args...)
+	cmd.Stdin = &script
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "nsupdate failed: %s", bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+// Package acmedns gets certificates from an ACME CA with the DNS-01
+// challenge. The TXT records of the challenges are created by a Provider.
+package acmedns
+
+import (
+	"context"
+	"strings"
+)
+
+// Provider creates and removes the TXT records of DNS-01 challenges
+type Provider interface {
+	// Present creates a TXT record with value for fqdn
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes the TXT record created by Present
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// challengeRecord returns the fully qualified name of the TXT record for the
+// challenge of domain. Wildcard names use the record of the base domain.
+func challengeRecord(domain string) string {
+	return "_acme-challenge." + strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".") + "."
+}
@@ -0,0 +1,113 @@
+package acmedns
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_challengeRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain string
+		want   string
+	}{
+		{
+			name:   "Name",
+			domain: "loxwebhook.example.com",
+			want:   "_acme-challenge.loxwebhook.example.com.",
+		},
+		{
+			name:   "Wildcard",
+			domain: "*.example.com",
+			want:   "_acme-challenge.example.com.",
+		},
+		{
+			name:   "Qualified",
+			domain: "example.com.",
+			want:   "_acme-challenge.example.com.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := challengeRecord(tt.domain); got != tt.want {
+				t.Errorf("challengeRecord() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeScript writes a shell script that appends its arguments and stdin to
+// the file out
+func writeScript(t *testing.T, dir, out string) string {
+	script := filepath.Join(dir, "hook.sh")
+	content := "#!/bin/sh\necho \"$@\" >> " + out + "\ncat >> " + out + "\n"
+	if err := ioutil.WriteFile(script, []byte(content), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmedns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	p, err := NewRFC2136Provider("ns1.example.com:5353", "/etc/tsig.key")
+	if err != nil {
+		t.Fatalf("NewRFC2136Provider() error = %v", err)
+	}
+	p.command = writeScript(t, dir, out)
+	if err := p.Present(context.Background(), "_acme-challenge.example.com.", "token"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if err := p.CleanUp(context.Background(), "_acme-challenge.example.com.", "token"); err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+	got, _ := ioutil.ReadFile(out)
+	want := `-k /etc/tsig.key
+server ns1.example.com 5353
+update add _acme-challenge.example.com. 60 TXT "token"
+send
+-k /etc/tsig.key
+server ns1.example.com 5353
+update delete _acme-challenge.example.com. TXT "token"
+send
+`
+	if string(got) != want {
+		t.Errorf("nsupdate got:\n%s\nwant:\n%s", got, want)
+	}
+	if p, _ := NewRFC2136Provider("192.0.2.53", ""); p.port != 53 {
+		t.Errorf("NewRFC2136Provider() port = %d, want default 53", p.port)
+	}
+}
+
+func TestExecProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmedns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	p := NewExecProvider(writeScript(t, dir, out))
+	if err := p.Present(context.Background(), "_acme-challenge.example.com.", "token"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if err := p.CleanUp(context.Background(), "_acme-challenge.example.com.", "token"); err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+	got, _ := ioutil.ReadFile(out)
+	want := "present _acme-challenge.example.com. token\ncleanup _acme-challenge.example.com. token\n"
+	if string(got) != want {
+		t.Errorf("command got:\n%s\nwant:\n%s", got, want)
+	}
+	failing := NewExecProvider(filepath.Join(dir, "missing"))
+	if err := failing.Present(context.Background(), "x.", "y"); err == nil || !strings.Contains(err.Error(), "present failed") {
+		t.Errorf("Present() with missing command error = %v", err)
+	}
+}
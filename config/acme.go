@@ -0,0 +1,106 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GetACMEDomains returns the names of the certificate requested from the
+// ACME CA. The default are the hostnames of all public hosts.
+func (c *Config) GetACMEDomains() []string {
+	if len(c.ACMEDomains) > 0 {
+		return c.ACMEDomains
+	}
+	return c.GetPublicHostnames()
+}
+
+// ACMEEABKey returns the decoded HMAC key of the external account binding
+func (c *Config) ACMEEABKey() ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(c.ACMEEABHMACKey, "="))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid ACMEEABHMACKey")
+	}
+	return key, nil
+}
+
+// ACMEHTTPClient returns the http.Client used to connect to the ACME server.
+// It returns nil to use the default client.
+func (c *Config) ACMEHTTPClient() (*http.Client, error) {
+	if c.ACMECAFile == "" {
+		return nil, nil
+	}
+	pem, err := ioutil.ReadFile(c.ACMECAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading ACMECAFile")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificate found in %s", c.ACMECAFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+// checkACME checks the settings used to get certificates in ListenMode autocert
+func (c *Config) checkACME() error {
+	if c.ACMEDirectoryURL != "" {
+		u, err := url.Parse(c.ACMEDirectoryURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("Invalid ACMEDirectoryURL " + c.ACMEDirectoryURL)
+		}
+	}
+	if _, err := c.ACMEHTTPClient(); err != nil {
+		return err
+	}
+	if (c.ACMEEABKeyID == "") != (c.ACMEEABHMACKey == "") {
+		return errors.New("ACMEEABKeyID and ACMEEABHMACKey must be set together")
+	}
+	if _, err := c.ACMEEABKey(); err != nil {
+		return err
+	}
+	switch c.ACMEChallenge {
+	case "tls-alpn-01":
+	case "dns-01":
+		switch c.ACMEDNSProvider {
+		case "rfc2136":
+			if c.ACMEDNSServer == "" {
+				return errors.New("ACMEDNSProvider rfc2136 needs ACMEDNSServer")
+			}
+			if err := c.checkFile(c.ACMEDNSKeyFile, "ACME DNS key file"); err != nil {
+				return err
+			}
+		case "exec":
+			if c.ACMEDNSCommand == "" {
+				return errors.New("ACMEDNSProvider exec needs ACMEDNSCommand")
+			}
+		default:
+			return errors.New("ACMEDNSProvider must be rfc2136 or exec")
+		}
+		if c.ACMEDNSDelay < 0 {
+			return errors.New("ACMEDNSDelay must not be negative")
+		}
+	default:
+		return errors.New("ACMEChallenge must be tls-alpn-01 or dns-01")
+	}
+	for _, d := range c.GetACMEDomains() {
+		if strings.HasPrefix(d, "*.") {
+			if c.ACMEChallenge != "dns-01" {
+				return errors.New("Wildcard names need ACMEChallenge dns-01")
+			}
+			d = d[2:]
+		}
+		if err := c.checkHostname(d); err != nil {
+			return errors.Wrap(err, "Invalid ACMEDomains")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfig_checkACME(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{
+			name: "Default",
+			cfg:  Config{PublicURI: "loxwebhook.example.com", ACMEChallenge: "tls-alpn-01"},
+		},
+		{
+			name: "DNSExec",
+			cfg: Config{
+				ACMEChallenge:   "dns-01",
+				ACMEDomains:     []string{"example.com", "*.example.com"},
+				ACMEDNSProvider: "exec",
+				ACMEDNSCommand:  "/usr/local/bin/dns-hook",
+			},
+		},
+		{
+			name: "DNSRFC2136WithoutServer",
+			cfg: Config{
+				PublicURI:       "loxwebhook.example.com",
+				ACMEChallenge:   "dns-01",
+				ACMEDNSProvider: "rfc2136",
+			},
+			wantErr: true,
+		},
+		{
+			name: "UnknownDNSProvider",
+			cfg: Config{
+				PublicURI:       "loxwebhook.example.com",
+				ACMEChallenge:   "dns-01",
+				ACMEDNSProvider: "route53",
+			},
+			wantErr: true,
+		},
+		{
+			name: "WildcardWithoutDNS",
+			cfg: Config{
+				ACMEChallenge: "tls-alpn-01",
+				ACMEDomains:   []string{"*.example.com"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownChallenge",
+			cfg:     Config{PublicURI: "loxwebhook.example.com", ACMEChallenge: "http-01"},
+			wantErr: true,
+		},
+		{
+			name: "InvalidDirectoryURL",
+			cfg: Config{
+				PublicURI:        "loxwebhook.example.com",
+				ACMEChallenge:    "tls-alpn-01",
+				ACMEDirectoryURL: "localhost:14000/dir",
+			},
+			wantErr: true,
+		},
+		{
+			name: "EABKeyWithoutID",
+			cfg: Config{
+				PublicURI:      "loxwebhook.example.com",
+				ACMEChallenge:  "tls-alpn-01",
+				ACMEEABHMACKey: "c2VjcmV0",
+			},
+			wantErr: true,
+		},
+		{
+			name: "EAB",
+			cfg: Config{
+				PublicURI:      "loxwebhook.example.com",
+				ACMEChallenge:  "tls-alpn-01",
+				ACMEEABKeyID:   "kid-1",
+				ACMEEABHMACKey: "c2VjcmV0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.checkACME(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.checkACME() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_ACMEEABKey(t *testing.T) {
+	c := Config{ACMEEABHMACKey: "c2VjcmV0LWtleQ"}
+	got, err := c.ACMEEABKey()
+	if err != nil {
+		t.Fatalf("ACMEEABKey() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []byte("secret-key")) {
+		t.Errorf("ACMEEABKey() = %s, want secret-key", got)
+	}
+}
@@ -1,11 +1,13 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -16,54 +18,147 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/pkg/errors"
+
+	"github.com/axxelG/loxwebhook/helpers"
 )
 
 // Config holds the configuration values
 type Config struct {
-	Version            string
-	ConfigFile         string
-	LogFileMain        string
-	LogFileHTTPError   string
-	LogFileHTTPAccess  string
-	ListenPort         int
-	PublicURI          string
-	LetsEncryptCache   string
-	ControlsFiles      string
-	MiniserverURL      *url.URL
-	MiniserverUser     string
-	MiniserverPassword string
-	MiniserverTimeout  time.Duration
+	Version                 string
+	ConfigFile              string
+	LogFileMain             string
+	LogFileHTTPError        string
+	LogFileHTTPAccess       string
+	LogFormat               string
+	LogLevel                string
+	ListenPort              int
+	PublicURI               string
+	LetsEncryptCache        string
+	ControlsFiles           string
+	ControlsWatch           bool
+	AuthKeyHeader           string
+	StateFile               string
+	RateLimitIP             int
+	RateBurstIP             int
+	RateLimitKey            int
+	RateBurstKey            int
+	RateLimitControl        int
+	RateBurstControl        int
+	BanThreshold            int
+	BanTime                 int
+	LimiterMaxEntries       int
+	AdminListenAddress      string
+	MetricsListenAddress    string
+	TrustedProxies          []string
+	ClientCAFile            string
+	ListenMode              string
+	ShutdownTimeout         int
+	ListenAddress           string
+	TLSCertFile             string
+	TLSKeyFile              string
+	PathPrefix              string
+	ACMEDirectoryURL        string
+	ACMECAFile              string
+	ACMEEABKeyID            string
+	ACMEEABHMACKey          string
+	ACMEChallenge           string
+	ACMEDomains             []string
+	ACMEDNSProvider         string
+	ACMEDNSServer           string
+	ACMEDNSKeyFile          string
+	ACMEDNSCommand          string
+	ACMEDNSDelay            int
+	MiniserverURL           *url.URL
+	MiniserverUser          string
+	MiniserverPassword      string
+	MiniserverTimeout       time.Duration
+	MiniserverAuth          string
+	MiniserverTransport     string
+	MiniserverEncryption    string
+	MiniserverTLSSkipVerify bool
+	MiniserverTLSCAFile     string
+	Miniservers             map[string]*Miniserver // Named Miniservers besides the default one
+	PublicHosts             map[string]*PublicHost // Named public hosts besides PublicURI
 }
 
 // String returns a multiline String to print Config.
 func (c *Config) String() string {
-	return fmt.Sprintf(
-		"Config:\n"+
-			"Version:              %s\n"+
-			"Config file:          %s\n"+
-			"Log file main:        %s\n"+
-			"Log file http errors: %s\n"+
-			"Log file http access: %s\n"+
-			"Listen Port:          %d\n"+
-			"Public URI:           %s\n"+
-			"LetsEncrypt Cache:    %s\n"+
-			"Configs Directory:    %s\n"+
-			"Miniserver URL:       %s\n"+
-			"Miniserver User:      %s\n"+
-			"Miniserver Timeout:   %d seconds\n",
-		c.Version,
-		c.ConfigFile,
-		c.LogFileMain,
-		c.LogFileHTTPError,
-		c.LogFileHTTPAccess,
-		c.ListenPort,
-		c.PublicURI,
-		c.LetsEncryptCache,
-		c.ControlsFiles,
-		c.MiniserverURL,
-		c.MiniserverUser,
-		int64(c.MiniserverTimeout.Seconds()),
-	)
+	var sb strings.Builder
+	sb.WriteString("Config:\n")
+	for _, a := range c.logAttrs() {
+		fmt.Fprintf(&sb, "%-22s %s\n", a.Key+":", a.Value)
+	}
+	return sb.String()
+}
+
+// LogValue implements slog.LogValuer. It logs the settings as a group of
+// attributes.
+func (c *Config) LogValue() slog.Value {
+	return slog.GroupValue(c.logAttrs()...)
+}
+
+// logAttrs returns the settings shown by String and LogValue. Passwords and
+// keys are left out.
+func (c *Config) logAttrs() []slog.Attr {
+	miniserverURL := ""
+	if c.MiniserverURL != nil {
+		miniserverURL = c.MiniserverURL.String()
+	}
+	return []slog.Attr{
+		slog.String("version", c.Version),
+		slog.String("configFile", c.ConfigFile),
+		slog.String("logFileMain", c.LogFileMain),
+		slog.String("logFileHTTPError", c.LogFileHTTPError),
+		slog.String("logFileHTTPAccess", c.LogFileHTTPAccess),
+		slog.String("logFormat", c.LogFormat),
+		slog.String("logLevel", c.LogLevel),
+		slog.Int("listenPort", c.ListenPort),
+		slog.String("publicURI", c.PublicURI),
+		slog.String("letsEncryptCache", c.LetsEncryptCache),
+		slog.String("controlsFiles", c.ControlsFiles),
+		slog.Bool("controlsWatch", c.ControlsWatch),
+		slog.String("authKeyHeader", c.AuthKeyHeader),
+		slog.String("stateFile", c.StateFile),
+		slog.Int("rateLimitIP", c.RateLimitIP),
+		slog.Int("rateBurstIP", c.RateBurstIP),
+		slog.Int("rateLimitKey", c.RateLimitKey),
+		slog.Int("rateBurstKey", c.RateBurstKey),
+		slog.Int("rateLimitControl", c.RateLimitControl),
+		slog.Int("rateBurstControl", c.RateBurstControl),
+		slog.Int("banThreshold", c.BanThreshold),
+		slog.Int("banTime", c.BanTime),
+		slog.Int("limiterMaxEntries", c.LimiterMaxEntries),
+		slog.String("adminListenAddress", c.AdminListenAddress),
+		slog.String("metricsListenAddress", c.MetricsListenAddress),
+		slog.Any("trustedProxies", c.TrustedProxies),
+		slog.String("clientCAFile", c.ClientCAFile),
+		slog.String("listenMode", c.ListenMode),
+		slog.Int("shutdownTimeout", c.ShutdownTimeout),
+		slog.String("listenAddress", c.ListenAddress),
+		slog.String("tlsCertFile", c.TLSCertFile),
+		slog.String("tlsKeyFile", c.TLSKeyFile),
+		slog.String("pathPrefix", c.PathPrefix),
+		slog.String("acmeDirectoryURL", c.ACMEDirectoryURL),
+		slog.String("acmeCAFile", c.ACMECAFile),
+		slog.String("acmeEABKeyID", c.ACMEEABKeyID),
+		slog.String("acmeChallenge", c.ACMEChallenge),
+		slog.Any("acmeDomains", c.ACMEDomains),
+		slog.String("acmeDNSProvider", c.ACMEDNSProvider),
+		slog.String("acmeDNSServer", c.ACMEDNSServer),
+		slog.String("acmeDNSKeyFile", c.ACMEDNSKeyFile),
+		slog.String("acmeDNSCommand", c.ACMEDNSCommand),
+		slog.Int("acmeDNSDelay", c.ACMEDNSDelay),
+		slog.String("miniserverURL", miniserverURL),
+		slog.String("miniserverUser", c.MiniserverUser),
+		slog.Duration("miniserverTimeout", c.MiniserverTimeout),
+		slog.String("miniserverAuth", c.MiniserverAuth),
+		slog.String("miniserverTransport", c.MiniserverTransport),
+		slog.String("miniserverEncryption", c.MiniserverEncryption),
+		slog.Bool("miniserverTLSSkipVerify", c.MiniserverTLSSkipVerify),
+		slog.String("miniserverTLSCAFile", c.MiniserverTLSCAFile),
+		slog.Any("miniservers", c.GetMiniserverNames()),
+		slog.Any("publicHosts", c.GetPublicHostnames()),
+	}
 }
 
 func (c *Config) checkFile(fn, description string) error {
@@ -110,20 +205,104 @@ func (c *Config) checkHostname(h string) error {
 	return nil
 }
 
-func (c *Config) reachMiniserver(address *url.URL) error {
-	testEndpoint := address
-	testEndpoint.Path = "/jdev/cfg/api"
-	client := http.Client{
-		Timeout: c.MiniserverTimeout,
+func (c *Config) checkLimits() error {
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"RateLimitIP", c.RateLimitIP},
+		{"RateBurstIP", c.RateBurstIP},
+		{"RateLimitKey", c.RateLimitKey},
+		{"RateBurstKey", c.RateBurstKey},
+		{"RateLimitControl", c.RateLimitControl},
+		{"RateBurstControl", c.RateBurstControl},
+		{"BanThreshold", c.BanThreshold},
+		{"BanTime", c.BanTime},
+	}
+	for _, l := range limits {
+		if l.value < 0 {
+			return errors.New(l.name + " must not be negative")
+		}
+	}
+	if c.LimiterMaxEntries < 1 {
+		return errors.New("LimiterMaxEntries must be >= 1")
+	}
+	return nil
+}
+
+// checkLogging checks LogFormat and LogLevel
+func (c *Config) checkLogging() error {
+	switch c.LogFormat {
+	case "logfmt", "json":
+	default:
+		return errors.New("LogFormat must be logfmt or json")
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return errors.New("LogLevel must be debug, info, warn or error")
+	}
+	return nil
+}
+
+// checkListener checks ListenMode and the settings the mode needs
+func (c *Config) checkListener() error {
+	switch c.ListenMode {
+	case "autocert":
+	case "tls":
+		if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+			return errors.New("ListenMode tls needs TLSCertFile and TLSKeyFile")
+		}
+		if _, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile); err != nil {
+			return errors.Wrap(err, "Error loading TLSCertFile and TLSKeyFile")
+		}
+	case "http":
+		if c.ClientCAFile != "" {
+			return errors.New("ClientCAFile needs ListenMode autocert or tls")
+		}
+	default:
+		return errors.New("ListenMode must be autocert, tls or http")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("ShutdownTimeout must not be negative")
 	}
-	resp, err := client.Get(testEndpoint.String())
+	network, address := c.GetListenAddress()
+	if network == "unix" {
+		if c.ListenMode != "http" {
+			return errors.New("Unix sockets are only supported with ListenMode http")
+		}
+		if address == "" {
+			return errors.New("ListenAddress has no socket path")
+		}
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
 	if err != nil {
-		return errors.Wrap(err, "Cannot reach miniserver")
+		return errors.Wrap(err, "Invalid ListenAddress")
+	}
+	if c.ListenMode == "http" && !isLoopback(host) {
+		// Plain HTTP must only be reachable by the reverse proxy
+		return errors.New("ListenMode http only listens on loopback addresses or Unix sockets")
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
 	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Miniserver responded with status code %d", resp.StatusCode)
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (c *Config) checkAuthKeyHeader(h string) error {
+	re := regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	if !re.MatchString(h) {
+		return errors.New("Invalid AuthKeyHeader " + h)
+	}
+	if strings.EqualFold(h, "Authorization") {
+		return errors.New("AuthKeyHeader must not be Authorization")
 	}
-	resp.Body.Close()
 	return nil
 }
 
@@ -145,16 +324,56 @@ func (c *Config) Validate() error {
 		// We are using port 65535 as default value for the listenport flag.
 		return errors.New("ListenPort must be < 65535")
 	}
+	if err := c.checkLogging(); err != nil {
+		return err
+	}
+	if err := c.checkListener(); err != nil {
+		return err
+	}
+	if c.ListenMode == "autocert" {
+		if err := c.checkACME(); err != nil {
+			return err
+		}
+	}
+	if err := c.checkAuthKeyHeader(c.AuthKeyHeader); err != nil {
+		return err
+	}
+	if err := c.checkLimits(); err != nil {
+		return err
+	}
+	if _, _, err := c.GetTrustedProxies(); err != nil {
+		return err
+	}
+	if _, err := c.ClientCAPool(); err != nil {
+		return err
+	}
+	if c.AdminListenAddress != "" {
+		if _, _, err := net.SplitHostPort(c.AdminListenAddress); err != nil {
+			return errors.Wrap(err, "Invalid AdminListenAddress")
+		}
+	}
+	if c.MetricsListenAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsListenAddress); err != nil {
+			return errors.Wrap(err, "Invalid MetricsListenAddress")
+		}
+	}
 	if err := c.checkHostname(c.PublicURI); err != nil {
 		return err
 	}
-	if _, err := net.LookupIP(c.PublicURI); err != nil {
-		return errors.Wrap(err, "Error looking up public URI")
+	if err := c.validatePublicHosts(); err != nil {
+		return err
 	}
-	if err := c.reachMiniserver(c.MiniserverURL); err != nil {
+	if c.ListenMode == "autocert" && c.ACMEChallenge == "tls-alpn-01" {
+		// The ACME CA must reach loxwebhook with these names
+		for _, h := range c.GetPublicHostnames() {
+			if _, err := net.LookupIP(h); err != nil {
+				return errors.Wrap(err, "Error looking up public URI")
+			}
+		}
+	}
+	if err := c.validateMiniservers(); err != nil {
 		return err
 	}
-	//TODO: Validate username and password
 	return nil
 }
 
@@ -163,23 +382,118 @@ func (c Config) GetListenPort() string {
 	return ":" + strconv.Itoa(c.ListenPort)
 }
 
+// TrustedProxyUnixSocket in TrustedProxies trusts the reverse proxy that
+// connects over a Unix socket
+const TrustedProxyUnixSocket = "unix"
+
+// GetTrustedProxies returns the networks in TrustedProxies and whether
+// requests over a Unix socket come from a trusted proxy
+func (c *Config) GetTrustedProxies() (networks []*net.IPNet, unixSocket bool, err error) {
+	var list []string
+	for _, p := range c.TrustedProxies {
+		if p == TrustedProxyUnixSocket {
+			unixSocket = true
+			continue
+		}
+		list = append(list, p)
+	}
+	networks, err = helpers.ParseNetworks(list)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Invalid TrustedProxies")
+	}
+	return networks, unixSocket, nil
+}
+
+// ClientCAPool returns the CAs that sign client certificates. It returns nil
+// if client certificates are not used.
+func (c *Config) ClientCAPool() (*x509.CertPool, error) {
+	if c.ClientCAFile == "" {
+		return nil, nil
+	}
+	pem, err := ioutil.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading ClientCAFile")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificate found in %s", c.ClientCAFile)
+	}
+	return pool, nil
+}
+
+// GetListenAddress returns the network (tcp or unix) and the address to
+// listen on
+func (c Config) GetListenAddress() (network, address string) {
+	switch {
+	case strings.HasPrefix(c.ListenAddress, "unix:"):
+		return "unix", strings.TrimPrefix(c.ListenAddress, "unix:")
+	case c.ListenAddress != "":
+		return "tcp", c.ListenAddress
+	case c.ListenMode == "http":
+		return "tcp", "127.0.0.1" + c.GetListenPort()
+	}
+	return "tcp", c.GetListenPort()
+}
+
 // basicTypeConfig holds the config internally used by the
 // config package. We are not using types like *url.URL or
 // time.Duration because they are not supported by flags,
 // environment variables or toml.
 type basicTypeConfig struct {
-	ConfigFile         string
-	LogFileMain        string
-	LogFileHTTPError   string
-	LogFileHTTPAccess  string
-	ListenPort         int
-	PublicURI          string
-	LetsEncryptCache   string
-	ControlsFiles      string
-	MiniserverURL      string
-	MiniserverUser     string
-	MiniserverPassword string
-	MiniserverTimeout  int // Seconds
+	ConfigFile              string
+	LogFileMain             string
+	LogFileHTTPError        string
+	LogFileHTTPAccess       string
+	LogFormat               string
+	LogLevel                string
+	ListenPort              int
+	PublicURI               string
+	LetsEncryptCache        string
+	ControlsFiles           string
+	ControlsWatch           bool
+	AuthKeyHeader           string
+	StateFile               string
+	RateLimitIP             int
+	RateBurstIP             int
+	RateLimitKey            int
+	RateBurstKey            int
+	RateLimitControl        int
+	RateBurstControl        int
+	BanThreshold            int
+	BanTime                 int
+	LimiterMaxEntries       int
+	AdminListenAddress      string
+	MetricsListenAddress    string
+	TrustedProxies          []string
+	ClientCAFile            string
+	ListenMode              string
+	ShutdownTimeout         int
+	ListenAddress           string
+	TLSCertFile             string
+	TLSKeyFile              string
+	PathPrefix              string
+	ACMEDirectoryURL        string
+	ACMECAFile              string
+	ACMEEABKeyID            string
+	ACMEEABHMACKey          string
+	ACMEChallenge           string
+	ACMEDomains             []string
+	ACMEDNSProvider         string
+	ACMEDNSServer           string
+	ACMEDNSKeyFile          string
+	ACMEDNSCommand          string
+	ACMEDNSDelay            int
+	MiniserverURL           string
+	MiniserverUser          string
+	MiniserverPassword      string
+	MiniserverTimeout       int // Seconds
+	MiniserverAuth          string
+	MiniserverTransport     string
+	MiniserverEncryption    string
+	MiniserverTLSSkipVerify bool
+	MiniserverTLSCAFile     string
+	Miniservers             map[string]basicTypeMiniserver
+	PublicHosts             map[string]basicTypePublicHost
 }
 
 func (btc *basicTypeConfig) getConfig() (*Config, error) {
@@ -189,11 +503,49 @@ func (btc *basicTypeConfig) getConfig() (*Config, error) {
 	cfg.LogFileMain = btc.LogFileMain
 	cfg.LogFileHTTPError = btc.LogFileHTTPError
 	cfg.LogFileHTTPAccess = btc.LogFileHTTPAccess
+	cfg.LogFormat = btc.LogFormat
+	cfg.LogLevel = btc.LogLevel
 	cfg.ControlsFiles = btc.ControlsFiles
+	cfg.ControlsWatch = btc.ControlsWatch
 	cfg.ListenPort = btc.ListenPort
 	cfg.PublicURI = btc.PublicURI
 	cfg.LetsEncryptCache = btc.LetsEncryptCache
 	cfg.ControlsFiles = btc.ControlsFiles
+	cfg.AuthKeyHeader = btc.AuthKeyHeader
+	cfg.StateFile = btc.StateFile
+	cfg.RateLimitIP = btc.RateLimitIP
+	cfg.RateBurstIP = btc.RateBurstIP
+	cfg.RateLimitKey = btc.RateLimitKey
+	cfg.RateBurstKey = btc.RateBurstKey
+	cfg.RateLimitControl = btc.RateLimitControl
+	cfg.RateBurstControl = btc.RateBurstControl
+	cfg.BanThreshold = btc.BanThreshold
+	cfg.BanTime = btc.BanTime
+	cfg.LimiterMaxEntries = btc.LimiterMaxEntries
+	cfg.AdminListenAddress = btc.AdminListenAddress
+	cfg.MetricsListenAddress = btc.MetricsListenAddress
+	cfg.TrustedProxies = btc.TrustedProxies
+	cfg.ClientCAFile = btc.ClientCAFile
+	cfg.ListenMode = btc.ListenMode
+	cfg.ShutdownTimeout = btc.ShutdownTimeout
+	cfg.ListenAddress = btc.ListenAddress
+	cfg.TLSCertFile = btc.TLSCertFile
+	cfg.TLSKeyFile = btc.TLSKeyFile
+	cfg.PathPrefix, err = normalizePathPrefix(btc.PathPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid PathPrefix")
+	}
+	cfg.ACMEDirectoryURL = btc.ACMEDirectoryURL
+	cfg.ACMECAFile = btc.ACMECAFile
+	cfg.ACMEEABKeyID = btc.ACMEEABKeyID
+	cfg.ACMEEABHMACKey = btc.ACMEEABHMACKey
+	cfg.ACMEChallenge = btc.ACMEChallenge
+	cfg.ACMEDomains = btc.ACMEDomains
+	cfg.ACMEDNSProvider = btc.ACMEDNSProvider
+	cfg.ACMEDNSServer = btc.ACMEDNSServer
+	cfg.ACMEDNSKeyFile = btc.ACMEDNSKeyFile
+	cfg.ACMEDNSCommand = btc.ACMEDNSCommand
+	cfg.ACMEDNSDelay = btc.ACMEDNSDelay
 	cfg.MiniserverURL, err = url.Parse(btc.MiniserverURL)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error parsing MiniserverURL")
@@ -201,9 +553,33 @@ func (btc *basicTypeConfig) getConfig() (*Config, error) {
 	cfg.MiniserverUser = btc.MiniserverUser
 	cfg.MiniserverPassword = btc.MiniserverPassword
 	cfg.MiniserverTimeout = time.Duration(btc.MiniserverTimeout) * time.Second
+	cfg.MiniserverAuth = strings.ToLower(btc.MiniserverAuth)
+	cfg.MiniserverTransport = strings.ToLower(btc.MiniserverTransport)
+	cfg.MiniserverEncryption = strings.ToLower(btc.MiniserverEncryption)
+	cfg.MiniserverTLSSkipVerify = btc.MiniserverTLSSkipVerify
+	cfg.MiniserverTLSCAFile = btc.MiniserverTLSCAFile
+	cfg.PublicHosts, err = btc.getPublicHosts()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Miniservers, err = btc.getMiniservers()
+	if err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// splitList splits a comma separated list of env variables and flags
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 // newDefaultConfig returns importConfig struct with default values
 func newDefaultConfig() *basicTypeConfig {
 	cfg := new(basicTypeConfig)
@@ -211,14 +587,34 @@ func newDefaultConfig() *basicTypeConfig {
 	cfg.LogFileMain = ""
 	cfg.LogFileHTTPError = ""
 	cfg.LogFileHTTPAccess = ""
+	cfg.LogFormat = "logfmt"
+	cfg.LogLevel = "info"
 	cfg.ControlsFiles = "./controls.d"
 	cfg.ListenPort = 4443
 	cfg.PublicURI = ""
 	cfg.LetsEncryptCache = "./cache/letsencrypt"
+	cfg.AuthKeyHeader = "X-Loxwebhook-AuthKey"
+	cfg.StateFile = "./state/state.json"
+	cfg.RateLimitIP = 60
+	cfg.RateBurstIP = 10
+	cfg.RateLimitKey = 60
+	cfg.RateBurstKey = 5
+	cfg.RateLimitControl = 60
+	cfg.RateBurstControl = 3
+	cfg.BanThreshold = 10
+	cfg.BanTime = 900
+	cfg.LimiterMaxEntries = 10000
+	cfg.ListenMode = "autocert"
+	cfg.ShutdownTimeout = 10
+	cfg.ACMEChallenge = "tls-alpn-01"
+	cfg.ACMEDNSDelay = 60
 	cfg.MiniserverURL = ""
 	cfg.MiniserverUser = "admin"
 	cfg.MiniserverPassword = "admin"
 	cfg.MiniserverTimeout = 2 // Seconds
+	cfg.MiniserverAuth = "token"
+	cfg.MiniserverTransport = "http"
+	cfg.MiniserverEncryption = "none"
 	return cfg
 }
 
@@ -237,9 +633,22 @@ func newEnvConfig() (*basicTypeConfig, error) {
 	if val, ok := os.LookupEnv(pref + "LOGFILEHTTPACCESS"); ok {
 		cfg.LogFileHTTPAccess = val
 	}
+	if val, ok := os.LookupEnv(pref + "LOGFORMAT"); ok {
+		cfg.LogFormat = val
+	}
+	if val, ok := os.LookupEnv(pref + "LOGLEVEL"); ok {
+		cfg.LogLevel = val
+	}
 	if val, ok := os.LookupEnv(pref + "CONTROLSFILES"); ok {
 		cfg.ControlsFiles = val
 	}
+	if val, ok := os.LookupEnv(pref + "CONTROLSWATCH"); ok {
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting CONTROLSWATCH from env")
+		}
+		cfg.ControlsWatch = v
+	}
 	if val, ok := os.LookupEnv(pref + "LISTENPORT"); ok {
 		v, err := strconv.Atoi(val)
 		if err != nil {
@@ -253,6 +662,146 @@ func newEnvConfig() (*basicTypeConfig, error) {
 	if val, ok := os.LookupEnv(pref + "LETSENCRYPTCACHE"); ok {
 		cfg.LetsEncryptCache = val
 	}
+	if val, ok := os.LookupEnv(pref + "AUTHKEYHEADER"); ok {
+		cfg.AuthKeyHeader = val
+	}
+	if val, ok := os.LookupEnv(pref + "STATEFILE"); ok {
+		cfg.StateFile = val
+	}
+	if val, ok := os.LookupEnv(pref + "RATELIMITIP"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting RATELIMITIP from env")
+		}
+		cfg.RateLimitIP = v
+	}
+	if val, ok := os.LookupEnv(pref + "RATEBURSTIP"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting RATEBURSTIP from env")
+		}
+		cfg.RateBurstIP = v
+	}
+	if val, ok := os.LookupEnv(pref + "RATELIMITKEY"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting RATELIMITKEY from env")
+		}
+		cfg.RateLimitKey = v
+	}
+	if val, ok := os.LookupEnv(pref + "RATEBURSTKEY"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting RATEBURSTKEY from env")
+		}
+		cfg.RateBurstKey = v
+	}
+	if val, ok := os.LookupEnv(pref + "RATELIMITCONTROL"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting RATELIMITCONTROL from env")
+		}
+		cfg.RateLimitControl = v
+	}
+	if val, ok := os.LookupEnv(pref + "RATEBURSTCONTROL"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting RATEBURSTCONTROL from env")
+		}
+		cfg.RateBurstControl = v
+	}
+	if val, ok := os.LookupEnv(pref + "BANTHRESHOLD"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting BANTHRESHOLD from env")
+		}
+		cfg.BanThreshold = v
+	}
+	if val, ok := os.LookupEnv(pref + "BANTIME"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting BANTIME from env")
+		}
+		cfg.BanTime = v
+	}
+	if val, ok := os.LookupEnv(pref + "LIMITERMAXENTRIES"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting LIMITERMAXENTRIES from env")
+		}
+		cfg.LimiterMaxEntries = v
+	}
+	if val, ok := os.LookupEnv(pref + "ADMINLISTENADDRESS"); ok {
+		cfg.AdminListenAddress = val
+	}
+	if val, ok := os.LookupEnv(pref + "METRICSLISTENADDRESS"); ok {
+		cfg.MetricsListenAddress = val
+	}
+	if val, ok := os.LookupEnv(pref + "TRUSTEDPROXIES"); ok {
+		cfg.TrustedProxies = splitList(val)
+	}
+	if val, ok := os.LookupEnv(pref + "CLIENTCAFILE"); ok {
+		cfg.ClientCAFile = val
+	}
+	if val, ok := os.LookupEnv(pref + "LISTENMODE"); ok {
+		cfg.ListenMode = val
+	}
+	if val, ok := os.LookupEnv(pref + "SHUTDOWNTIMEOUT"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting SHUTDOWNTIMEOUT from env")
+		}
+		cfg.ShutdownTimeout = v
+	}
+	if val, ok := os.LookupEnv(pref + "LISTENADDRESS"); ok {
+		cfg.ListenAddress = val
+	}
+	if val, ok := os.LookupEnv(pref + "TLSCERTFILE"); ok {
+		cfg.TLSCertFile = val
+	}
+	if val, ok := os.LookupEnv(pref + "TLSKEYFILE"); ok {
+		cfg.TLSKeyFile = val
+	}
+	if val, ok := os.LookupEnv(pref + "PATHPREFIX"); ok {
+		cfg.PathPrefix = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMEDIRECTORYURL"); ok {
+		cfg.ACMEDirectoryURL = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMECAFILE"); ok {
+		cfg.ACMECAFile = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMEEABKEYID"); ok {
+		cfg.ACMEEABKeyID = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMEEABHMACKEY"); ok {
+		cfg.ACMEEABHMACKey = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMECHALLENGE"); ok {
+		cfg.ACMEChallenge = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMEDOMAINS"); ok {
+		cfg.ACMEDomains = splitList(val)
+	}
+	if val, ok := os.LookupEnv(pref + "ACMEDNSPROVIDER"); ok {
+		cfg.ACMEDNSProvider = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMEDNSSERVER"); ok {
+		cfg.ACMEDNSServer = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMEDNSKEYFILE"); ok {
+		cfg.ACMEDNSKeyFile = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMEDNSCOMMAND"); ok {
+		cfg.ACMEDNSCommand = val
+	}
+	if val, ok := os.LookupEnv(pref + "ACMEDNSDELAY"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting ACMEDNSDELAY from env")
+		}
+		cfg.ACMEDNSDelay = v
+	}
 	if val, ok := os.LookupEnv(pref + "MINISERVERURL"); ok {
 		cfg.MiniserverURL = val
 	}
@@ -269,6 +818,25 @@ func newEnvConfig() (*basicTypeConfig, error) {
 		}
 		cfg.MiniserverTimeout = v
 	}
+	if val, ok := os.LookupEnv(pref + "MINISERVERAUTH"); ok {
+		cfg.MiniserverAuth = val
+	}
+	if val, ok := os.LookupEnv(pref + "MINISERVERTRANSPORT"); ok {
+		cfg.MiniserverTransport = val
+	}
+	if val, ok := os.LookupEnv(pref + "MINISERVERENCRYPTION"); ok {
+		cfg.MiniserverEncryption = val
+	}
+	if val, ok := os.LookupEnv(pref + "MINISERVERTLSSKIPVERIFY"); ok {
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting MINISERVERTLSSKIPVERIFY from env")
+		}
+		cfg.MiniserverTLSSkipVerify = v
+	}
+	if val, ok := os.LookupEnv(pref + "MINISERVERTLSCAFILE"); ok {
+		cfg.MiniserverTLSCAFile = val
+	}
 	return cfg, nil
 }
 
@@ -284,20 +852,66 @@ func newFlagConfig(versionStr string) *basicTypeConfig {
 	logFileMain := flags.String("logfilemain", "", "Log file")
 	logFileHTTPError := flags.String("logfilehttperror", "", "Log file")
 	logFileHTTPAccess := flags.String("logfilehttpaccess", "", "Log file")
+	logFormat := flags.String("logFormat", "", "Log format: logfmt or json")
+	logLevel := flags.String("logLevel", "", "Minimum log level: debug, info, warn or error")
 	controlsFiles := flags.String("controlsfiles", "", "Directory containing controls files")
+	controlsWatch := flags.Bool("controlsWatch", false, "Reload controls files when they change (Linux only)")
 	listenPort := flags.Int("listenport", 65535, "Port to listen on")
 	publicURI := flags.String("publicURI", "", "URI where this service is reachable like myhome.example.com")
 	letsencryptCache := flags.String("letsencryptCache", "", "Folder where letsencrypt can store cached data")
+	authKeyHeader := flags.String("authKeyHeader", "", "HTTP header that can carry the auth key")
+	stateFile := flags.String("stateFile", "", "File that keeps the use counts of auth keys")
+	rateLimitIP := flags.Int("rateLimitIP", 0, "Requests per minute per client IP (0 disables)")
+	rateBurstIP := flags.Int("rateBurstIP", 0, "Requests a client IP may send at once")
+	rateLimitKey := flags.Int("rateLimitKey", 0, "Default requests per minute per auth key (0 disables)")
+	rateBurstKey := flags.Int("rateBurstKey", 0, "Default requests an auth key may send at once")
+	rateLimitControl := flags.Int("rateLimitControl", 0, "Default requests per minute per control (0 disables)")
+	rateBurstControl := flags.Int("rateBurstControl", 0, "Default requests a control accepts at once")
+	banThreshold := flags.Int("banThreshold", 0, "Unknown auth keys after which a client IP is banned (0 disables)")
+	banTime := flags.Int("banTime", 0, "Seconds a client IP stays banned")
+	limiterMaxEntries := flags.Int("limiterMaxEntries", 0, "Maximum number of tracked IPs, keys and controls per limiter")
+	adminListenAddress := flags.String("adminListenAddress", "", "Address of the admin listener like 127.0.0.1:9091")
+	metricsListenAddress := flags.String("metricsListenAddress", "", "Address of the Prometheus metrics listener like 127.0.0.1:9100")
+	trustedProxies := flags.String("trustedProxies", "", "Comma separated networks of proxies whose X-Forwarded-For and Forwarded headers are trusted")
+	clientCAFile := flags.String("clientCAFile", "", "PEM file with the CA certificates that sign client certificates")
+	listenMode := flags.String("listenMode", "", "How to accept connections: autocert, tls or http")
+	shutdownTimeout := flags.Int("shutdownTimeout", 0, "Seconds to wait for running requests on shutdown")
+	listenAddress := flags.String("listenAddress", "", "Address to listen on like 127.0.0.1:8080 or unix:/run/loxwebhook.sock. Overrides listenport")
+	tlsCertFile := flags.String("tlsCertFile", "", "PEM file with the certificate chain for listen mode tls")
+	tlsKeyFile := flags.String("tlsKeyFile", "", "PEM file with the private key for listen mode tls")
+	pathPrefix := flags.String("pathPrefix", "", "URL path prefix of all routes like /lox")
+	acmeDirectoryURL := flags.String("acmeDirectoryURL", "", "Directory URL of the ACME CA. Default: Let's Encrypt")
+	acmeCAFile := flags.String("acmeCAFile", "", "PEM file with the CA certificates of the ACME server")
+	acmeEABKeyID := flags.String("acmeEABKeyID", "", "Key id of the external account binding")
+	acmeEABHMACKey := flags.String("acmeEABHMACKey", "", "Base64url encoded HMAC key of the external account binding")
+	acmeChallenge := flags.String("acmeChallenge", "", "ACME challenge: tls-alpn-01 or dns-01")
+	acmeDomains := flags.String("acmeDomains", "", "Comma separated names of the certificate for the dns-01 challenge")
+	acmeDNSProvider := flags.String("acmeDNSProvider", "", "Provider of the dns-01 TXT records: rfc2136 or exec")
+	acmeDNSServer := flags.String("acmeDNSServer", "", "rfc2136 only: DNS server that accepts updates like ns1.example.com:53")
+	acmeDNSKeyFile := flags.String("acmeDNSKeyFile", "", "rfc2136 only: TSIG key file for nsupdate -k")
+	acmeDNSCommand := flags.String("acmeDNSCommand", "", "exec only: Command that creates and removes TXT records")
+	acmeDNSDelay := flags.Int("acmeDNSDelay", 0, "Seconds to wait for the TXT record to reach all DNS servers")
 	miniserverURL := flags.String("miniserverURL", "", "Miniserver URL like http://192.168.1.2:80")
 	miniserverUser := flags.String("miniserverUser", "", "Miniserver user")
 	miniserverPassword := flags.String("miniserverPassword", "", "Miniserver password")
 	miniserverTimeout := flags.Int("miniserverTimeout", 0, "Timeout for requests to the Miniserver")
+	miniserverAuth := flags.String("miniserverAuth", "", "Authentication against the Miniserver (token or basic)")
+	miniserverTransport := flags.String("miniserverTransport", "", "Connection to the Miniserver (http or websocket)")
+	miniserverEncryption := flags.String("miniserverEncryption", "", "Encryption of commands to the Miniserver (none, enc or fenc)")
+	miniserverTLSSkipVerify := flags.Bool("miniserverTLSSkipVerify", false, "Do not verify the TLS certificate of the Miniserver")
+	miniserverTLSCAFile := flags.String("miniserverTLSCAFile", "", "CA certificate file to verify the TLS certificate of the Miniserver")
 	flags.Parse(os.Args[1:])
 	if *versionFlag {
 		fmt.Printf("Version  : %s\n", versionStr)
 		fmt.Printf("Build for: %s\n", buildForOS)
 		os.Exit(0)
 	}
+	// Limits may be set to 0, which is also the default of the flags.
+	// Only flags that were passed change them.
+	passed := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
 	cfg := newDefaultConfig()
 	if *config != "" {
 		cfg.ConfigFile = *config
@@ -311,9 +925,18 @@ func newFlagConfig(versionStr string) *basicTypeConfig {
 	if *logFileHTTPAccess != "" {
 		cfg.LogFileHTTPAccess = *logFileHTTPAccess
 	}
+	if *logFormat != "" {
+		cfg.LogFormat = *logFormat
+	}
+	if *logLevel != "" {
+		cfg.LogLevel = *logLevel
+	}
 	if *controlsFiles != "" {
 		cfg.ControlsFiles = *controlsFiles
 	}
+	if *controlsWatch {
+		cfg.ControlsWatch = *controlsWatch
+	}
 	if *listenPort != 65535 {
 		cfg.ListenPort = *listenPort
 	}
@@ -323,6 +946,102 @@ func newFlagConfig(versionStr string) *basicTypeConfig {
 	if *letsencryptCache != "" {
 		cfg.LetsEncryptCache = *letsencryptCache
 	}
+	if *authKeyHeader != "" {
+		cfg.AuthKeyHeader = *authKeyHeader
+	}
+	if *stateFile != "" {
+		cfg.StateFile = *stateFile
+	}
+	if passed["rateLimitIP"] {
+		cfg.RateLimitIP = *rateLimitIP
+	}
+	if passed["rateBurstIP"] {
+		cfg.RateBurstIP = *rateBurstIP
+	}
+	if passed["rateLimitKey"] {
+		cfg.RateLimitKey = *rateLimitKey
+	}
+	if passed["rateBurstKey"] {
+		cfg.RateBurstKey = *rateBurstKey
+	}
+	if passed["rateLimitControl"] {
+		cfg.RateLimitControl = *rateLimitControl
+	}
+	if passed["rateBurstControl"] {
+		cfg.RateBurstControl = *rateBurstControl
+	}
+	if passed["banThreshold"] {
+		cfg.BanThreshold = *banThreshold
+	}
+	if passed["banTime"] {
+		cfg.BanTime = *banTime
+	}
+	if passed["limiterMaxEntries"] {
+		cfg.LimiterMaxEntries = *limiterMaxEntries
+	}
+	if *adminListenAddress != "" {
+		cfg.AdminListenAddress = *adminListenAddress
+	}
+	if *metricsListenAddress != "" {
+		cfg.MetricsListenAddress = *metricsListenAddress
+	}
+	if *trustedProxies != "" {
+		cfg.TrustedProxies = splitList(*trustedProxies)
+	}
+	if *clientCAFile != "" {
+		cfg.ClientCAFile = *clientCAFile
+	}
+	if *listenMode != "" {
+		cfg.ListenMode = *listenMode
+	}
+	if *shutdownTimeout != 0 {
+		cfg.ShutdownTimeout = *shutdownTimeout
+	}
+	if *listenAddress != "" {
+		cfg.ListenAddress = *listenAddress
+	}
+	if *tlsCertFile != "" {
+		cfg.TLSCertFile = *tlsCertFile
+	}
+	if *tlsKeyFile != "" {
+		cfg.TLSKeyFile = *tlsKeyFile
+	}
+	if *pathPrefix != "" {
+		cfg.PathPrefix = *pathPrefix
+	}
+	if *acmeDirectoryURL != "" {
+		cfg.ACMEDirectoryURL = *acmeDirectoryURL
+	}
+	if *acmeCAFile != "" {
+		cfg.ACMECAFile = *acmeCAFile
+	}
+	if *acmeEABKeyID != "" {
+		cfg.ACMEEABKeyID = *acmeEABKeyID
+	}
+	if *acmeEABHMACKey != "" {
+		cfg.ACMEEABHMACKey = *acmeEABHMACKey
+	}
+	if *acmeChallenge != "" {
+		cfg.ACMEChallenge = *acmeChallenge
+	}
+	if *acmeDomains != "" {
+		cfg.ACMEDomains = splitList(*acmeDomains)
+	}
+	if *acmeDNSProvider != "" {
+		cfg.ACMEDNSProvider = *acmeDNSProvider
+	}
+	if *acmeDNSServer != "" {
+		cfg.ACMEDNSServer = *acmeDNSServer
+	}
+	if *acmeDNSKeyFile != "" {
+		cfg.ACMEDNSKeyFile = *acmeDNSKeyFile
+	}
+	if *acmeDNSCommand != "" {
+		cfg.ACMEDNSCommand = *acmeDNSCommand
+	}
+	if *acmeDNSDelay != 0 {
+		cfg.ACMEDNSDelay = *acmeDNSDelay
+	}
 	if *miniserverURL != "" {
 		cfg.MiniserverURL = *miniserverURL
 	}
@@ -335,6 +1054,21 @@ func newFlagConfig(versionStr string) *basicTypeConfig {
 	if *miniserverTimeout != 0 {
 		cfg.MiniserverTimeout = *miniserverTimeout
 	}
+	if *miniserverAuth != "" {
+		cfg.MiniserverAuth = *miniserverAuth
+	}
+	if *miniserverTransport != "" {
+		cfg.MiniserverTransport = *miniserverTransport
+	}
+	if *miniserverEncryption != "" {
+		cfg.MiniserverEncryption = *miniserverEncryption
+	}
+	if *miniserverTLSSkipVerify {
+		cfg.MiniserverTLSSkipVerify = *miniserverTLSSkipVerify
+	}
+	if *miniserverTLSCAFile != "" {
+		cfg.MiniserverTLSCAFile = *miniserverTLSCAFile
+	}
 	return cfg
 }
 
@@ -388,9 +1122,18 @@ func mergeConfig(cfg *basicTypeConfig, c *basicTypeConfig) {
 	if c.LogFileHTTPAccess != defCfg.LogFileHTTPAccess {
 		cfg.LogFileHTTPAccess = c.LogFileHTTPAccess
 	}
+	if c.LogFormat != defCfg.LogFormat {
+		cfg.LogFormat = c.LogFormat
+	}
+	if c.LogLevel != defCfg.LogLevel {
+		cfg.LogLevel = c.LogLevel
+	}
 	if c.ControlsFiles != defCfg.ControlsFiles {
 		cfg.ControlsFiles = c.ControlsFiles
 	}
+	if c.ControlsWatch != defCfg.ControlsWatch {
+		cfg.ControlsWatch = c.ControlsWatch
+	}
 	if c.ListenPort != defCfg.ListenPort {
 		cfg.ListenPort = c.ListenPort
 	}
@@ -403,6 +1146,102 @@ func mergeConfig(cfg *basicTypeConfig, c *basicTypeConfig) {
 	if c.ControlsFiles != defCfg.ControlsFiles {
 		cfg.ControlsFiles = c.ControlsFiles
 	}
+	if c.AuthKeyHeader != defCfg.AuthKeyHeader {
+		cfg.AuthKeyHeader = c.AuthKeyHeader
+	}
+	if c.StateFile != defCfg.StateFile {
+		cfg.StateFile = c.StateFile
+	}
+	if c.RateLimitIP != defCfg.RateLimitIP {
+		cfg.RateLimitIP = c.RateLimitIP
+	}
+	if c.RateBurstIP != defCfg.RateBurstIP {
+		cfg.RateBurstIP = c.RateBurstIP
+	}
+	if c.RateLimitKey != defCfg.RateLimitKey {
+		cfg.RateLimitKey = c.RateLimitKey
+	}
+	if c.RateBurstKey != defCfg.RateBurstKey {
+		cfg.RateBurstKey = c.RateBurstKey
+	}
+	if c.RateLimitControl != defCfg.RateLimitControl {
+		cfg.RateLimitControl = c.RateLimitControl
+	}
+	if c.RateBurstControl != defCfg.RateBurstControl {
+		cfg.RateBurstControl = c.RateBurstControl
+	}
+	if c.BanThreshold != defCfg.BanThreshold {
+		cfg.BanThreshold = c.BanThreshold
+	}
+	if c.BanTime != defCfg.BanTime {
+		cfg.BanTime = c.BanTime
+	}
+	if c.LimiterMaxEntries != defCfg.LimiterMaxEntries {
+		cfg.LimiterMaxEntries = c.LimiterMaxEntries
+	}
+	if c.AdminListenAddress != defCfg.AdminListenAddress {
+		cfg.AdminListenAddress = c.AdminListenAddress
+	}
+	if c.MetricsListenAddress != defCfg.MetricsListenAddress {
+		cfg.MetricsListenAddress = c.MetricsListenAddress
+	}
+	if c.TrustedProxies != nil {
+		cfg.TrustedProxies = c.TrustedProxies
+	}
+	if c.ClientCAFile != defCfg.ClientCAFile {
+		cfg.ClientCAFile = c.ClientCAFile
+	}
+	if c.ListenMode != defCfg.ListenMode {
+		cfg.ListenMode = c.ListenMode
+	}
+	if c.ShutdownTimeout != defCfg.ShutdownTimeout {
+		cfg.ShutdownTimeout = c.ShutdownTimeout
+	}
+	if c.ListenAddress != defCfg.ListenAddress {
+		cfg.ListenAddress = c.ListenAddress
+	}
+	if c.TLSCertFile != defCfg.TLSCertFile {
+		cfg.TLSCertFile = c.TLSCertFile
+	}
+	if c.TLSKeyFile != defCfg.TLSKeyFile {
+		cfg.TLSKeyFile = c.TLSKeyFile
+	}
+	if c.PathPrefix != defCfg.PathPrefix {
+		cfg.PathPrefix = c.PathPrefix
+	}
+	if c.ACMEDirectoryURL != defCfg.ACMEDirectoryURL {
+		cfg.ACMEDirectoryURL = c.ACMEDirectoryURL
+	}
+	if c.ACMECAFile != defCfg.ACMECAFile {
+		cfg.ACMECAFile = c.ACMECAFile
+	}
+	if c.ACMEEABKeyID != defCfg.ACMEEABKeyID {
+		cfg.ACMEEABKeyID = c.ACMEEABKeyID
+	}
+	if c.ACMEEABHMACKey != defCfg.ACMEEABHMACKey {
+		cfg.ACMEEABHMACKey = c.ACMEEABHMACKey
+	}
+	if c.ACMEChallenge != defCfg.ACMEChallenge {
+		cfg.ACMEChallenge = c.ACMEChallenge
+	}
+	if c.ACMEDomains != nil {
+		cfg.ACMEDomains = c.ACMEDomains
+	}
+	if c.ACMEDNSProvider != defCfg.ACMEDNSProvider {
+		cfg.ACMEDNSProvider = c.ACMEDNSProvider
+	}
+	if c.ACMEDNSServer != defCfg.ACMEDNSServer {
+		cfg.ACMEDNSServer = c.ACMEDNSServer
+	}
+	if c.ACMEDNSKeyFile != defCfg.ACMEDNSKeyFile {
+		cfg.ACMEDNSKeyFile = c.ACMEDNSKeyFile
+	}
+	if c.ACMEDNSCommand != defCfg.ACMEDNSCommand {
+		cfg.ACMEDNSCommand = c.ACMEDNSCommand
+	}
+	if c.ACMEDNSDelay != defCfg.ACMEDNSDelay {
+		cfg.ACMEDNSDelay = c.ACMEDNSDelay
+	}
 	if c.MiniserverURL != defCfg.MiniserverURL {
 		cfg.MiniserverURL = c.MiniserverURL
 	}
@@ -415,6 +1254,27 @@ func mergeConfig(cfg *basicTypeConfig, c *basicTypeConfig) {
 	if c.MiniserverTimeout != defCfg.MiniserverTimeout {
 		cfg.MiniserverTimeout = c.MiniserverTimeout
 	}
+	if c.MiniserverAuth != defCfg.MiniserverAuth {
+		cfg.MiniserverAuth = c.MiniserverAuth
+	}
+	if c.MiniserverTransport != defCfg.MiniserverTransport {
+		cfg.MiniserverTransport = c.MiniserverTransport
+	}
+	if c.MiniserverEncryption != defCfg.MiniserverEncryption {
+		cfg.MiniserverEncryption = c.MiniserverEncryption
+	}
+	if c.MiniserverTLSSkipVerify != defCfg.MiniserverTLSSkipVerify {
+		cfg.MiniserverTLSSkipVerify = c.MiniserverTLSSkipVerify
+	}
+	if c.MiniserverTLSCAFile != defCfg.MiniserverTLSCAFile {
+		cfg.MiniserverTLSCAFile = c.MiniserverTLSCAFile
+	}
+	if len(c.Miniservers) > 0 {
+		cfg.Miniservers = c.Miniservers
+	}
+	if len(c.PublicHosts) > 0 {
+		cfg.PublicHosts = c.PublicHosts
+	}
 	return
 }
 
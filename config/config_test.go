@@ -1,7 +1,9 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"os"
 	"reflect"
@@ -45,19 +47,39 @@ func TestNewConfig(t *testing.T) {
 	testingVersionNumber := "0.0.0"
 
 	configDefaults := Config{
-		Version:            testingVersionNumber,
-		ConfigFile:         "",
-		PublicURI:          "",
-		ListenPort:         4443,
-		MiniserverURL:      new(url.URL),
-		MiniserverUser:     "admin",
-		MiniserverPassword: "admin",
-		MiniserverTimeout:  2 * time.Second,
-		LetsEncryptCache:   "./cache/letsencrypt",
-		LogFileMain:        "",
-		LogFileHTTPError:   "",
-		LogFileHTTPAccess:  "",
-		ControlsFiles:      "./controls.d",
+		Version:              testingVersionNumber,
+		ConfigFile:           "",
+		PublicURI:            "",
+		ListenPort:           4443,
+		MiniserverURL:        new(url.URL),
+		MiniserverUser:       "admin",
+		MiniserverPassword:   "admin",
+		MiniserverTimeout:    2 * time.Second,
+		MiniserverAuth:       "token",
+		MiniserverTransport:  "http",
+		MiniserverEncryption: "none",
+		LetsEncryptCache:     "./cache/letsencrypt",
+		LogFileMain:          "",
+		LogFileHTTPError:     "",
+		LogFileHTTPAccess:    "",
+		LogFormat:            "logfmt",
+		LogLevel:             "info",
+		ControlsFiles:        "./controls.d",
+		AuthKeyHeader:        "X-Loxwebhook-AuthKey",
+		StateFile:            "./state/state.json",
+		RateLimitIP:          60,
+		RateBurstIP:          10,
+		RateLimitKey:         60,
+		RateBurstKey:         5,
+		RateLimitControl:     60,
+		RateBurstControl:     3,
+		BanThreshold:         10,
+		BanTime:              900,
+		LimiterMaxEntries:    10000,
+		ListenMode:           "autocert",
+		ShutdownTimeout:      10,
+		ACMEChallenge:        "tls-alpn-01",
+		ACMEDNSDelay:         60,
 	}
 
 	configFileExample := Config{
@@ -69,14 +91,46 @@ func TestNewConfig(t *testing.T) {
 			Scheme: "http",
 			Host:   "192.168.1.1:80",
 		},
-		MiniserverUser:     "loxwebhook",
-		MiniserverPassword: "YourSecretPassword",
-		MiniserverTimeout:  2 * time.Second,
-		LetsEncryptCache:   "/home/loxwebhook/loxwebhook/cache/letsencrypt",
-		LogFileMain:        "/var/log/loxwebhook/loxwebhook.log",
-		LogFileHTTPError:   "/var/log/loxwebhook/error.log",
-		LogFileHTTPAccess:  "/var/log/loxwebhook/access.log",
-		ControlsFiles:      "/etc/loxwebhook/controls.d",
+		MiniserverUser:       "loxwebhook",
+		MiniserverPassword:   "YourSecretPassword",
+		MiniserverTimeout:    2 * time.Second,
+		MiniserverAuth:       "token",
+		MiniserverTransport:  "http",
+		MiniserverEncryption: "none",
+		LetsEncryptCache:     "/home/loxwebhook/loxwebhook/cache/letsencrypt",
+		LogFileMain:          "/var/log/loxwebhook/loxwebhook.log",
+		LogFileHTTPError:     "/var/log/loxwebhook/error.log",
+		LogFileHTTPAccess:    "/var/log/loxwebhook/access.log",
+		LogFormat:            "json",
+		LogLevel:             "warn",
+		ControlsFiles:        "/etc/loxwebhook/controls.d",
+		ControlsWatch:        true,
+		AuthKeyHeader:        "X-Api-Key",
+		StateFile:            "/var/lib/loxwebhook/state.json",
+		RateLimitIP:          120,
+		RateBurstIP:          20,
+		RateLimitKey:         30,
+		RateBurstKey:         6,
+		RateLimitControl:     40,
+		RateBurstControl:     4,
+		BanThreshold:         5,
+		BanTime:              600,
+		LimiterMaxEntries:    5000,
+		AdminListenAddress:   "127.0.0.1:9091",
+		MetricsListenAddress: "127.0.0.1:9100",
+		TrustedProxies:       []string{"192.168.1.10", "fd00::/64"},
+		ClientCAFile:         "/etc/loxwebhook/client-ca.pem",
+		ListenMode:           "autocert",
+		ShutdownTimeout:      15,
+		TLSCertFile:          "/etc/loxwebhook/tls/fullchain.pem",
+		TLSKeyFile:           "/etc/loxwebhook/tls/privkey.pem",
+		ACMEDirectoryURL:     "https://acme-v02.api.letsencrypt.org/directory",
+		ACMEChallenge:        "tls-alpn-01",
+		ACMEDomains:          []string{"loxwebhook.example.com"},
+		ACMEDNSProvider:      "rfc2136",
+		ACMEDNSServer:        "ns1.example.com:53",
+		ACMEDNSKeyFile:       "/etc/loxwebhook/acme-tsig.key",
+		ACMEDNSDelay:         60,
 	}
 
 	configEnv := Config{
@@ -88,28 +142,108 @@ func TestNewConfig(t *testing.T) {
 			Scheme: "http",
 			Host:   "192.168.1.81:80",
 		},
-		MiniserverUser:     "userEnv",
-		MiniserverPassword: "env",
-		MiniserverTimeout:  81 * time.Second,
-		LetsEncryptCache:   "./cache/letsencrypt/env",
-		LogFileMain:        "/var/log/envLogFileMain.log",
-		LogFileHTTPError:   "/var/log/envLogFileHTTPError.log",
-		LogFileHTTPAccess:  "/var/log/envLogFileHTTPAccess.log",
-		ControlsFiles:      "./controls_env.d",
+		MiniserverUser:          "userEnv",
+		MiniserverPassword:      "env",
+		MiniserverTimeout:       81 * time.Second,
+		MiniserverAuth:          "basic",
+		MiniserverTransport:     "websocket",
+		MiniserverEncryption:    "fenc",
+		MiniserverTLSSkipVerify: true,
+		MiniserverTLSCAFile:     "/etc/loxwebhook/env-ca.pem",
+		LetsEncryptCache:        "./cache/letsencrypt/env",
+		LogFileMain:             "/var/log/envLogFileMain.log",
+		LogFileHTTPError:        "/var/log/envLogFileHTTPError.log",
+		LogFileHTTPAccess:       "/var/log/envLogFileHTTPAccess.log",
+		LogFormat:               "json",
+		LogLevel:                "debug",
+		ControlsFiles:           "./controls_env.d",
+		ControlsWatch:           true,
+		AuthKeyHeader:           "X-Env-Key",
+		StateFile:               "./state/env.json",
+		RateLimitIP:             121,
+		RateBurstIP:             21,
+		RateLimitKey:            31,
+		RateBurstKey:            7,
+		RateLimitControl:        41,
+		RateBurstControl:        5,
+		BanThreshold:            6,
+		BanTime:                 601,
+		LimiterMaxEntries:       5001,
+		AdminListenAddress:      "127.0.0.1:9092",
+		MetricsListenAddress:    "127.0.0.1:9101",
+		TrustedProxies:          []string{"10.0.0.0/8"},
+		ClientCAFile:            "/env/client-ca.pem",
+		ListenMode:              "tls",
+		ShutdownTimeout:         20,
+		ListenAddress:           "127.0.0.1:8081",
+		TLSCertFile:             "/env/fullchain.pem",
+		TLSKeyFile:              "/env/privkey.pem",
+		PathPrefix:              "/env",
+		ACMEDirectoryURL:        "https://localhost:14000/dir",
+		ACMECAFile:              "/env/pebble.minica.pem",
+		ACMEEABKeyID:            "env-kid",
+		ACMEEABHMACKey:          "ZW52LWhtYWMta2V5",
+		ACMEChallenge:           "dns-01",
+		ACMEDomains:             []string{"*.env.example.com"},
+		ACMEDNSProvider:         "exec",
+		ACMEDNSServer:           "192.0.2.53",
+		ACMEDNSKeyFile:          "/env/tsig.key",
+		ACMEDNSCommand:          "/env/dns-hook",
+		ACMEDNSDelay:            30,
 	}
 
 	allEnv := map[string]string{
-		"LOGFILEMAIN":        configEnv.LogFileMain,
-		"LOGFILEHTTPERROR":   configEnv.LogFileHTTPError,
-		"LOGFILEHTTPACCESS":  configEnv.LogFileHTTPAccess,
-		"LISTENPORT":         strconv.Itoa(configEnv.ListenPort),
-		"PUBLICURI":          configEnv.PublicURI,
-		"LETSENCRYPTCACHE":   configEnv.LetsEncryptCache,
-		"CONTROLSFILES":      configEnv.ControlsFiles,
-		"MINISERVERURL":      configEnv.MiniserverURL.String(),
-		"MINISERVERUSER":     configEnv.MiniserverUser,
-		"MINISERVERPASSWORD": configEnv.MiniserverPassword,
-		"MINISERVERTIMEOUT":  fmt.Sprint(configEnv.MiniserverTimeout.Seconds()),
+		"LOGFILEMAIN":             configEnv.LogFileMain,
+		"LOGFILEHTTPERROR":        configEnv.LogFileHTTPError,
+		"LOGFILEHTTPACCESS":       configEnv.LogFileHTTPAccess,
+		"LOGFORMAT":               configEnv.LogFormat,
+		"LOGLEVEL":                configEnv.LogLevel,
+		"LISTENPORT":              strconv.Itoa(configEnv.ListenPort),
+		"PUBLICURI":               configEnv.PublicURI,
+		"LETSENCRYPTCACHE":        configEnv.LetsEncryptCache,
+		"CONTROLSFILES":           configEnv.ControlsFiles,
+		"CONTROLSWATCH":           strconv.FormatBool(configEnv.ControlsWatch),
+		"AUTHKEYHEADER":           configEnv.AuthKeyHeader,
+		"STATEFILE":               configEnv.StateFile,
+		"RATELIMITIP":             strconv.Itoa(configEnv.RateLimitIP),
+		"RATEBURSTIP":             strconv.Itoa(configEnv.RateBurstIP),
+		"RATELIMITKEY":            strconv.Itoa(configEnv.RateLimitKey),
+		"RATEBURSTKEY":            strconv.Itoa(configEnv.RateBurstKey),
+		"RATELIMITCONTROL":        strconv.Itoa(configEnv.RateLimitControl),
+		"RATEBURSTCONTROL":        strconv.Itoa(configEnv.RateBurstControl),
+		"BANTHRESHOLD":            strconv.Itoa(configEnv.BanThreshold),
+		"BANTIME":                 strconv.Itoa(configEnv.BanTime),
+		"LIMITERMAXENTRIES":       strconv.Itoa(configEnv.LimiterMaxEntries),
+		"ADMINLISTENADDRESS":      configEnv.AdminListenAddress,
+		"METRICSLISTENADDRESS":    configEnv.MetricsListenAddress,
+		"TRUSTEDPROXIES":          strings.Join(configEnv.TrustedProxies, ","),
+		"CLIENTCAFILE":            configEnv.ClientCAFile,
+		"LISTENMODE":              configEnv.ListenMode,
+		"SHUTDOWNTIMEOUT":         strconv.Itoa(configEnv.ShutdownTimeout),
+		"LISTENADDRESS":           configEnv.ListenAddress,
+		"TLSCERTFILE":             configEnv.TLSCertFile,
+		"TLSKEYFILE":              configEnv.TLSKeyFile,
+		"PATHPREFIX":              configEnv.PathPrefix,
+		"ACMEDIRECTORYURL":        configEnv.ACMEDirectoryURL,
+		"ACMECAFILE":              configEnv.ACMECAFile,
+		"ACMEEABKEYID":            configEnv.ACMEEABKeyID,
+		"ACMEEABHMACKEY":          configEnv.ACMEEABHMACKey,
+		"ACMECHALLENGE":           configEnv.ACMEChallenge,
+		"ACMEDOMAINS":             strings.Join(configEnv.ACMEDomains, ","),
+		"ACMEDNSPROVIDER":         configEnv.ACMEDNSProvider,
+		"ACMEDNSSERVER":           configEnv.ACMEDNSServer,
+		"ACMEDNSKEYFILE":          configEnv.ACMEDNSKeyFile,
+		"ACMEDNSCOMMAND":          configEnv.ACMEDNSCommand,
+		"ACMEDNSDELAY":            strconv.Itoa(configEnv.ACMEDNSDelay),
+		"MINISERVERURL":           configEnv.MiniserverURL.String(),
+		"MINISERVERUSER":          configEnv.MiniserverUser,
+		"MINISERVERPASSWORD":      configEnv.MiniserverPassword,
+		"MINISERVERTIMEOUT":       fmt.Sprint(configEnv.MiniserverTimeout.Seconds()),
+		"MINISERVERAUTH":          configEnv.MiniserverAuth,
+		"MINISERVERTRANSPORT":     configEnv.MiniserverTransport,
+		"MINISERVERENCRYPTION":    configEnv.MiniserverEncryption,
+		"MINISERVERTLSSKIPVERIFY": strconv.FormatBool(configEnv.MiniserverTLSSkipVerify),
+		"MINISERVERTLSCAFILE":     configEnv.MiniserverTLSCAFile,
 	}
 
 	configFlag := Config{
@@ -121,14 +255,54 @@ func TestNewConfig(t *testing.T) {
 			Scheme: "http",
 			Host:   "192.168.1.82:80",
 		},
-		MiniserverUser:     "userFlag",
-		MiniserverPassword: "flag",
-		MiniserverTimeout:  82 * time.Second,
-		LetsEncryptCache:   "./cache/letsencrypt/flag",
-		LogFileMain:        "/var/log/flagLogFileMain.log",
-		LogFileHTTPError:   "/var/log/flagLogFileHTTPError.log",
-		LogFileHTTPAccess:  "/var/log/flagLogFileHTTPAccess.log",
-		ControlsFiles:      "./controls_flag.d",
+		MiniserverUser:          "userFlag",
+		MiniserverPassword:      "flag",
+		MiniserverTimeout:       82 * time.Second,
+		MiniserverAuth:          "basic",
+		MiniserverTransport:     "websocket",
+		MiniserverEncryption:    "enc",
+		MiniserverTLSSkipVerify: true,
+		MiniserverTLSCAFile:     "/etc/loxwebhook/flag-ca.pem",
+		LetsEncryptCache:        "./cache/letsencrypt/flag",
+		LogFileMain:             "/var/log/flagLogFileMain.log",
+		LogFileHTTPError:        "/var/log/flagLogFileHTTPError.log",
+		LogFileHTTPAccess:       "/var/log/flagLogFileHTTPAccess.log",
+		LogFormat:               "json",
+		LogLevel:                "error",
+		ControlsFiles:           "./controls_flag.d",
+		ControlsWatch:           true,
+		AuthKeyHeader:           "X-Flag-Key",
+		StateFile:               "./state/flag.json",
+		RateLimitIP:             122,
+		RateBurstIP:             22,
+		RateLimitKey:            32,
+		RateBurstKey:            8,
+		RateLimitControl:        42,
+		RateBurstControl:        6,
+		BanThreshold:            7,
+		BanTime:                 602,
+		LimiterMaxEntries:       5002,
+		AdminListenAddress:      "127.0.0.1:9093",
+		MetricsListenAddress:    "127.0.0.1:9102",
+		TrustedProxies:          []string{"172.16.0.1", "172.16.0.2"},
+		ClientCAFile:            "/flag/client-ca.pem",
+		ListenMode:              "http",
+		ShutdownTimeout:         30,
+		ListenAddress:           "unix:/run/loxwebhook/loxwebhook.sock",
+		TLSCertFile:             "/flag/fullchain.pem",
+		TLSKeyFile:              "/flag/privkey.pem",
+		PathPrefix:              "/flag/hooks",
+		ACMEDirectoryURL:        "https://acme.example.com/directory",
+		ACMECAFile:              "/flag/acme-ca.pem",
+		ACMEEABKeyID:            "flag-kid",
+		ACMEEABHMACKey:          "ZmxhZy1obWFjLWtleQ",
+		ACMEChallenge:           "dns-01",
+		ACMEDomains:             []string{"flag.example.com", "*.flag.example.com"},
+		ACMEDNSProvider:         "rfc2136",
+		ACMEDNSServer:           "ns.flag.example.com:5353",
+		ACMEDNSKeyFile:          "/flag/tsig.key",
+		ACMEDNSCommand:          "/flag/dns-hook",
+		ACMEDNSDelay:            90,
 	}
 
 	allFlags := []string{
@@ -136,15 +310,68 @@ func TestNewConfig(t *testing.T) {
 		"-logfilemain", configFlag.LogFileMain,
 		"-logfilehttperror", configFlag.LogFileHTTPError,
 		"-logfilehttpaccess", configFlag.LogFileHTTPAccess,
+		"-logFormat", configFlag.LogFormat,
+		"-logLevel", configFlag.LogLevel,
 		"-listenport", strconv.Itoa(configFlag.ListenPort),
 		"-publicURI", configFlag.PublicURI,
 		"-letsencryptCache", configFlag.LetsEncryptCache,
 		"-controlsfiles", configFlag.ControlsFiles,
+		"-controlsWatch=" + strconv.FormatBool(configFlag.ControlsWatch),
+		"-authKeyHeader", configFlag.AuthKeyHeader,
+		"-stateFile", configFlag.StateFile,
+		"-rateLimitIP", strconv.Itoa(configFlag.RateLimitIP),
+		"-rateBurstIP", strconv.Itoa(configFlag.RateBurstIP),
+		"-rateLimitKey", strconv.Itoa(configFlag.RateLimitKey),
+		"-rateBurstKey", strconv.Itoa(configFlag.RateBurstKey),
+		"-rateLimitControl", strconv.Itoa(configFlag.RateLimitControl),
+		"-rateBurstControl", strconv.Itoa(configFlag.RateBurstControl),
+		"-banThreshold", strconv.Itoa(configFlag.BanThreshold),
+		"-banTime", strconv.Itoa(configFlag.BanTime),
+		"-limiterMaxEntries", strconv.Itoa(configFlag.LimiterMaxEntries),
+		"-adminListenAddress", configFlag.AdminListenAddress,
+		"-metricsListenAddress", configFlag.MetricsListenAddress,
+		"-trustedProxies", strings.Join(configFlag.TrustedProxies, ","),
+		"-clientCAFile", configFlag.ClientCAFile,
+		"-listenMode", configFlag.ListenMode,
+		"-shutdownTimeout", strconv.Itoa(configFlag.ShutdownTimeout),
+		"-listenAddress", configFlag.ListenAddress,
+		"-tlsCertFile", configFlag.TLSCertFile,
+		"-tlsKeyFile", configFlag.TLSKeyFile,
+		"-pathPrefix", configFlag.PathPrefix,
+		"-acmeDirectoryURL", configFlag.ACMEDirectoryURL,
+		"-acmeCAFile", configFlag.ACMECAFile,
+		"-acmeEABKeyID", configFlag.ACMEEABKeyID,
+		"-acmeEABHMACKey", configFlag.ACMEEABHMACKey,
+		"-acmeChallenge", configFlag.ACMEChallenge,
+		"-acmeDomains", strings.Join(configFlag.ACMEDomains, ","),
+		"-acmeDNSProvider", configFlag.ACMEDNSProvider,
+		"-acmeDNSServer", configFlag.ACMEDNSServer,
+		"-acmeDNSKeyFile", configFlag.ACMEDNSKeyFile,
+		"-acmeDNSCommand", configFlag.ACMEDNSCommand,
+		"-acmeDNSDelay", strconv.Itoa(configFlag.ACMEDNSDelay),
 		"-miniserverURL", configFlag.MiniserverURL.String(),
 		"-miniserverUser", configFlag.MiniserverUser,
 		"-miniserverPassword", configFlag.MiniserverPassword,
 		"-miniserverTimeout", fmt.Sprint(configFlag.MiniserverTimeout.Seconds()),
+		"-miniserverAuth", configFlag.MiniserverAuth,
+		"-miniserverTransport", configFlag.MiniserverTransport,
+		"-miniserverEncryption", configFlag.MiniserverEncryption,
+		"-miniserverTLSSkipVerify=" + strconv.FormatBool(configFlag.MiniserverTLSSkipVerify),
+		"-miniserverTLSCAFile", configFlag.MiniserverTLSCAFile,
 	}
+	// 0 disables limits although it is the default of the flags
+	configNoLimits := configDefaults
+	configNoLimits.RateLimitIP = 0
+	configNoLimits.RateLimitKey = 0
+	configNoLimits.RateLimitControl = 0
+	configNoLimits.BanThreshold = 0
+	configZeroFlags := configDefaults
+	configZeroFlags.RateBurstIP = 0
+	configZeroFlags.RateBurstKey = 0
+	configZeroFlags.RateBurstControl = 0
+	configZeroFlags.BanTime = 0
+	configZeroFlags.LimiterMaxEntries = 0
+
 	type args struct {
 		configFile *string
 	}
@@ -176,6 +403,29 @@ func TestNewConfig(t *testing.T) {
 			flags:   allFlags,
 			wantCfg: configFlag,
 		},
+		{
+			name: "FlagsDisableLimits",
+			flags: []string{
+				os.Args[0],
+				"-rateLimitIP", "0",
+				"-rateLimitKey", "0",
+				"-rateLimitControl", "0",
+				"-banThreshold", "0",
+			},
+			wantCfg: configNoLimits,
+		},
+		{
+			name: "FlagsZero",
+			flags: []string{
+				os.Args[0],
+				"-rateBurstIP", "0",
+				"-rateBurstKey", "0",
+				"-rateBurstControl", "0",
+				"-banTime", "0",
+				"-limiterMaxEntries", "0",
+			},
+			wantCfg: configZeroFlags,
+		},
 		{
 			name: "EnvConfigFile",
 			flags: []string{
@@ -211,19 +461,51 @@ func TestNewConfig(t *testing.T) {
 				"-publicURI", configFlag.PublicURI,
 			},
 			wantCfg: Config{
-				Version:            testingVersionNumber,
-				ConfigFile:         configFileExample.ConfigFile,
-				ListenPort:         configEnv.ListenPort,
-				PublicURI:          configFlag.PublicURI,
-				MiniserverURL:      configFileExample.MiniserverURL,
-				MiniserverUser:     configFileExample.MiniserverUser,
-				MiniserverPassword: configFileExample.MiniserverPassword,
-				MiniserverTimeout:  configFileExample.MiniserverTimeout,
-				LetsEncryptCache:   configFileExample.LetsEncryptCache,
-				LogFileMain:        configFileExample.LogFileMain,
-				LogFileHTTPError:   configFileExample.LogFileHTTPError,
-				LogFileHTTPAccess:  configFileExample.LogFileHTTPAccess,
-				ControlsFiles:      configFileExample.ControlsFiles,
+				Version:              testingVersionNumber,
+				ConfigFile:           configFileExample.ConfigFile,
+				ListenPort:           configEnv.ListenPort,
+				PublicURI:            configFlag.PublicURI,
+				MiniserverURL:        configFileExample.MiniserverURL,
+				MiniserverUser:       configFileExample.MiniserverUser,
+				MiniserverPassword:   configFileExample.MiniserverPassword,
+				MiniserverTimeout:    configFileExample.MiniserverTimeout,
+				MiniserverAuth:       configFileExample.MiniserverAuth,
+				MiniserverTransport:  configFileExample.MiniserverTransport,
+				MiniserverEncryption: configFileExample.MiniserverEncryption,
+				LetsEncryptCache:     configFileExample.LetsEncryptCache,
+				LogFileMain:          configFileExample.LogFileMain,
+				LogFileHTTPError:     configFileExample.LogFileHTTPError,
+				LogFileHTTPAccess:    configFileExample.LogFileHTTPAccess,
+				LogFormat:            configFileExample.LogFormat,
+				LogLevel:             configFileExample.LogLevel,
+				ControlsFiles:        configFileExample.ControlsFiles,
+				ControlsWatch:        configFileExample.ControlsWatch,
+				AuthKeyHeader:        configFileExample.AuthKeyHeader,
+				StateFile:            configFileExample.StateFile,
+				RateLimitIP:          configFileExample.RateLimitIP,
+				RateBurstIP:          configFileExample.RateBurstIP,
+				RateLimitKey:         configFileExample.RateLimitKey,
+				RateBurstKey:         configFileExample.RateBurstKey,
+				RateLimitControl:     configFileExample.RateLimitControl,
+				RateBurstControl:     configFileExample.RateBurstControl,
+				BanThreshold:         configFileExample.BanThreshold,
+				BanTime:              configFileExample.BanTime,
+				LimiterMaxEntries:    configFileExample.LimiterMaxEntries,
+				AdminListenAddress:   configFileExample.AdminListenAddress,
+				MetricsListenAddress: configFileExample.MetricsListenAddress,
+				TrustedProxies:       configFileExample.TrustedProxies,
+				ClientCAFile:         configFileExample.ClientCAFile,
+				ListenMode:           configFileExample.ListenMode,
+				ShutdownTimeout:      configFileExample.ShutdownTimeout,
+				TLSCertFile:          configFileExample.TLSCertFile,
+				TLSKeyFile:           configFileExample.TLSKeyFile,
+				ACMEDirectoryURL:     configFileExample.ACMEDirectoryURL,
+				ACMEChallenge:        configFileExample.ACMEChallenge,
+				ACMEDomains:          configFileExample.ACMEDomains,
+				ACMEDNSProvider:      configFileExample.ACMEDNSProvider,
+				ACMEDNSServer:        configFileExample.ACMEDNSServer,
+				ACMEDNSKeyFile:       configFileExample.ACMEDNSKeyFile,
+				ACMEDNSDelay:         configFileExample.ACMEDNSDelay,
 			},
 		},
 	}
@@ -258,3 +540,161 @@ func TestNewConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_checkListener(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		wantNetwork string
+		wantAddress string
+		wantErr     bool
+	}{
+		{
+			name:        "Autocert",
+			cfg:         Config{ListenMode: "autocert", ListenPort: 4443},
+			wantNetwork: "tcp",
+			wantAddress: ":4443",
+		},
+		{
+			name:    "TLSWithoutFiles",
+			cfg:     Config{ListenMode: "tls", ListenPort: 4443},
+			wantErr: true,
+		},
+		{
+			name:        "HTTPDefault",
+			cfg:         Config{ListenMode: "http", ListenPort: 8080},
+			wantNetwork: "tcp",
+			wantAddress: "127.0.0.1:8080",
+		},
+		{
+			name:        "HTTPLoopback",
+			cfg:         Config{ListenMode: "http", ListenAddress: "[::1]:8080"},
+			wantNetwork: "tcp",
+			wantAddress: "[::1]:8080",
+		},
+		{
+			name:    "HTTPPublic",
+			cfg:     Config{ListenMode: "http", ListenAddress: "0.0.0.0:8080"},
+			wantErr: true,
+		},
+		{
+			name:        "HTTPUnixSocket",
+			cfg:         Config{ListenMode: "http", ListenAddress: "unix:/run/loxwebhook.sock"},
+			wantNetwork: "unix",
+			wantAddress: "/run/loxwebhook.sock",
+		},
+		{
+			name:    "AutocertUnixSocket",
+			cfg:     Config{ListenMode: "autocert", ListenAddress: "unix:/run/loxwebhook.sock"},
+			wantErr: true,
+		},
+		{
+			name:    "HTTPWithClientCA",
+			cfg:     Config{ListenMode: "http", ListenPort: 8080, ClientCAFile: "ca.pem"},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownMode",
+			cfg:     Config{ListenMode: "quic", ListenPort: 4443},
+			wantErr: true,
+		},
+		{
+			name:    "NegativeShutdownTimeout",
+			cfg:     Config{ListenMode: "autocert", ListenPort: 4443, ShutdownTimeout: -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.checkListener()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.checkListener() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			network, address := tt.cfg.GetListenAddress()
+			if network != tt.wantNetwork || address != tt.wantAddress {
+				t.Errorf("Config.GetListenAddress() = %s, %s, want %s, %s", network, address, tt.wantNetwork, tt.wantAddress)
+			}
+		})
+	}
+}
+
+func TestConfig_LogValue(t *testing.T) {
+	u, _ := url.Parse("http://192.168.1.10")
+	cfg := &Config{
+		ListenPort:         4443,
+		MiniserverURL:      u,
+		MiniserverPassword: "secretPassword",
+		ACMEEABHMACKey:     "secretHMACKey",
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("Config", "config", cfg)
+	entry := buf.String()
+	for _, want := range []string{"config.listenPort=4443", "config.miniserverURL=http://192.168.1.10"} {
+		if !strings.Contains(entry, want) {
+			t.Errorf("LogValue() entry = %s, want %s", entry, want)
+		}
+	}
+	for _, secret := range []string{cfg.MiniserverPassword, cfg.ACMEEABHMACKey} {
+		if strings.Contains(entry, secret) {
+			t.Errorf("LogValue() entry contains secret %s", secret)
+		}
+	}
+	if strings.Count(entry, "\n") != 1 {
+		t.Errorf("LogValue() entry spans multiple lines: %s", entry)
+	}
+	str := cfg.String()
+	if !strings.Contains(str, "listenPort:            4443\n") {
+		t.Errorf("String() = %s, want listenPort", str)
+	}
+	for _, secret := range []string{cfg.MiniserverPassword, cfg.ACMEEABHMACKey} {
+		if strings.Contains(str, secret) {
+			t.Errorf("String() contains secret %s", secret)
+		}
+	}
+}
+
+func TestConfig_GetTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		wantNetworks   int
+		wantUnixSocket bool
+		wantErr        bool
+	}{
+		{
+			name:           "None",
+			trustedProxies: nil,
+		},
+		{
+			name:           "Networks",
+			trustedProxies: []string{"192.168.1.10", "fd00::/64"},
+			wantNetworks:   2,
+		},
+		{
+			name:           "UnixSocket",
+			trustedProxies: []string{"unix", "192.168.1.10"},
+			wantNetworks:   1,
+			wantUnixSocket: true,
+		},
+		{
+			name:           "Invalid",
+			trustedProxies: []string{"proxy.example.com"},
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{TrustedProxies: tt.trustedProxies}
+			networks, unixSocket, err := c.GetTrustedProxies()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.GetTrustedProxies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(networks) != tt.wantNetworks || unixSocket != tt.wantUnixSocket {
+				t.Errorf("Config.GetTrustedProxies() = %v, %v, want %d networks, %v", networks, unixSocket, tt.wantNetworks, tt.wantUnixSocket)
+			}
+		})
+	}
+}
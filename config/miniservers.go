@@ -0,0 +1,275 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMiniserver is the name of the Miniserver configured by the
+// Miniserver* values. Controls without a Miniserver field use it.
+const DefaultMiniserver = "default"
+
+// Miniserver holds the connection settings for one Miniserver
+type Miniserver struct {
+	Name          string
+	URL           *url.URL
+	User          string
+	Password      string
+	Timeout       time.Duration
+	Auth          string
+	Transport     string
+	Encryption    string
+	TLSSkipVerify bool
+	TLSCAFile     string
+}
+
+// basicTypeMiniserver holds a named Miniserver from the config file.
+// Empty values are taken from the Miniserver* values.
+type basicTypeMiniserver struct {
+	URL           string
+	User          string
+	Password      string
+	Timeout       int // Seconds
+	Auth          string
+	Transport     string
+	Encryption    string
+	TLSSkipVerify bool
+	TLSCAFile     string
+}
+
+func (btc *basicTypeConfig) getMiniservers() (map[string]*Miniserver, error) {
+	if len(btc.Miniservers) == 0 {
+		return nil, nil
+	}
+	validName := regexp.MustCompile(`^[0-9a-zA-z_-]+$`)
+	miniservers := make(map[string]*Miniserver)
+	for name, bm := range btc.Miniservers {
+		if !validName.MatchString(name) || name == DefaultMiniserver {
+			return nil, fmt.Errorf("Invalid Miniserver name %s", name)
+		}
+		if bm.URL == "" {
+			return nil, fmt.Errorf("Miniserver %s has no URL", name)
+		}
+		u, err := url.Parse(bm.URL)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error parsing URL of Miniserver "+name)
+		}
+		m := &Miniserver{
+			Name:          name,
+			URL:           u,
+			User:          firstNonEmpty(bm.User, btc.MiniserverUser),
+			Password:      firstNonEmpty(bm.Password, btc.MiniserverPassword),
+			Timeout:       time.Duration(btc.MiniserverTimeout) * time.Second,
+			Auth:          strings.ToLower(firstNonEmpty(bm.Auth, btc.MiniserverAuth)),
+			Transport:     strings.ToLower(firstNonEmpty(bm.Transport, btc.MiniserverTransport)),
+			Encryption:    strings.ToLower(firstNonEmpty(bm.Encryption, btc.MiniserverEncryption)),
+			TLSSkipVerify: bm.TLSSkipVerify || btc.MiniserverTLSSkipVerify,
+			TLSCAFile:     firstNonEmpty(bm.TLSCAFile, btc.MiniserverTLSCAFile),
+		}
+		if bm.Timeout != 0 {
+			m.Timeout = time.Duration(bm.Timeout) * time.Second
+		}
+		miniservers[name] = m
+	}
+	return miniservers, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// GetMiniserver returns the Miniserver with the given name. An empty name
+// returns the default Miniserver.
+func (c *Config) GetMiniserver(name string) (*Miniserver, bool) {
+	if name == "" || name == DefaultMiniserver {
+		return &Miniserver{
+			Name:          DefaultMiniserver,
+			URL:           c.MiniserverURL,
+			User:          c.MiniserverUser,
+			Password:      c.MiniserverPassword,
+			Timeout:       c.MiniserverTimeout,
+			Auth:          c.MiniserverAuth,
+			Transport:     c.MiniserverTransport,
+			Encryption:    c.MiniserverEncryption,
+			TLSSkipVerify: c.MiniserverTLSSkipVerify,
+			TLSCAFile:     c.MiniserverTLSCAFile,
+		}, true
+	}
+	m, ok := c.Miniservers[name]
+	return m, ok
+}
+
+// GetMiniserverNames returns the names of all Miniservers. The default
+// Miniserver is always first.
+func (c *Config) GetMiniserverNames() []string {
+	names := make([]string, 0, len(c.Miniservers))
+	for name := range c.Miniservers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultMiniserver}, names...)
+}
+
+// TLSConfig returns the TLS config used to connect to the Miniserver
+func (m *Miniserver) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: m.TLSSkipVerify,
+	}
+	if m.TLSCAFile == "" {
+		return tlsConfig, nil
+	}
+	pem, err := ioutil.ReadFile(m.TLSCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading TLSCAFile")
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificate found in %s", m.TLSCAFile)
+	}
+	return tlsConfig, nil
+}
+
+// HTTPClient returns a http.Client with the timeout and TLS settings of the Miniserver
+func (m *Miniserver) HTTPClient() (*http.Client, error) {
+	tlsConfig, err := m.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Timeout:   m.Timeout,
+		Transport: transport,
+	}, nil
+}
+
+// ProbeClient returns a http.Client for Probe. It does not keep idle
+// connections open between probes.
+func (m *Miniserver) ProbeClient() (*http.Client, error) {
+	client, err := m.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	client.Transport.(*http.Transport).DisableKeepAlives = true
+	return client, nil
+}
+
+func (m *Miniserver) validate() error {
+	if m.URL == nil || m.URL.Host == "" || (m.URL.Scheme != "http" && m.URL.Scheme != "https") {
+		return errors.New("MiniserverURL must be a http or https URL")
+	}
+	if m.User == "" || m.Password == "" {
+		return errors.New("MiniserverUser and MiniserverPassword must not be empty")
+	}
+	if _, err := m.TLSConfig(); err != nil {
+		return err
+	}
+	switch m.Auth {
+	case "token", "basic":
+		// Nothing to do
+	default:
+		return errors.New("MiniserverAuth must be token or basic")
+	}
+	switch m.Transport {
+	case "http", "websocket":
+		// Nothing to do
+	default:
+		return errors.New("MiniserverTransport must be http or websocket")
+	}
+	switch m.Encryption {
+	case "none", "enc", "fenc":
+		// Nothing to do
+	default:
+		return errors.New("MiniserverEncryption must be none, enc or fenc")
+	}
+	return nil
+}
+
+// reach checks if the Miniserver answers requests
+func (m *Miniserver) reach() error {
+	client, err := m.ProbeClient()
+	if err != nil {
+		return err
+	}
+	_, err = m.Probe(client)
+	return err
+}
+
+// Probe checks if the Miniserver answers requests and returns its firmware
+// version. The version is empty if the answer does not contain it.
+func (m *Miniserver) Probe(client *http.Client) (string, error) {
+	testEndpoint := *m.URL
+	testEndpoint.Path = "/jdev/cfg/api"
+	resp, err := client.Get(testEndpoint.String())
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot reach miniserver")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("Miniserver responded with status code %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", errors.Wrap(err, "Error reading Miniserver response")
+	}
+	return parseAPIVersion(body), nil
+}
+
+// parseAPIVersion returns the firmware version of a /jdev/cfg/api answer.
+// The value is a JSON like object with single quotes:
+// {"LL": {"control": "dev/cfg/api", "value": "{'snr': '50:4F:94:10:B8:4A', 'version':'12.0.2.24'}", "Code": "200"}}
+func parseAPIVersion(body []byte) string {
+	var answer struct {
+		LL struct {
+			Value string `json:"value"`
+		}
+	}
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return ""
+	}
+	var api struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal([]byte(strings.Replace(answer.LL.Value, "'", `"`, -1)), &api); err != nil {
+		return ""
+	}
+	return api.Version
+}
+
+// validateMiniservers returns an error if the settings of a Miniserver are
+// invalid or the default Miniserver is not reachable. The other Miniservers
+// may be offline at startup, the health probes report them.
+func (c *Config) validateMiniservers() error {
+	var failed []string
+	for _, name := range c.GetMiniserverNames() {
+		m, _ := c.GetMiniserver(name)
+		err := m.validate()
+		if err == nil && name == DefaultMiniserver {
+			err = m.reach()
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("Miniserver %s: %s", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
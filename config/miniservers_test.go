@@ -0,0 +1,253 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func Test_basicTypeConfig_getMiniservers(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]*Miniserver
+		wantErr bool
+	}{
+		{
+			name: "NoMiniservers",
+			data: `MiniserverURL = 'http://192.168.1.1'`,
+			want: nil,
+		},
+		{
+			name: "InheritDefaults",
+			data: `
+MiniserverUser = 'loxwebhook'
+MiniserverPassword = 'secret'
+MiniserverTimeout = 3
+MiniserverTLSCAFile = '/etc/loxwebhook/ca.pem'
+[Miniservers.garage]
+URL = 'https://192.168.1.2'
+Auth = 'Basic'
+`,
+			want: map[string]*Miniserver{
+				"garage": {
+					Name:       "garage",
+					URL:        &url.URL{Scheme: "https", Host: "192.168.1.2"},
+					User:       "loxwebhook",
+					Password:   "secret",
+					Timeout:    3 * time.Second,
+					Auth:       "basic",
+					Transport:  "http",
+					Encryption: "none",
+					TLSCAFile:  "/etc/loxwebhook/ca.pem",
+				},
+			},
+		},
+		{
+			name: "OwnValues",
+			data: `
+[Miniservers.garage]
+URL = 'https://192.168.1.2'
+User = 'garage'
+Password = 'garageSecret'
+Timeout = 5
+Transport = 'websocket'
+Encryption = 'fenc'
+TLSSkipVerify = true
+`,
+			want: map[string]*Miniserver{
+				"garage": {
+					Name:          "garage",
+					URL:           &url.URL{Scheme: "https", Host: "192.168.1.2"},
+					User:          "garage",
+					Password:      "garageSecret",
+					Timeout:       5 * time.Second,
+					Auth:          "token",
+					Transport:     "websocket",
+					Encryption:    "fenc",
+					TLSSkipVerify: true,
+				},
+			},
+		},
+		{
+			name: "NoURL",
+			data: `
+[Miniservers.garage]
+User = 'garage'
+`,
+			wantErr: true,
+		},
+		{
+			name: "ReservedName",
+			data: `
+[Miniservers.default]
+URL = 'https://192.168.1.2'
+`,
+			wantErr: true,
+		},
+		{
+			name: "InvalidName",
+			data: `
+[Miniservers."gar age"]
+URL = 'https://192.168.1.2'
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			btc := newDefaultConfig()
+			if err := toml.Unmarshal([]byte(tt.data), btc); err != nil {
+				t.Fatalf("toml.Unmarshal() error = %v", err)
+			}
+			got, err := btc.getMiniservers()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getMiniservers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getMiniservers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_validateMiniservers(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/jdev/cfg/api" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	healthyURL, _ := url.Parse(healthy.URL)
+	brokenURL, _ := url.Parse(broken.URL)
+	newMiniserver := func(name string, u *url.URL, auth string) *Miniserver {
+		return &Miniserver{
+			Name:       name,
+			URL:        u,
+			User:       "admin",
+			Password:   "secret",
+			Timeout:    time.Second,
+			Auth:       auth,
+			Transport:  "http",
+			Encryption: "none",
+		}
+	}
+
+	tests := []struct {
+		name        string
+		defaultURL  *url.URL
+		miniservers map[string]*Miniserver
+		wantErr     bool
+	}{
+		{
+			name:        "Healthy",
+			defaultURL:  healthyURL,
+			miniservers: map[string]*Miniserver{"garage": newMiniserver("garage", healthyURL, "token")},
+			wantErr:     false,
+		},
+		{
+			name:        "OfflineMiniserver",
+			defaultURL:  healthyURL,
+			miniservers: map[string]*Miniserver{"garage": newMiniserver("garage", brokenURL, "token")},
+			wantErr:     false,
+		},
+		{
+			name:        "OfflineDefault",
+			defaultURL:  brokenURL,
+			miniservers: map[string]*Miniserver{"garage": newMiniserver("garage", healthyURL, "token")},
+			wantErr:     true,
+		},
+		{
+			name:        "InvalidAuth",
+			defaultURL:  healthyURL,
+			miniservers: map[string]*Miniserver{"shed": newMiniserver("shed", healthyURL, "digest")},
+			wantErr:     true,
+		},
+		{
+			name:        "NoPassword",
+			defaultURL:  healthyURL,
+			miniservers: map[string]*Miniserver{"shed": {Name: "shed", URL: healthyURL, User: "admin", Auth: "token", Transport: "http", Encryption: "none"}},
+			wantErr:     true,
+		},
+		{
+			name:        "InvalidURL",
+			defaultURL:  healthyURL,
+			miniservers: map[string]*Miniserver{"shed": newMiniserver("shed", &url.URL{Path: "192.168.1.2"}, "token")},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				MiniserverURL:        tt.defaultURL,
+				MiniserverUser:       "admin",
+				MiniserverPassword:   "secret",
+				MiniserverTimeout:    time.Second,
+				MiniserverAuth:       "token",
+				MiniserverTransport:  "http",
+				MiniserverEncryption: "none",
+				Miniservers:          tt.miniservers,
+			}
+			if err := cfg.validateMiniservers(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.validateMiniservers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if cfg.MiniserverURL.Path != "" {
+				t.Errorf("Config.validateMiniservers() changed MiniserverURL to %s", cfg.MiniserverURL)
+			}
+		})
+	}
+}
+
+func TestMiniserver_Probe(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantVersion string
+	}{
+		{
+			name:        "Version",
+			body:        `{"LL": {"control": "dev/cfg/api", "value": "{'snr': '50:4F:94:10:B8:4A', 'version':'12.0.2.24'}", "Code": "200"}}`,
+			wantVersion: "12.0.2.24",
+		},
+		{
+			name:        "NoVersion",
+			body:        `{"LL": {"control": "dev/cfg/api", "value": "{'snr': '50:4F:94:10:B8:4A'}", "Code": "200"}}`,
+			wantVersion: "",
+		},
+		{
+			name:        "NoJSON",
+			body:        `<html></html>`,
+			wantVersion: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			u, _ := url.Parse(server.URL)
+			m := &Miniserver{URL: u, Timeout: time.Second}
+			client, err := m.ProbeClient()
+			if err != nil {
+				t.Fatalf("ProbeClient() error = %v", err)
+			}
+			version, err := m.Probe(client)
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("Probe() = %q, want %q", version, tt.wantVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+// PublicHost is a hostname and path prefix loxwebhook accepts requests for
+type PublicHost struct {
+	Name       string
+	Hostname   string
+	PathPrefix string   // Empty or like /lox without trailing slash
+	Controls   []string // Controls served on this host. Empty serves all.
+}
+
+// basicTypePublicHost holds a named public host from the config file
+type basicTypePublicHost struct {
+	Hostname   string
+	PathPrefix string
+	Controls   []string
+}
+
+// DefaultPublicHost is the name of the public host configured by PublicURI
+// and PathPrefix
+const DefaultPublicHost = "default"
+
+var validPathPrefix = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)*$`)
+
+// normalizePathPrefix returns p with a leading and without a trailing slash
+func normalizePathPrefix(p string) (string, error) {
+	p = strings.TrimSuffix(p, "/")
+	if p != "" && !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	if !validPathPrefix.MatchString(p) {
+		return "", errors.New("Invalid path prefix " + p)
+	}
+	return p, nil
+}
+
+func (btc *basicTypeConfig) getPublicHosts() (map[string]*PublicHost, error) {
+	if len(btc.PublicHosts) == 0 {
+		return nil, nil
+	}
+	validName := regexp.MustCompile(`^[0-9a-zA-z_-]+$`)
+	hosts := make(map[string]*PublicHost)
+	for name, bh := range btc.PublicHosts {
+		if !validName.MatchString(name) || name == DefaultPublicHost {
+			return nil, fmt.Errorf("Invalid public host name %s", name)
+		}
+		if bh.Hostname == "" {
+			return nil, fmt.Errorf("Public host %s has no Hostname", name)
+		}
+		prefix, err := normalizePathPrefix(bh.PathPrefix)
+		if err != nil {
+			return nil, errors.Wrap(err, "Public host "+name)
+		}
+		hosts[name] = &PublicHost{
+			Name:       name,
+			Hostname:   strings.ToLower(bh.Hostname),
+			PathPrefix: prefix,
+			Controls:   bh.Controls,
+		}
+	}
+	return hosts, nil
+}
+
+// GetPublicHosts returns the host of PublicURI followed by the PublicHosts
+// sorted by name
+func (c *Config) GetPublicHosts() []*PublicHost {
+	prefix, _ := normalizePathPrefix(c.PathPrefix)
+	hosts := []*PublicHost{{
+		Name:       DefaultPublicHost,
+		Hostname:   strings.ToLower(c.PublicURI),
+		PathPrefix: prefix,
+	}}
+	names := make([]string, 0, len(c.PublicHosts))
+	for name := range c.PublicHosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hosts = append(hosts, c.PublicHosts[name])
+	}
+	return hosts
+}
+
+// GetPublicHostnames returns every hostname of the public hosts once
+func (c *Config) GetPublicHostnames() []string {
+	var hostnames []string
+	for _, h := range c.GetPublicHosts() {
+		if !helpers.IsStringInSlice(h.Hostname, hostnames) {
+			hostnames = append(hostnames, h.Hostname)
+		}
+	}
+	return hostnames
+}
+
+// AllowsControl returns true if the control is served on the host
+func (h *PublicHost) AllowsControl(control string) bool {
+	return len(h.Controls) == 0 || helpers.IsStringInSlice(control, h.Controls)
+}
+
+// URL returns the base URL of the host
+func (h *PublicHost) URL() string {
+	return "https://" + h.Hostname + h.PathPrefix + "/"
+}
+
+func (c *Config) validatePublicHosts() error {
+	if _, err := normalizePathPrefix(c.PathPrefix); err != nil {
+		return errors.Wrap(err, "Invalid PathPrefix")
+	}
+	seen := make(map[string]string)
+	for _, h := range c.GetPublicHosts() {
+		if err := c.checkHostname(h.Hostname); err != nil {
+			return errors.Wrap(err, "Public host "+h.Name)
+		}
+		base := h.Hostname + h.PathPrefix
+		if other, ok := seen[base]; ok {
+			return fmt.Errorf("Public hosts %s and %s have the same Hostname and PathPrefix", other, h.Name)
+		}
+		seen[base] = h.Name
+	}
+	return nil
+}
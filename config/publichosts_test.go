@@ -0,0 +1,178 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func Test_normalizePathPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{name: "Empty", prefix: "", want: ""},
+		{name: "Slash", prefix: "/", want: ""},
+		{name: "NoLeadingSlash", prefix: "lox", want: "/lox"},
+		{name: "TrailingSlash", prefix: "/lox/hooks/", want: "/lox/hooks"},
+		{name: "DoubleSlash", prefix: "/lox//hooks", wantErr: true},
+		{name: "Query", prefix: "/lox?a=b", wantErr: true},
+		{name: "Space", prefix: "/lox hooks", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizePathPrefix(tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizePathPrefix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizePathPrefix() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_basicTypeConfig_getPublicHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]*PublicHost
+		wantErr bool
+	}{
+		{
+			name: "NoPublicHosts",
+			data: `PublicURI = 'loxwebhook.example.com'`,
+			want: nil,
+		},
+		{
+			name: "PublicHost",
+			data: `
+[PublicHosts.hooks]
+Hostname = 'Hooks.example.com'
+PathPrefix = 'lox/'
+Controls = ['garage']
+`,
+			want: map[string]*PublicHost{
+				"hooks": {
+					Name:       "hooks",
+					Hostname:   "hooks.example.com",
+					PathPrefix: "/lox",
+					Controls:   []string{"garage"},
+				},
+			},
+		},
+		{
+			name: "NoHostname",
+			data: `
+[PublicHosts.hooks]
+PathPrefix = '/lox'
+`,
+			wantErr: true,
+		},
+		{
+			name: "InvalidPathPrefix",
+			data: `
+[PublicHosts.hooks]
+Hostname = 'hooks.example.com'
+PathPrefix = '/lox?'
+`,
+			wantErr: true,
+		},
+		{
+			name: "ReservedName",
+			data: `
+[PublicHosts.default]
+Hostname = 'hooks.example.com'
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			btc := newDefaultConfig()
+			if err := toml.Unmarshal([]byte(tt.data), btc); err != nil {
+				t.Fatalf("toml.Unmarshal() error = %v", err)
+			}
+			got, err := btc.getPublicHosts()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getPublicHosts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getPublicHosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_GetPublicHosts(t *testing.T) {
+	cfg := &Config{
+		PublicURI:  "loxwebhook.example.com",
+		PathPrefix: "/lox",
+		PublicHosts: map[string]*PublicHost{
+			"shed":   {Name: "shed", Hostname: "loxwebhook.example.com", PathPrefix: "/shed"},
+			"garage": {Name: "garage", Hostname: "garage.example.com"},
+		},
+	}
+	var names []string
+	for _, h := range cfg.GetPublicHosts() {
+		names = append(names, h.Name)
+	}
+	if want := []string{DefaultPublicHost, "garage", "shed"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("GetPublicHosts() names = %v, want %v", names, want)
+	}
+	want := []string{"loxwebhook.example.com", "garage.example.com"}
+	if got := cfg.GetPublicHostnames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPublicHostnames() = %v, want %v", got, want)
+	}
+}
+
+func TestConfig_validatePublicHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   map[string]*PublicHost
+		wantErr bool
+	}{
+		{
+			name: "OtherPrefix",
+			hosts: map[string]*PublicHost{
+				"hooks": {Name: "hooks", Hostname: "loxwebhook.example.com", PathPrefix: "/hooks"},
+			},
+		},
+		{
+			name: "SamePrefix",
+			hosts: map[string]*PublicHost{
+				"hooks": {Name: "hooks", Hostname: "loxwebhook.example.com"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "InvalidHostname",
+			hosts: map[string]*PublicHost{
+				"hooks": {Name: "hooks", Hostname: "https://hooks.example.com"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{PublicURI: "loxwebhook.example.com", PublicHosts: tt.hosts}
+			if err := cfg.validatePublicHosts(); (err != nil) != tt.wantErr {
+				t.Errorf("validatePublicHosts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublicHost_AllowsControl(t *testing.T) {
+	all := &PublicHost{}
+	some := &PublicHost{Controls: []string{"garage"}}
+	if !all.AllowsControl("gate") {
+		t.Errorf("AllowsControl() without Controls rejected gate")
+	}
+	if !some.AllowsControl("garage") || some.AllowsControl("gate") {
+		t.Errorf("AllowsControl() did not limit to Controls %v", some.Controls)
+	}
+}
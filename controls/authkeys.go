@@ -0,0 +1,203 @@
+package controls
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+// defaultMaxClockSkew is used for signed requests if MaxClockSkew is not set
+const defaultMaxClockSkew = 5 * time.Minute
+
+// AuthKey holds the config for one authentication key. In controls files a
+// key is either configured as plain string or as table:
+//
+//	[AuthKeys]
+//	simple = "43b2c690-f281-42bb-af2d-979f5dbe9517"
+//	[AuthKeys.signed]
+//	Key = "a long shared secret"
+//	Mode = "hmac"
+type AuthKey struct {
+	Key          string
+	Mode         string   // plain (default), hmac or cert
+	MaxClockSkew int      // hmac only, seconds a signed request may differ from the local time
+	Transports   []string // plain only, ways the key may be sent. Empty allows all.
+	NotBefore    time.Time
+	ExpiresAt    time.Time
+	MaxUses      int // 0 allows unlimited uses
+	Disabled     bool
+	Window       TimeWindow
+	RateLimit    int // Requests per minute, 0 uses RateLimitKey of the config
+	RateBurst    int // 0 uses RateBurstKey of the config
+	// Client IPs and networks (CIDR) the key is accepted from. Empty allows all.
+	AllowedNetworks []string
+	networks        []*net.IPNet // Parsed AllowedNetworks, set by Validate
+	// Client certificate identities that authenticate as this key
+	ClientCerts []string
+}
+
+// AuthKeyTransports are the ways a plain key can be sent with a request
+var AuthKeyTransports = []string{
+	"query",  // Query parameter k
+	"form",   // Field k of a form in the request body
+	"header", // Header configured by AuthKeyHeader
+	"bearer", // Authorization: Bearer <key>
+	"basic",  // Password of Basic Auth
+}
+
+// authKeyFields has the fields of AuthKey without the UnmarshalTOML method
+type authKeyFields AuthKey
+
+// UnmarshalTOML accepts a plain string or a table
+func (k *AuthKey) UnmarshalTOML(data interface{}) error {
+	switch v := data.(type) {
+	case string:
+		*k = AuthKey{Key: v}
+		return nil
+	case map[string]interface{}:
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+			return err
+		}
+		var fields authKeyFields
+		md, err := toml.Decode(buf.String(), &fields)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("Unknown auth key setting %s", undecoded[0])
+		}
+		*k = AuthKey(fields)
+		return nil
+	default:
+		return fmt.Errorf("Auth key must be a string or a table, got %T", data)
+	}
+}
+
+// GetMode returns the mode of the key
+func (k *AuthKey) GetMode() string {
+	if k.Mode == "" {
+		return "plain"
+	}
+	return k.Mode
+}
+
+// GetMaxClockSkew returns the accepted difference between the timestamp of
+// a signed request and the local time
+func (k *AuthKey) GetMaxClockSkew() time.Duration {
+	if k.MaxClockSkew == 0 {
+		return defaultMaxClockSkew
+	}
+	return time.Duration(k.MaxClockSkew) * time.Second
+}
+
+// IsHashed returns true if only the hash of the key is configured
+func (k *AuthKey) IsHashed() bool {
+	_, ok := parseKeyHash(k.Key)
+	return ok
+}
+
+// keyID returns the key id of a hashed key
+func (k *AuthKey) keyID() string {
+	h, _ := parseKeyHash(k.Key)
+	return h.id
+}
+
+// Verify compares key in constant time with the configured key or its hash
+func (k *AuthKey) Verify(key string) bool {
+	if h, ok := parseKeyHash(k.Key); ok {
+		return h.verify(key)
+	}
+	return subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1
+}
+
+// AllowsIP returns true if the key may be used by a client with ip
+func (k *AuthKey) AllowsIP(ip net.IP) bool {
+	return allowsIP(k.networks, ip)
+}
+
+// AllowsTransport returns true if the key may be sent via transport
+func (k *AuthKey) AllowsTransport(transport string) bool {
+	if len(k.Transports) == 0 {
+		return true
+	}
+	return helpers.IsStringInSlice(transport, k.Transports)
+}
+
+// Validate returns an error if the key name contains invalid data. It parses
+// AllowedNetworks for AllowsIP.
+func (k *AuthKey) Validate(name string) ControlError {
+	if k.Key == "" && k.GetMode() != "cert" {
+		return newInvalidAuthKeyConfigError(name, "Key must not be empty")
+	}
+	h, hashed := parseKeyHash(k.Key)
+	switch k.GetMode() {
+	case "plain":
+		if !hashed {
+			break
+		}
+		if err := h.check(); err != nil {
+			return newInvalidAuthKeyConfigError(name, err.Error())
+		}
+		if strings.Contains(h.id, ".") {
+			return newInvalidAuthKeyConfigError(name, "Key id must not contain a .")
+		}
+		if h.expensive() && h.id == "" {
+			return newInvalidAuthKeyConfigError(name, h.algorithm+" hashes need a key id")
+		}
+	case "hmac":
+		if hashed {
+			return newInvalidAuthKeyConfigError(name, "Key of hmac mode must not be hashed")
+		}
+		if len(k.Key) < 16 {
+			return newInvalidAuthKeyConfigError(name, "Key of hmac mode must have at least 16 characters")
+		}
+	case "cert":
+		if k.Key != "" {
+			return newInvalidAuthKeyConfigError(name, "Key of cert mode must be empty")
+		}
+		if len(k.ClientCerts) == 0 {
+			return newInvalidAuthKeyConfigError(name, "Cert mode needs ClientCerts")
+		}
+	default:
+		return newInvalidAuthKeyConfigError(name, "Mode must be plain, hmac or cert")
+	}
+	for _, id := range k.ClientCerts {
+		if _, err := normalizeCertIdentity(id); err != nil {
+			return newInvalidAuthKeyConfigError(name, err.Error())
+		}
+	}
+	for _, t := range k.Transports {
+		if !helpers.IsStringInSlice(t, AuthKeyTransports) {
+			return newInvalidAuthKeyConfigError(name, "Unknown transport "+t)
+		}
+	}
+	if k.MaxClockSkew < 0 {
+		return newInvalidAuthKeyConfigError(name, "MaxClockSkew must not be negative")
+	}
+	if k.RateLimit < 0 || k.RateBurst < 0 {
+		return newInvalidAuthKeyConfigError(name, "RateLimit and RateBurst must not be negative")
+	}
+	networks, err := parseNetworks(k.AllowedNetworks)
+	if err != nil {
+		return newInvalidAuthKeyConfigError(name, err.Error())
+	}
+	k.networks = networks
+	if k.MaxUses < 0 {
+		return newInvalidAuthKeyConfigError(name, "MaxUses must not be negative")
+	}
+	if !k.NotBefore.IsZero() && !k.ExpiresAt.IsZero() && !k.NotBefore.Before(k.ExpiresAt) {
+		return newInvalidAuthKeyConfigError(name, "NotBefore must be before ExpiresAt")
+	}
+	if err := k.Window.validate(); err != nil {
+		return newInvalidAuthKeyConfigError(name, err.Error())
+	}
+	return nil
+}
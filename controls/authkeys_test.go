@@ -0,0 +1,177 @@
+package controls
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestAuthKey_UnmarshalTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]AuthKey
+		wantErr bool
+	}{
+		{
+			name: "String",
+			data: `
+[AuthKeys]
+simple = "43b2c690-f281-42bb-af2d-979f5dbe9517"
+`,
+			want: map[string]AuthKey{
+				"simple": {Key: "43b2c690-f281-42bb-af2d-979f5dbe9517"},
+			},
+		},
+		{
+			name: "Table",
+			data: `
+[AuthKeys]
+simple = "43b2c690-f281-42bb-af2d-979f5dbe9517"
+[AuthKeys.signed]
+Key = "0123456789abcdef0123"
+Mode = "hmac"
+MaxClockSkew = 60
+`,
+			want: map[string]AuthKey{
+				"simple": {Key: "43b2c690-f281-42bb-af2d-979f5dbe9517"},
+				"signed": {Key: "0123456789abcdef0123", Mode: "hmac", MaxClockSkew: 60},
+			},
+		},
+		{
+			name: "UnknownSetting",
+			data: `
+[AuthKeys.signed]
+Key = "0123456789abcdef0123"
+Secret = "typo"
+`,
+			wantErr: true,
+		},
+		{
+			name: "WrongType",
+			data: `
+[AuthKeys]
+number = 5
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ci controlImport
+			err := toml.Unmarshal([]byte(tt.data), &ci)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toml.Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(ci.AuthKeys, tt.want) {
+				t.Errorf("toml.Unmarshal() AuthKeys = %v, want %v", ci.AuthKeys, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthKey_validate(t *testing.T) {
+	tests := []struct {
+		name string
+		key  AuthKey
+		want ControlError
+	}{
+		{
+			name: "Plain",
+			key:  AuthKey{Key: "43b2c690-f281-42bb-af2d-979f5dbe9517"},
+			want: nil,
+		},
+		{
+			name: "Hmac",
+			key:  AuthKey{Key: "0123456789abcdef", Mode: "hmac"},
+			want: nil,
+		},
+		{
+			name: "EmptyKey",
+			key:  AuthKey{Mode: "plain"},
+			want: newInvalidAuthKeyConfigError("EmptyKey", "Key must not be empty"),
+		},
+		{
+			name: "ShortHmacKey",
+			key:  AuthKey{Key: "short", Mode: "hmac"},
+			want: newInvalidAuthKeyConfigError("ShortHmacKey", "Key of hmac mode must have at least 16 characters"),
+		},
+		{
+			name: "UnknownMode",
+			key:  AuthKey{Key: "43b2c690-f281-42bb-af2d-979f5dbe9517", Mode: "rsa"},
+			want: newInvalidAuthKeyConfigError("UnknownMode", "Mode must be plain, hmac or cert"),
+		},
+		{
+			name: "Cert",
+			key:  AuthKey{Mode: "cert", ClientCerts: []string{"cn:door", "SHA256:AB:" + strings.Repeat("cd", 31)}},
+			want: nil,
+		},
+		{
+			name: "CertWithKey",
+			key:  AuthKey{Key: "43b2c690-f281-42bb-af2d-979f5dbe9517", Mode: "cert", ClientCerts: []string{"cn:door"}},
+			want: newInvalidAuthKeyConfigError("CertWithKey", "Key of cert mode must be empty"),
+		},
+		{
+			name: "CertWithoutClientCerts",
+			key:  AuthKey{Mode: "cert"},
+			want: newInvalidAuthKeyConfigError("CertWithoutClientCerts", "Cert mode needs ClientCerts"),
+		},
+		{
+			name: "UnknownCertIdentity",
+			key:  AuthKey{Key: "43b2c690-f281-42bb-af2d-979f5dbe9517", ClientCerts: []string{"serial:1234"}},
+			want: newInvalidAuthKeyConfigError("UnknownCertIdentity", "Unknown type of client certificate identity serial:1234"),
+		},
+		{
+			name: "Sha256WithoutKeyID",
+			key:  AuthKey{Key: "$sha256$5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"},
+			want: nil,
+		},
+		{
+			name: "Argon2idWithoutKeyID",
+			key:  AuthKey{Key: "$argon2id$v=19$m=65536,t=3,p=4$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo"},
+			want: newInvalidAuthKeyConfigError("Argon2idWithoutKeyID", "argon2id hashes need a key id"),
+		},
+		{
+			name: "InvalidArgon2id",
+			key:  AuthKey{Key: "1a2b3c4d$argon2id$v=19$m=0,t=3,p=4$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo"},
+			want: newInvalidAuthKeyConfigError("InvalidArgon2id", "Invalid argon2id parameters"),
+		},
+		{
+			name: "InvalidSha256",
+			key:  AuthKey{Key: "1a2b3c4d$sha256$nohex"},
+			want: newInvalidAuthKeyConfigError("InvalidSha256", "Invalid sha256 hash"),
+		},
+		{
+			name: "HashedHmac",
+			key:  AuthKey{Key: "$sha256$5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8", Mode: "hmac"},
+			want: newInvalidAuthKeyConfigError("HashedHmac", "Key of hmac mode must not be hashed"),
+		},
+		{
+			name: "NegativeClockSkew",
+			key:  AuthKey{Key: "0123456789abcdef", Mode: "hmac", MaxClockSkew: -1},
+			want: newInvalidAuthKeyConfigError("NegativeClockSkew", "MaxClockSkew must not be negative"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.key.Validate(tt.name)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AuthKey.Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthKey_GetMaxClockSkew(t *testing.T) {
+	k := AuthKey{}
+	if got := k.GetMaxClockSkew(); got != defaultMaxClockSkew {
+		t.Errorf("GetMaxClockSkew() = %v, want %v", got, defaultMaxClockSkew)
+	}
+	k.MaxClockSkew = 30
+	if got := k.GetMaxClockSkew(); got != 30*time.Second {
+		t.Errorf("GetMaxClockSkew() = %v, want %v", got, 30*time.Second)
+	}
+}
@@ -0,0 +1,57 @@
+package controls
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Category defines a type of control like "dvi". Each category validates its
+// control definitions, parses requests and builds the path that is sent to
+// the Miniserver. Categories register themselves with RegisterCategory.
+type Category interface {
+	// Routes returns the route patterns handled by the category. They are
+	// mounted below /<category name> and must contain a {control} variable.
+	Routes() []string
+	// Validate returns an error if the category specific settings of c are invalid
+	Validate(c *Control) ControlError
+	// ParseRequest returns the control name and command of a request
+	ParseRequest(req *http.Request) (control, command string, err error)
+	// IsAllowed returns true if command may be sent to c
+	IsAllowed(c *Control, command string) bool
+	// GetPath returns the Miniserver path that sends command to c
+	GetPath(c *Control, command string) (string, error)
+}
+
+// ResponseConverter can be implemented by a Category that does not forward
+// the Miniserver response as it is.
+type ResponseConverter interface {
+	ConvertResponse(control string, body []byte) (contentType string, content []byte, err error)
+}
+
+var categories = make(map[string]Category)
+
+// RegisterCategory makes a category available under name.
+// It panics if a category with the same name is already registered.
+func RegisterCategory(name string, category Category) {
+	if _, ok := categories[name]; ok {
+		panic(fmt.Sprintf("Category %s registered twice", name))
+	}
+	categories[name] = category
+}
+
+// GetCategory returns the category registered as name
+func GetCategory(name string) (Category, bool) {
+	category, ok := categories[name]
+	return category, ok
+}
+
+// GetCategoryNames returns the sorted names of all registered categories
+func GetCategoryNames() []string {
+	names := make([]string, 0, len(categories))
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,86 @@
+package controls
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type aviCategory struct{}
+
+func init() {
+	RegisterCategory("avi", aviCategory{})
+}
+
+func (aviCategory) Routes() []string {
+	return []string{"/{control}/{value}"}
+}
+
+func (aviCategory) Validate(c *Control) ControlError {
+	if c.Min >= c.Max {
+		return newInvalidRangeError("avi", "Min must be lower than Max")
+	}
+	if c.Step < 0 {
+		return newInvalidRangeError("avi", "Step must not be negative")
+	}
+	if c.Step > c.Max-c.Min {
+		return newInvalidRangeError("avi", "Step must not be greater than Max - Min")
+	}
+	if c.Precision < 0 || c.Precision > 10 {
+		return newInvalidRangeError("avi", "Precision must be between 0 and 10")
+	}
+	return nil
+}
+
+func (aviCategory) ParseRequest(req *http.Request) (control, command string, err error) {
+	control = mux.Vars(req)["control"]
+	command = mux.Vars(req)["value"]
+	return
+}
+
+// IsAllowed always returns true because values are checked against the
+// limits of the control in GetPath
+func (aviCategory) IsAllowed(c *Control, command string) bool {
+	return true
+}
+
+// formatValue checks value against the limits of c and returns it formatted
+// with the configured precision
+func (aviCategory) formatValue(c *Control, value string) (string, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return "", fmt.Errorf("Invalid value for analog virtual input: %s", value)
+	}
+	if v < c.Min || v > c.Max {
+		return "", fmt.Errorf("Value %s is out of range (%g - %g)", value, c.Min, c.Max)
+	}
+	formatted := strconv.FormatFloat(v, 'f', c.Precision, 64)
+	rounded, _ := strconv.ParseFloat(formatted, 64)
+	if math.Abs(rounded-v) > 1e-9 {
+		return "", fmt.Errorf("Value %s has more than %d decimal places", value, c.Precision)
+	}
+	if c.Step > 0 {
+		steps := (v - c.Min) / c.Step
+		if math.Abs(steps-math.Round(steps)) > 1e-9 {
+			return "", fmt.Errorf("Value %s does not match step %g", value, c.Step)
+		}
+	}
+	return formatted, nil
+}
+
+func (cat aviCategory) GetPath(c *Control, command string) (string, error) {
+	value, err := cat.formatValue(c, command)
+	if err != nil {
+		return "", err
+	}
+	ep := fmt.Sprintf("%s/%s/%s",
+		virtualInputBasePath,
+		url.PathEscape(c.GetAddress()),
+		value,
+	)
+	return ep, nil
+}
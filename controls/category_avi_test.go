@@ -0,0 +1,78 @@
+package controls
+
+import "testing"
+
+func Test_aviCategory_GetPath(t *testing.T) {
+	c := &Control{
+		Category:  "avi",
+		ID:        4,
+		Min:       5,
+		Max:       30,
+		Step:      0.5,
+		Precision: 1,
+	}
+	tests := []struct {
+		name     string
+		value    string
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "ValidInteger",
+			value:    "21",
+			wantPath: "/dev/sps/io/VI4/21.0",
+		},
+		{
+			name:     "ValidDecimal",
+			value:    "21.5",
+			wantPath: "/dev/sps/io/VI4/21.5",
+		},
+		{
+			name:     "ValidMax",
+			value:    "30",
+			wantPath: "/dev/sps/io/VI4/30.0",
+		},
+		{
+			name:    "BelowMin",
+			value:   "4.5",
+			wantErr: true,
+		},
+		{
+			name:    "AboveMax",
+			value:   "30.5",
+			wantErr: true,
+		},
+		{
+			name:    "WrongStep",
+			value:   "21.3",
+			wantErr: true,
+		},
+		{
+			name:    "TooManyDecimals",
+			value:   "21.55",
+			wantErr: true,
+		},
+		{
+			name:    "NotANumber",
+			value:   "warm",
+			wantErr: true,
+		},
+		{
+			name:    "NaN",
+			value:   "NaN",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := aviCategory{}.GetPath(c, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("aviCategory.GetPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.wantPath {
+				t.Errorf("aviCategory.GetPath() = %s, want %s", got, tt.wantPath)
+			}
+		})
+	}
+}
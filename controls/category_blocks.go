@@ -0,0 +1,122 @@
+package controls
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+type blockCommand struct {
+	Command string // Command expected by the Miniserver
+	HasArg  bool   // Command needs a numeric argument like changeTo/<mood>
+}
+
+// blockCommands maps the friendly commands of each block category to the
+// commands expected by the Miniserver
+var blockCommands = map[string]map[string]blockCommand{
+	"jalousie": {
+		"up":       {Command: "up"},
+		"upoff":    {Command: "UpOff"},
+		"down":     {Command: "down"},
+		"downoff":  {Command: "DownOff"},
+		"fullup":   {Command: "FullUp"},
+		"fulldown": {Command: "FullDown"},
+		"shade":    {Command: "shade"},
+		"auto":     {Command: "auto"},
+		"noauto":   {Command: "NoAuto"},
+		"stop":     {Command: "stop"},
+	},
+	"lightcontroller": {
+		"plus":     {Command: "plus"},
+		"minus":    {Command: "minus"},
+		"changeto": {Command: "changeTo", HasArg: true},
+	},
+	"gate": {
+		"open":  {Command: "open"},
+		"close": {Command: "close"},
+		"stop":  {Command: "stop"},
+	},
+}
+
+// blockCategory handles Loxone function blocks. The commands of each block
+// type are defined in blockCommands.
+type blockCategory struct {
+	name string
+}
+
+func init() {
+	for name := range blockCommands {
+		RegisterCategory(name, blockCategory{name: name})
+	}
+}
+
+func (blockCategory) Routes() []string {
+	return []string{
+		"/{control}/{command}",
+		"/{control}/{command}/{arg}",
+	}
+}
+
+// lookup returns the Miniserver command for command and its argument
+func (cat blockCategory) lookup(command string) (blockCommand, string, bool) {
+	name, arg := command, ""
+	if i := strings.Index(command, "/"); i >= 0 {
+		name, arg = command[:i], command[i+1:]
+	}
+	cmd, ok := blockCommands[cat.name][strings.ToLower(name)]
+	if !ok {
+		return cmd, arg, false
+	}
+	if !cmd.HasArg {
+		return cmd, arg, arg == ""
+	}
+	_, err := strconv.ParseUint(arg, 10, 32)
+	return cmd, arg, err == nil
+}
+
+func (cat blockCategory) Validate(c *Control) ControlError {
+	for _, command := range c.Allowed {
+		if _, _, ok := cat.lookup(command); !ok {
+			return newInvalidCommandError(cat.name, command)
+		}
+	}
+	return nil
+}
+
+// ParseRequest joins commands with an argument like changeTo/2
+func (blockCategory) ParseRequest(req *http.Request) (control, command string, err error) {
+	control = mux.Vars(req)["control"]
+	command = mux.Vars(req)["command"]
+	if arg, ok := mux.Vars(req)["arg"]; ok {
+		command += "/" + arg
+	}
+	return
+}
+
+func (blockCategory) IsAllowed(c *Control, command string) bool {
+	return helpers.IsStringInSlice(command, c.Allowed)
+}
+
+// GetPath returns a path that sends a command to a Miniserver block
+func (cat blockCategory) GetPath(c *Control, command string) (string, error) {
+	cmd, arg, ok := cat.lookup(command)
+	if !ok {
+		return "", fmt.Errorf("Unknown command for %s: %s", cat.name, command)
+	}
+	miniserverCommand := cmd.Command
+	if cmd.HasArg {
+		miniserverCommand += "/" + arg
+	}
+	ep := fmt.Sprintf("%s/%s/%s",
+		virtualInputBasePath,
+		url.PathEscape(c.GetAddress()),
+		miniserverCommand,
+	)
+	return ep, nil
+}
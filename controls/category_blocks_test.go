@@ -0,0 +1,63 @@
+package controls
+
+import "testing"
+
+func Test_blockCategory_GetPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		category string
+		command  string
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "JalousieFullUp",
+			category: "jalousie",
+			command:  "fullup",
+			wantPath: "/dev/sps/io/Jalousie%20Kitchen/FullUp",
+		},
+		{
+			name:     "JalousieShade",
+			category: "jalousie",
+			command:  "Shade",
+			wantPath: "/dev/sps/io/Jalousie%20Kitchen/shade",
+		},
+		{
+			name:     "LightcontrollerMood",
+			category: "lightcontroller",
+			command:  "changeTo/778",
+			wantPath: "/dev/sps/io/Jalousie%20Kitchen/changeTo/778",
+		},
+		{
+			name:     "LightcontrollerMoodInvalid",
+			category: "lightcontroller",
+			command:  "changeTo/../../sys",
+			wantErr:  true,
+		},
+		{
+			name:     "GateArgument",
+			category: "gate",
+			command:  "open/1",
+			wantErr:  true,
+		},
+		{
+			name:     "WrongCategory",
+			category: "gate",
+			command:  "fullup",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Control{Name: "Jalousie Kitchen"}
+			got, err := blockCategory{name: tt.category}.GetPath(c, tt.command)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("blockCategory.GetPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.wantPath {
+				t.Errorf("blockCategory.GetPath() = %s, want %s", got, tt.wantPath)
+			}
+		})
+	}
+}
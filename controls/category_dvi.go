@@ -0,0 +1,98 @@
+package controls
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+var virtualInputBasePath = "/dev/sps/io"
+
+// dviCommands maps the commands that can be allowed for a digital virtual
+// input to the command sent to the Miniserver. An empty value forwards the
+// command as it is configured.
+// Loxone documentation for allowed commands: https://www.loxone.com/enen/kb/web-services/
+var dviCommands = map[string]string{
+	"0":           "",
+	"1":           "",
+	"ein":         "On",
+	"on":          "On",
+	"aus":         "Off",
+	"off":         "Off",
+	"impuls":      "Pulse",
+	"pulse":       "Pulse",
+	"impulsplus":  "",
+	"impulsminus": "",
+	"pulseup":     "",
+	"pulsedown":   "",
+	"impulsauf":   "",
+	"impulsab":    "",
+	"pulseopen":   "",
+	"pulseclose":  "",
+	"plusein":     "",
+	"plusaus":     "",
+	"upon":        "",
+	"upoff":       "",
+	"aufein":      "",
+	"aufaus":      "",
+	"openon":      "",
+	"openoff":     "",
+	"minusein":    "",
+	"minusaus":    "",
+	"downon":      "",
+	"downoff":     "",
+	"abein":       "",
+	"abaus":       "",
+	"closeon":     "",
+	"closeoff":    "",
+}
+
+type dviCategory struct{}
+
+func init() {
+	RegisterCategory("dvi", dviCategory{})
+}
+
+func (dviCategory) Routes() []string {
+	return []string{"/{control}/{command}"}
+}
+
+func (dviCategory) Validate(c *Control) ControlError {
+	for _, command := range c.Allowed {
+		if _, ok := dviCommands[strings.ToLower(command)]; !ok {
+			return newInvalidCommandError("dvi", command)
+		}
+	}
+	return nil
+}
+
+func (dviCategory) ParseRequest(req *http.Request) (control, command string, err error) {
+	control = mux.Vars(req)["control"]
+	command = mux.Vars(req)["command"]
+	return
+}
+
+func (dviCategory) IsAllowed(c *Control, command string) bool {
+	return helpers.IsStringInSlice(command, c.Allowed)
+}
+
+func (dviCategory) GetPath(c *Control, command string) (string, error) {
+	cmd, ok := dviCommands[strings.ToLower(command)]
+	if !ok {
+		return "", fmt.Errorf("Unknown command for digital virtual input: %s", command)
+	}
+	if cmd == "" {
+		cmd = command
+	}
+	ep := fmt.Sprintf("%s/%s/%s",
+		virtualInputBasePath,
+		url.PathEscape(c.GetAddress()),
+		cmd,
+	)
+	return ep, nil
+}
@@ -0,0 +1,75 @@
+package controls
+
+import "testing"
+
+func Test_dviCategory_GetPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		c        *Control
+		command  string
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "VirtualInput",
+			c:        &Control{ID: 7},
+			command:  "pulse",
+			wantPath: "/dev/sps/io/VI7/Pulse",
+		},
+		{
+			name:     "GermanAlias",
+			c:        &Control{ID: 7},
+			command:  "ein",
+			wantPath: "/dev/sps/io/VI7/On",
+		},
+		{
+			name:     "UUID",
+			c:        &Control{UUID: "0f7a1bc2-0123-4567-ffff403fb0c34b9e"},
+			command:  "pulse",
+			wantPath: "/dev/sps/io/0f7a1bc2-0123-4567-ffff403fb0c34b9e/Pulse",
+		},
+		{
+			name:     "Name",
+			c:        &Control{Name: "Light Kitchen"},
+			command:  "pulse",
+			wantPath: "/dev/sps/io/Light%20Kitchen/Pulse",
+		},
+		{
+			// Every command accepted by Validate must be accepted by GetPath
+			name:     "ForwardedCommand",
+			c:        &Control{ID: 7},
+			command:  "ImpulsPlus",
+			wantPath: "/dev/sps/io/VI7/ImpulsPlus",
+		},
+		{
+			name:    "UnknownCommand",
+			c:       &Control{ID: 7},
+			command: "FullUp",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dviCategory{}.GetPath(tt.c, tt.command)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dviCategory.GetPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.wantPath {
+				t.Errorf("dviCategory.GetPath() = %s, want %s", got, tt.wantPath)
+			}
+		})
+	}
+}
+
+func Test_dviCategory_ValidateMatchesGetPath(t *testing.T) {
+	for command := range dviCommands {
+		c := &Control{ID: 1, Allowed: []string{command}}
+		if err := (dviCategory{}).Validate(c); err != nil {
+			t.Errorf("dviCategory.Validate() error = %v", err)
+		}
+		if _, err := (dviCategory{}).GetPath(c, command); err != nil {
+			t.Errorf("dviCategory.GetPath() error = %v", err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package controls
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var stateBasePath = "/jdev/sps/io"
+
+var valueWithUnit = regexp.MustCompile(`^(-?[0-9]+(?:\.[0-9]+)?)\s*(.*)$`)
+
+// stateCategory is read-only and returns the state of a Miniserver output as JSON
+type stateCategory struct{}
+
+func init() {
+	RegisterCategory("state", stateCategory{})
+}
+
+func (stateCategory) Routes() []string {
+	return []string{"/{control}"}
+}
+
+func (stateCategory) Validate(c *Control) ControlError {
+	return nil
+}
+
+func (stateCategory) ParseRequest(req *http.Request) (control, command string, err error) {
+	control = mux.Vars(req)["control"]
+	if req.Method != http.MethodGet {
+		err = fmt.Errorf("Method %s not allowed for state queries", req.Method)
+	}
+	return
+}
+
+// IsAllowed always returns true because state queries have no commands
+func (stateCategory) IsAllowed(c *Control, command string) bool {
+	return true
+}
+
+// GetPath returns a path that queries the state of an output from the Miniserver
+func (stateCategory) GetPath(c *Control, command string) (string, error) {
+	ep := fmt.Sprintf("%s/%s/state",
+		stateBasePath,
+		url.PathEscape(c.GetAddress()),
+	)
+	return ep, nil
+}
+
+// ConvertResponse converts the LoxLIVE response of the Miniserver to JSON
+func (stateCategory) ConvertResponse(control string, body []byte) (string, []byte, error) {
+	state, err := newStateResponse(control, body)
+	if err != nil {
+		return "", nil, err
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		return "", nil, err
+	}
+	return "application/json", append(content, '\n'), nil
+}
+
+// stateResponse is returned to the client as JSON
+type stateResponse struct {
+	Control string      `json:"control"`
+	Value   interface{} `json:"value"`
+	Unit    string      `json:"unit"`
+}
+
+// loxLiveJSON and loxLiveXML hold the interesting parts of a Miniserver answer.
+// The Miniserver answers /jdev/ requests with JSON and /dev/ requests with XML.
+type loxLiveJSON struct {
+	LL struct {
+		Control string      `json:"control"`
+		Value   interface{} `json:"value"`
+		Code    interface{} `json:"Code"`
+	}
+}
+
+type loxLiveXML struct {
+	XMLName xml.Name `xml:"LL"`
+	Control string   `xml:"control,attr"`
+	Value   string   `xml:"value,attr"`
+	Code    string   `xml:"Code,attr"`
+}
+
+// parseLoxLiveResponse parses the JSON or XML response of the Miniserver and
+// returns the value and the response code
+func parseLoxLiveResponse(body []byte) (value string, code int, err error) {
+	var rawValue, rawCode interface{}
+	trimmed := strings.TrimSpace(string(body))
+	if strings.HasPrefix(trimmed, "{") {
+		var resp loxLiveJSON
+		if err = json.Unmarshal([]byte(trimmed), &resp); err != nil {
+			return "", 0, fmt.Errorf("Cannot parse Miniserver response: %s", err)
+		}
+		rawValue = resp.LL.Value
+		rawCode = resp.LL.Code
+	} else {
+		var resp loxLiveXML
+		if err = xml.Unmarshal([]byte(trimmed), &resp); err != nil {
+			return "", 0, fmt.Errorf("Cannot parse Miniserver response: %s", err)
+		}
+		rawValue = resp.Value
+		rawCode = resp.Code
+	}
+	code, err = strconv.Atoi(fmt.Sprint(rawCode))
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid code in Miniserver response: %v", rawCode)
+	}
+	if rawValue == nil {
+		return "", code, nil
+	}
+	return fmt.Sprint(rawValue), code, nil
+}
+
+// splitValueUnit returns value as number if it starts with a number.
+// Everything after the number is returned as unit.
+func splitValueUnit(value string) (interface{}, string) {
+	value = strings.TrimSpace(value)
+	m := valueWithUnit.FindStringSubmatch(value)
+	if m == nil {
+		return value, ""
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return value, ""
+	}
+	return v, m[2]
+}
+
+func newStateResponse(control string, body []byte) (*stateResponse, error) {
+	value, code, err := parseLoxLiveResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("Miniserver responded with code %d", code)
+	}
+	v, unit := splitValueUnit(value)
+	return &stateResponse{
+		Control: control,
+		Value:   v,
+		Unit:    unit,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package controls
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_newStateResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    *stateResponse
+		wantErr bool
+	}{
+		{
+			name: "JSONNumber",
+			body: `{"LL": {"control": "dev/sps/io/Temperature/state", "value": "21.5", "Code": "200"}}`,
+			want: &stateResponse{Control: "temp", Value: 21.5, Unit: ""},
+		},
+		{
+			name: "JSONNumberWithUnit",
+			body: `{"LL": {"control": "dev/sps/io/Temperature/state", "value": "21.5°C", "Code": 200}}`,
+			want: &stateResponse{Control: "temp", Value: 21.5, Unit: "°C"},
+		},
+		{
+			name: "JSONText",
+			body: `{"LL": {"control": "dev/sps/io/Alarm/state", "value": "armed", "Code": "200"}}`,
+			want: &stateResponse{Control: "temp", Value: "armed", Unit: ""},
+		},
+		{
+			name: "XMLNumberWithUnit",
+			body: `<?xml version="1.0" encoding="utf-8"?><LL control="dev/sps/io/Temperature/state" value="-3.5 °C" Code="200"/>`,
+			want: &stateResponse{Control: "temp", Value: -3.5, Unit: "°C"},
+		},
+		{
+			name:    "ErrorCode",
+			body:    `{"LL": {"control": "dev/sps/io/Unknown/state", "value": "", "Code": "404"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Garbage",
+			body:    `Not a LoxLIVE response`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newStateResponse("temp", []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newStateResponse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newStateResponse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_stateCategory_GetPath(t *testing.T) {
+	c := &Control{Name: "Temperature Living Room"}
+	want := "/jdev/sps/io/Temperature%20Living%20Room/state"
+	got, err := stateCategory{}.GetPath(c, "")
+	if err != nil {
+		t.Errorf("stateCategory.GetPath() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("stateCategory.GetPath() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package controls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// maxTextBodySize limits the size of request bodies for virtual text inputs
+const maxTextBodySize = 4096
+
+type vtiCategory struct{}
+
+func init() {
+	RegisterCategory("vti", vtiCategory{})
+}
+
+func (vtiCategory) Routes() []string {
+	return []string{
+		"/{control}",
+		"/{control}/{text}",
+	}
+}
+
+func (vtiCategory) Validate(c *Control) ControlError {
+	if c.MaxLength < 1 || c.MaxLength > 1024 {
+		return newInvalidRangeError("vti", "MaxLength must be between 1 and 1024")
+	}
+	switch c.Charset {
+	case
+		"",
+		"printable",
+		"ascii",
+		"alnum":
+		// Nothing to do
+	default:
+		return newInvalidCharsetError("vti", c.Charset)
+	}
+	return nil
+}
+
+// ParseRequest takes the text from the path, the query parameter "text" or
+// the body of a POST request.
+func (vtiCategory) ParseRequest(req *http.Request) (control, command string, err error) {
+	control = mux.Vars(req)["control"]
+	if t, ok := mux.Vars(req)["text"]; ok {
+		command = t
+		return
+	}
+	if t, ok := req.URL.Query()["text"]; ok {
+		command = t[0]
+		return
+	}
+	if req.Method != http.MethodPost {
+		err = fmt.Errorf("Request without text")
+		return
+	}
+	req.Body = http.MaxBytesReader(nil, req.Body, maxTextBodySize)
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err = req.ParseForm(); err != nil {
+			err = fmt.Errorf("Cannot read request body: %s", err)
+			return
+		}
+		command = req.PostForm.Get("text")
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		err = fmt.Errorf("Cannot read request body: %s", err)
+		return
+	}
+	command = string(body)
+	return
+}
+
+// IsAllowed always returns true because texts are checked against the
+// limits of the control in GetPath
+func (vtiCategory) IsAllowed(c *Control, command string) bool {
+	return true
+}
+
+func isAllowedRune(r rune, charset string) bool {
+	switch charset {
+	case "ascii":
+		return r >= ' ' && r <= '~'
+	case "alnum":
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' '
+	default:
+		return unicode.IsPrint(r)
+	}
+}
+
+// sanitizeText checks text against the length and charset of c
+func (vtiCategory) sanitizeText(c *Control, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", fmt.Errorf("Empty text for virtual text input")
+	}
+	if !utf8.ValidString(text) {
+		return "", fmt.Errorf("Text for virtual text input is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(text) > c.MaxLength {
+		return "", fmt.Errorf("Text is longer than %d characters", c.MaxLength)
+	}
+	for _, r := range text {
+		if !isAllowedRune(r, c.Charset) {
+			return "", fmt.Errorf("Text contains a character that is not allowed: %q", r)
+		}
+	}
+	return text, nil
+}
+
+func (cat vtiCategory) GetPath(c *Control, command string) (string, error) {
+	text, err := cat.sanitizeText(c, command)
+	if err != nil {
+		return "", err
+	}
+	ep := fmt.Sprintf("%s/%s/%s",
+		virtualInputBasePath,
+		url.PathEscape(c.GetAddress()),
+		url.PathEscape(text),
+	)
+	return ep, nil
+}
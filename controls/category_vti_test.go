@@ -0,0 +1,141 @@
+package controls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_vtiCategory_GetPath(t *testing.T) {
+	c := &Control{
+		Category:  "vti",
+		ID:        5,
+		MaxLength: 10,
+	}
+	cAlnum := *c
+	cAlnum.Charset = "alnum"
+	tests := []struct {
+		name     string
+		c        *Control
+		text     string
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "Simple",
+			c:        c,
+			text:     "Home",
+			wantPath: "/dev/sps/io/VI5/Home",
+		},
+		{
+			name:     "EscapeSpecialCharacters",
+			c:        c,
+			text:     " a/b?c d ",
+			wantPath: "/dev/sps/io/VI5/a%2Fb%3Fc%20d",
+		},
+		{
+			name:     "Unicode",
+			c:        c,
+			text:     "Büro",
+			wantPath: "/dev/sps/io/VI5/B%C3%BCro",
+		},
+		{
+			name:    "TooLong",
+			c:       c,
+			text:    "12345678901",
+			wantErr: true,
+		},
+		{
+			name:    "Empty",
+			c:       c,
+			text:    "  ",
+			wantErr: true,
+		},
+		{
+			name:    "ControlCharacter",
+			c:       c,
+			text:    "a\nb",
+			wantErr: true,
+		},
+		{
+			name:    "AlnumRejectsPunctuation",
+			c:       &cAlnum,
+			text:    "a/b",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := vtiCategory{}.GetPath(tt.c, tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("vtiCategory.GetPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.wantPath {
+				t.Errorf("vtiCategory.GetPath() = %s, want %s", got, tt.wantPath)
+			}
+		})
+	}
+}
+
+func Test_vtiCategory_ParseRequest(t *testing.T) {
+	form := httptest.NewRequest("POST", "/vti/test?k=key", strings.NewReader("text=At+home"))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tests := []struct {
+		name     string
+		req      *http.Request
+		vars     map[string]string
+		wantText string
+		wantErr  bool
+	}{
+		{
+			name:     "Path",
+			req:      httptest.NewRequest("GET", "/vti/test/Home?k=key", nil),
+			vars:     map[string]string{"control": "test", "text": "Home"},
+			wantText: "Home",
+		},
+		{
+			name:     "Query",
+			req:      httptest.NewRequest("GET", "/vti/test?k=key&text=At+work", nil),
+			vars:     map[string]string{"control": "test"},
+			wantText: "At work",
+		},
+		{
+			name:     "Body",
+			req:      httptest.NewRequest("POST", "/vti/test?k=key", strings.NewReader("At work")),
+			vars:     map[string]string{"control": "test"},
+			wantText: "At work",
+		},
+		{
+			name:     "Form",
+			req:      form,
+			vars:     map[string]string{"control": "test"},
+			wantText: "At home",
+		},
+		{
+			name:    "NoText",
+			req:     httptest.NewRequest("GET", "/vti/test?k=key", nil),
+			vars:    map[string]string{"control": "test"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := mux.SetURLVars(tt.req, tt.vars)
+			control, text, err := vtiCategory{}.ParseRequest(req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("vtiCategory.ParseRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if control != "test" || text != tt.wantText {
+				t.Errorf("vtiCategory.ParseRequest() = %s, %s", control, text)
+			}
+		})
+	}
+}
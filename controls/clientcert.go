@@ -0,0 +1,66 @@
+package controls
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Client certificates are mapped to auth keys by identities in ClientCerts.
+// An identity is one of
+//
+//	cn:<common name>          Common name of the subject
+//	subject:<subject>         Complete subject like CN=door,O=Home (RFC 2253)
+//	san:<name>                DNS name, email address, IP address or URI of the
+//	                          subject alternative names
+//	sha256:<fingerprint>      SHA-256 fingerprint of the certificate in hex
+//
+// Only certificates signed by ClientCAFile of the config are mapped.
+
+// normalizeCertIdentity returns the identity in the form returned by
+// certIdentities
+func normalizeCertIdentity(s string) (string, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", fmt.Errorf("Client certificate identity %s has no type", s)
+	}
+	kind, value := strings.ToLower(s[:i]), s[i+1:]
+	if value == "" {
+		return "", fmt.Errorf("Client certificate identity %s is empty", s)
+	}
+	switch kind {
+	case "cn", "subject", "san":
+	case "sha256":
+		value = strings.ToLower(strings.Replace(value, ":", "", -1))
+		if b, err := hex.DecodeString(value); err != nil || len(b) != sha256.Size {
+			return "", fmt.Errorf("Invalid sha256 fingerprint %s", s)
+		}
+	default:
+		return "", fmt.Errorf("Unknown type of client certificate identity %s", s)
+	}
+	return kind + ":" + value, nil
+}
+
+// certIdentities returns all identities of cert
+func certIdentities(cert *x509.Certificate) []string {
+	ids := []string{"subject:" + cert.Subject.String()}
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, "cn:"+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		ids = append(ids, "san:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		ids = append(ids, "san:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		ids = append(ids, "san:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		ids = append(ids, "san:"+uri.String())
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return append(ids, "sha256:"+hex.EncodeToString(sum[:]))
+}
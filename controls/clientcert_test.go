@@ -0,0 +1,101 @@
+package controls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCert(t *testing.T, cn string, dnsNames ...string) *x509.Certificate {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Home"}},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestAuthKeyIndex_LookupCert(t *testing.T) {
+	door := newTestCert(t, "door", "door.home.example")
+	garage := newTestCert(t, "garage")
+	unknown := newTestCert(t, "unknown")
+	garageIDs := certIdentities(garage)
+	idx := NewAuthKeyIndex(map[string]AuthKey{
+		"byCN":      {Mode: "cert", ClientCerts: []string{"cn:door"}},
+		"bySAN":     {Key: "43b2c690-f281-42bb-af2d-979f5dbe9517", ClientCerts: []string{"san:door.home.example"}},
+		"bySubject": {Mode: "cert", ClientCerts: []string{"subject:CN=garage,O=Home"}},
+		"byHash":    {Mode: "cert", ClientCerts: []string{garageIDs[len(garageIDs)-1]}},
+	})
+	tests := []struct {
+		name      string
+		cert      *x509.Certificate
+		preferred []string
+		want      string
+		wantOk    bool
+	}{
+		{
+			name:   "FirstMatch",
+			cert:   door,
+			want:   "byCN",
+			wantOk: true,
+		},
+		{
+			name:      "Preferred",
+			cert:      door,
+			preferred: []string{"bySAN"},
+			want:      "bySAN",
+			wantOk:    true,
+		},
+		{
+			name:      "Fingerprint",
+			cert:      garage,
+			preferred: []string{"byHash"},
+			want:      "byHash",
+			wantOk:    true,
+		},
+		{
+			name:      "Subject",
+			cert:      garage,
+			preferred: []string{"bySubject"},
+			want:      "bySubject",
+			wantOk:    true,
+		},
+		{
+			name:   "Unknown",
+			cert:   unknown,
+			want:   "",
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotOk := idx.LookupCert(tt.cert, tt.preferred)
+			if got != tt.want || gotOk != tt.wantOk {
+				t.Errorf("AuthKeyIndex.LookupCert() = %s, %v, want %s, %v", got, gotOk, tt.want, tt.wantOk)
+			}
+		})
+	}
+	// Keys without secret must not match empty request keys
+	if name, ok := idx.Lookup(""); ok {
+		t.Errorf("AuthKeyIndex.Lookup(\"\") = %s, want no match", name)
+	}
+}
@@ -2,22 +2,39 @@ package controls
 
 import (
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/pkg/errors"
+
+	"github.com/axxelG/loxwebhook/helpers"
 )
 
 type controlImport struct {
-	AuthKeys map[string]string
+	AuthKeys map[string]AuthKey
 	Controls map[string]Control
 }
 
 func (ci controlImport) Validate() ControlError {
 	validName := regexp.MustCompile(`^[0-9a-zA-z_-]+$`)
+	keyIDs := make(map[string]string)
+	for name, k := range ci.AuthKeys {
+		if err := k.Validate(name); err != nil {
+			return err
+		}
+		ci.AuthKeys[name] = k
+		if id := k.keyID(); id != "" {
+			if other, ok := keyIDs[id]; ok {
+				return newInvalidAuthKeyConfigError(name, "Key id "+id+" is already used by "+other)
+			}
+			keyIDs[id] = name
+		}
+	}
 	for name, c := range ci.Controls {
 		if !validName.MatchString(name) {
 			return newInvalidControlNameError(name)
@@ -26,6 +43,7 @@ func (ci controlImport) Validate() ControlError {
 		if err != nil {
 			return err
 		}
+		ci.Controls[name] = c
 		// Check if authKey configured in this control exists
 		for _, c := range ci.Controls {
 			for _, t := range c.AuthKeys {
@@ -38,78 +56,118 @@ func (ci controlImport) Validate() ControlError {
 	return nil
 }
 
-// Control holds the config for one Miniserver control
+// Control holds the config for one Miniserver control.
+// The Miniserver control is addressed by exactly one of ID, UUID or Name.
 type Control struct {
-	Category string
-	ID       int
-	Allowed  []string
-	AuthKeys []string
+	Category   string
+	ID         int      // Number of a virtual input (VI<ID>)
+	UUID       string   // Loxone UUID of a block or input
+	Name       string   // Loxone name of a block, input or output
+	Allowed    []string // dvi and block categories only
+	AuthKeys   []string
+	Min        float64 // avi only
+	Max        float64 // avi only
+	Step       float64 // avi only, 0 allows any value between Min and Max
+	Precision  int     // avi only, number of allowed decimal places
+	MaxLength  int     // vti only, maximum number of characters
+	Charset    string  // vti only, allowed characters: printable (default), ascii or alnum
+	Miniserver string  // Name of the Miniserver, empty for the default Miniserver
+	RateLimit  int     // Requests per minute, 0 uses RateLimitControl of the config
+	RateBurst  int     // 0 uses RateBurstControl of the config
+	// Client IPs and networks (CIDR) the control accepts requests from. Empty allows all.
+	AllowedNetworks []string
+	networks        []*net.IPNet // Parsed AllowedNetworks, set by Validate
+}
+
+// AllowsIP returns true if a client with ip may use the control
+func (c *Control) AllowsIP(ip net.IP) bool {
+	return allowsIP(c.networks, ip)
 }
 
-func (c *Control) validateAllowedCommandsDvi() ControlError {
-	// Loxone documentation for allowed commands: https://www.loxone.com/enen/kb/web-services/
-	for _, command := range c.Allowed {
-		switch strings.ToLower(command) {
-		case
-			"0",
-			"1",
-			"on",
-			"off",
-			"impuls",
-			"pulse",
-			"impulsplus",
-			"impulsminus",
-			"pulseup",
-			"pulsedown",
-			"impulsauf",
-			"impulsab",
-			"pulseopen",
-			"pulseclose",
-			"plusein",
-			"plusaus",
-			"upon",
-			"upoff",
-			"aufein",
-			"aufaus",
-			"openon",
-			"openoff",
-			"minusein",
-			"minusaus",
-			"downon",
-			"downoff",
-			"abein",
-			"abaus",
-			"closeon",
-			"closeoff":
-			// Nothing to do
-		default:
-			return newInvalidCommandError("dvi", command)
+// allowsIP returns true if networks is empty or contains ip
+func allowsIP(networks []*net.IPNet, ip net.IP) bool {
+	if len(networks) == 0 {
+		return true
+	}
+	return ip != nil && helpers.IsIPInNetworks(ip, networks)
+}
+
+// parseNetworks parses the AllowedNetworks of a key or control. An empty
+// list stays nil.
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return helpers.ParseNetworks(list)
+}
+
+// GetAddress returns the identifier used to address the control on the Miniserver
+func (c *Control) GetAddress() string {
+	switch {
+	case c.UUID != "":
+		return c.UUID
+	case c.Name != "":
+		return c.Name
+	default:
+		return "VI" + strconv.Itoa(c.ID)
+	}
+}
+
+func (c *Control) validateAddress() ControlError {
+	// Loxone UUIDs look like 0f7a1bc2-0123-4567-ffff403fb0c34b9e
+	validUUID := regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{16}$`)
+	modes := 0
+	if c.ID != 0 {
+		modes++
+		if c.ID < 0 {
+			return newInvalidAddressError("ID must be greater than 0")
+		}
+	}
+	if c.UUID != "" {
+		modes++
+		if !validUUID.MatchString(c.UUID) {
+			return newInvalidAddressError("Invalid UUID " + c.UUID)
+		}
+	}
+	if c.Name != "" {
+		modes++
+		if strings.TrimSpace(c.Name) == "" {
+			return newInvalidAddressError("Name must not be blank")
 		}
 	}
+	if modes != 1 {
+		return newInvalidAddressError("Exactly one of ID, UUID or Name must be set")
+	}
 	return nil
 }
 
-// Validate returns an error if a control contains invalid data
+// Validate returns an error if a control contains invalid data. It parses
+// AllowedNetworks for AllowsIP.
 func (c *Control) Validate() ControlError {
 	if len(c.AuthKeys) < 1 {
 		return newNoAuthKeysError()
 	}
-	switch c.Category {
-	case
-		"dvi":
-		if err := c.validateAllowedCommandsDvi(); err != nil {
-			return err
-		}
-	default:
+	category, ok := GetCategory(c.Category)
+	if !ok {
 		return newInvalidCategoryError(c.Category)
 	}
-
-	return nil
+	if err := c.validateAddress(); err != nil {
+		return err
+	}
+	networks, err := parseNetworks(c.AllowedNetworks)
+	if err != nil {
+		return newInvalidNetworkError(err.Error())
+	}
+	c.networks = networks
+	if c.RateLimit < 0 || c.RateBurst < 0 {
+		return newInvalidRangeError(c.Category, "RateLimit and RateBurst must not be negative")
+	}
+	return category.Validate(c)
 }
 
 // Read imports all *.toml files from dir (including subdirectories) and returns
 // authKeys and controls
-func Read(dir string) (map[string]string, map[string]Control, error) {
+func Read(dir string) (map[string]AuthKey, map[string]Control, error) {
 	var files []string
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if filepath.Ext(path) == ".toml" {
@@ -120,7 +178,7 @@ func Read(dir string) (map[string]string, map[string]Control, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	authKeys := make(map[string]string)
+	authKeys := make(map[string]AuthKey)
 	controls := make(map[string]Control)
 	impCtl := new(controlImport)
 	for _, fn := range files {
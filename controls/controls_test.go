@@ -1,6 +1,7 @@
 package controls
 
 import (
+	"net"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -13,7 +14,7 @@ func TestRead(t *testing.T) {
 	tests := []struct {
 		name         string
 		args         args
-		wantAuthKeys map[string]string
+		wantAuthKeys map[string]AuthKey
 		wantControls map[string]Control
 		wantErr      bool
 	}{
@@ -22,10 +23,10 @@ func TestRead(t *testing.T) {
 			args: args{
 				dir: filepath.Join("testdata", "OneFile"),
 			},
-			wantAuthKeys: map[string]string{
-				"testOne":   "43b2c690-f281-42bb-af2d-979f5dbe9517",
-				"testTwo":   "69b9a1ad-1224-4c93-8411-e88e65ebe582",
-				"testThree": "84627dbd-bd68-476f-9e53-35522285783b",
+			wantAuthKeys: map[string]AuthKey{
+				"testOne":   {Key: "43b2c690-f281-42bb-af2d-979f5dbe9517"},
+				"testTwo":   {Key: "69b9a1ad-1224-4c93-8411-e88e65ebe582"},
+				"testThree": {Key: "84627dbd-bd68-476f-9e53-35522285783b"},
 			},
 			wantControls: map[string]Control{
 				"test1": Control{
@@ -67,10 +68,10 @@ func TestRead(t *testing.T) {
 			args: args{
 				dir: filepath.Join("testdata", "ThreeFiles"),
 			},
-			wantAuthKeys: map[string]string{
-				"testOne":   "43b2c690-f281-42bb-af2d-979f5dbe9517",
-				"testTwo":   "69b9a1ad-1224-4c93-8411-e88e65ebe582",
-				"testThree": "84627dbd-bd68-476f-9e53-35522285783b",
+			wantAuthKeys: map[string]AuthKey{
+				"testOne":   {Key: "43b2c690-f281-42bb-af2d-979f5dbe9517"},
+				"testTwo":   {Key: "69b9a1ad-1224-4c93-8411-e88e65ebe582"},
+				"testThree": {Key: "84627dbd-bd68-476f-9e53-35522285783b"},
 			},
 			wantControls: map[string]Control{
 				"test1": Control{
@@ -173,6 +174,211 @@ func TestControl_Validate(t *testing.T) {
 			},
 			want: newInvalidCommandError("DummyCategory", "DummyCommand"),
 		},
+		{
+			name: "validAvi",
+			c: &Control{
+				Category:  "avi",
+				ID:        2,
+				Min:       5,
+				Max:       30,
+				Step:      0.5,
+				Precision: 1,
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "invalidAviMinMax",
+			c: &Control{
+				Category: "avi",
+				ID:       2,
+				Min:      30,
+				Max:      5,
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: newInvalidRangeError("DummyCategory", "DummyReason"),
+		},
+		{
+			name: "invalidAviStep",
+			c: &Control{
+				Category: "avi",
+				ID:       2,
+				Min:      0,
+				Max:      10,
+				Step:     -1,
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: newInvalidRangeError("DummyCategory", "DummyReason"),
+		},
+		{
+			name: "validVti",
+			c: &Control{
+				Category:  "vti",
+				ID:        3,
+				MaxLength: 32,
+				Charset:   "alnum",
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "invalidVtiMaxLength",
+			c: &Control{
+				Category: "vti",
+				ID:       3,
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: newInvalidRangeError("DummyCategory", "DummyReason"),
+		},
+		{
+			name: "invalidVtiCharset",
+			c: &Control{
+				Category:  "vti",
+				ID:        3,
+				MaxLength: 32,
+				Charset:   "emoji",
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: newInvalidCharsetError("DummyCategory", "DummyCharset"),
+		},
+		{
+			name: "validState",
+			c: &Control{
+				Category: "state",
+				Name:     "Temperature Living Room",
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "invalidStateWithoutName",
+			c: &Control{
+				Category: "state",
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: newInvalidAddressError("DummyReason"),
+		},
+		{
+			name: "validUUID",
+			c: &Control{
+				Category: "dvi",
+				UUID:     "0f7a1bc2-0123-4567-ffff403fb0c34b9e",
+				Allowed: []string{
+					"pulse",
+				},
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "invalidUUID",
+			c: &Control{
+				Category: "dvi",
+				UUID:     "not-a-uuid",
+				Allowed: []string{
+					"pulse",
+				},
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: newInvalidAddressError("DummyReason"),
+		},
+		{
+			name: "validName",
+			c: &Control{
+				Category: "dvi",
+				Name:     "Lighting Kitchen",
+				Allowed: []string{
+					"pulse",
+				},
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "invalidIDAndName",
+			c: &Control{
+				Category: "dvi",
+				ID:       1,
+				Name:     "Lighting Kitchen",
+				Allowed: []string{
+					"pulse",
+				},
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: newInvalidAddressError("DummyReason"),
+		},
+		{
+			name: "invalidNoAddress",
+			c: &Control{
+				Category: "dvi",
+				Allowed: []string{
+					"pulse",
+				},
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			},
+			want: newInvalidAddressError("DummyReason"),
+		},
+		{
+			name: "validAllowedNetworks",
+			c: &Control{
+				Category: "dvi",
+				ID:       1,
+				Allowed: []string{
+					"pulse",
+				},
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+				AllowedNetworks: []string{
+					"192.168.0.0/16",
+					"2001:db8::1",
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "invalidAllowedNetworks",
+			c: &Control{
+				Category: "dvi",
+				ID:       1,
+				Allowed: []string{
+					"pulse",
+				},
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+				AllowedNetworks: []string{
+					"192.168.0.0/33",
+				},
+			},
+			want: newInvalidNetworkError("DummyReason"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -199,8 +405,8 @@ func Test_controlImport_Validate(t *testing.T) {
 		{
 			name: "ValidName",
 			ci: controlImport{
-				AuthKeys: map[string]string{
-					"ValidAuthKey": "325ce159-0ddf-433a-966f-a94b313a7eb5",
+				AuthKeys: map[string]AuthKey{
+					"ValidAuthKey": {Key: "325ce159-0ddf-433a-966f-a94b313a7eb5"},
 				},
 				Controls: map[string]Control{
 					"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-": {
@@ -220,8 +426,8 @@ func Test_controlImport_Validate(t *testing.T) {
 		{
 			name: "InvalidNameSpace",
 			ci: controlImport{
-				AuthKeys: map[string]string{
-					"ValidAuthKey": "325ce159-0ddf-433a-966f-a94b313a7eb5",
+				AuthKeys: map[string]AuthKey{
+					"ValidAuthKey": {Key: "325ce159-0ddf-433a-966f-a94b313a7eb5"},
 				},
 				Controls: map[string]Control{
 					"No spaces please": {
@@ -241,8 +447,8 @@ func Test_controlImport_Validate(t *testing.T) {
 		{
 			name: "InvalidNamePlus",
 			ci: controlImport{
-				AuthKeys: map[string]string{
-					"ValidAuthKey": "325ce159-0ddf-433a-966f-a94b313a7eb5",
+				AuthKeys: map[string]AuthKey{
+					"ValidAuthKey": {Key: "325ce159-0ddf-433a-966f-a94b313a7eb5"},
 				},
 				Controls: map[string]Control{
 					"No+please": {
@@ -262,8 +468,8 @@ func Test_controlImport_Validate(t *testing.T) {
 		{
 			name: "InvalidNameColon",
 			ci: controlImport{
-				AuthKeys: map[string]string{
-					"ValidAuthKey": "325ce159-0ddf-433a-966f-a94b313a7eb5",
+				AuthKeys: map[string]AuthKey{
+					"ValidAuthKey": {Key: "325ce159-0ddf-433a-966f-a94b313a7eb5"},
 				},
 				Controls: map[string]Control{
 					"No:please": {
@@ -283,8 +489,8 @@ func Test_controlImport_Validate(t *testing.T) {
 		{
 			name: "ValidAuthKey",
 			ci: controlImport{
-				AuthKeys: map[string]string{
-					"ValidAuthKey": "325ce159-0ddf-433a-966f-a94b313a7eb5",
+				AuthKeys: map[string]AuthKey{
+					"ValidAuthKey": {Key: "325ce159-0ddf-433a-966f-a94b313a7eb5"},
 				},
 				Controls: map[string]Control{
 					"ControlName": {
@@ -304,8 +510,8 @@ func Test_controlImport_Validate(t *testing.T) {
 		{
 			name: "InvalidAuthKey",
 			ci: controlImport{
-				AuthKeys: map[string]string{
-					"ValidAuthKey": "325ce159-0ddf-433a-966f-a94b313a7eb5",
+				AuthKeys: map[string]AuthKey{
+					"ValidAuthKey": {Key: "325ce159-0ddf-433a-966f-a94b313a7eb5"},
 				},
 				Controls: map[string]Control{
 					"ControlName": {
@@ -335,3 +541,148 @@ func Test_controlImport_Validate(t *testing.T) {
 		})
 	}
 }
+
+func Test_controlImport_Validate_networks(t *testing.T) {
+	ci := controlImport{
+		AuthKeys: map[string]AuthKey{
+			"lan": {Key: "f6694286-66e6-4b79-8936-9e45284eba60", AllowedNetworks: []string{"192.168.0.0/16"}},
+		},
+		Controls: map[string]Control{
+			"garage": {Category: "dvi", ID: 1, Allowed: []string{"pulse"}, AuthKeys: []string{"lan"}, AllowedNetworks: []string{"2001:db8::1"}},
+		},
+	}
+	if err := ci.Validate(); err != nil {
+		t.Fatalf("controlImport.Validate() error = %v", err)
+	}
+	k, ctl := ci.AuthKeys["lan"], ci.Controls["garage"]
+	if !k.AllowsIP(net.ParseIP("192.168.1.2")) || k.AllowsIP(net.ParseIP("10.0.0.1")) || k.AllowsIP(nil) {
+		t.Errorf("AuthKey.AllowsIP() does not use the parsed AllowedNetworks")
+	}
+	if !ctl.AllowsIP(net.ParseIP("2001:db8::1")) || ctl.AllowsIP(net.ParseIP("2001:db8::2")) {
+		t.Errorf("Control.AllowsIP() does not use the parsed AllowedNetworks")
+	}
+}
+
+func TestControl_GetAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *Control
+		want string
+	}{
+		{
+			name: "ID",
+			c:    &Control{ID: 3},
+			want: "VI3",
+		},
+		{
+			name: "UUID",
+			c:    &Control{UUID: "0f7a1bc2-0123-4567-ffff403fb0c34b9e"},
+			want: "0f7a1bc2-0123-4567-ffff403fb0c34b9e",
+		},
+		{
+			name: "Name",
+			c:    &Control{Name: "Jalousie Kitchen"},
+			want: "Jalousie Kitchen",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.GetAddress(); got != tt.want {
+				t.Errorf("Control.GetAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestControl_ValidateAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		category string
+		command  string
+		want     bool
+	}{
+		{
+			name:     "DviPulse",
+			category: "dvi",
+			command:  "pulse",
+			want:     true,
+		},
+		{
+			name:     "DviCaseInsensitive",
+			category: "dvi",
+			command:  "Pulse",
+			want:     true,
+		},
+		{
+			name:     "JalousieFullUp",
+			category: "jalousie",
+			command:  "FullUp",
+			want:     true,
+		},
+		{
+			name:     "JalousieShade",
+			category: "jalousie",
+			command:  "shade",
+			want:     true,
+		},
+		{
+			name:     "JalousieDviCommand",
+			category: "jalousie",
+			command:  "pulse",
+			want:     false,
+		},
+		{
+			name:     "LightcontrollerMood",
+			category: "lightcontroller",
+			command:  "changeTo/2",
+			want:     true,
+		},
+		{
+			name:     "LightcontrollerMoodWithoutArg",
+			category: "lightcontroller",
+			command:  "changeTo",
+			want:     false,
+		},
+		{
+			name:     "LightcontrollerMoodInvalidArg",
+			category: "lightcontroller",
+			command:  "changeTo/bright",
+			want:     false,
+		},
+		{
+			name:     "GateOpen",
+			category: "gate",
+			command:  "open",
+			want:     true,
+		},
+		{
+			name:     "GateFullUp",
+			category: "gate",
+			command:  "FullUp",
+			want:     false,
+		},
+		{
+			name:     "UnknownCategory",
+			category: "unknown",
+			command:  "on",
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Control{
+				Category: tt.category,
+				ID:       1,
+				Allowed: []string{
+					tt.command,
+				},
+				AuthKeys: []string{
+					"f6694286-66e6-4b79-8936-9e45284eba60",
+				},
+			}
+			if got := c.Validate() == nil; got != tt.want {
+				t.Errorf("Control.Validate() = %v, want %v", c.Validate(), tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package controls
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // ControlError implements GetType() wich is used in tests to test the validation.
 type ControlError interface {
@@ -109,3 +112,218 @@ func newNoAuthKeysError() *NoAuthKeysError {
 		Name: "all",
 	}
 }
+
+// InvalidRangeError is an error type for invalid value ranges
+type InvalidRangeError struct {
+	Category string
+	Reason   string
+}
+
+// GetType returns a string containing the error Type
+func (e *InvalidRangeError) GetType() string {
+	return "InvalidRangeError"
+}
+
+func (e *InvalidRangeError) Error() string {
+	return fmt.Sprintf("Invalid range for type %s: %s", e.Category, e.Reason)
+}
+
+func newInvalidRangeError(category, reason string) *InvalidRangeError {
+	return &InvalidRangeError{
+		Category: category,
+		Reason:   reason,
+	}
+}
+
+// InvalidCharsetError is an error type for invalid charset policies
+type InvalidCharsetError struct {
+	Category string
+	Charset  string
+}
+
+// GetType returns a string containing the error Type
+func (e *InvalidCharsetError) GetType() string {
+	return "InvalidCharsetError"
+}
+
+func (e *InvalidCharsetError) Error() string {
+	return fmt.Sprintf("Invalid charset for type %s: %s", e.Category, e.Charset)
+}
+
+func newInvalidCharsetError(category, charset string) *InvalidCharsetError {
+	return &InvalidCharsetError{
+		Category: category,
+		Charset:  charset,
+	}
+}
+
+// InvalidAddressError is an error type for controls without a valid Miniserver address
+type InvalidAddressError struct {
+	Reason string
+}
+
+// GetType returns a string containing the error Type
+func (e *InvalidAddressError) GetType() string {
+	return "InvalidAddressError"
+}
+
+func (e *InvalidAddressError) Error() string {
+	return fmt.Sprintf("Invalid address: %s", e.Reason)
+}
+
+func newInvalidAddressError(reason string) *InvalidAddressError {
+	return &InvalidAddressError{
+		Reason: reason,
+	}
+}
+
+// InvalidAuthKeyConfigError is an error type for invalid settings of an authKey
+type InvalidAuthKeyConfigError struct {
+	Name   string
+	Reason string
+}
+
+// GetType returns a string containing the error Type
+func (e *InvalidAuthKeyConfigError) GetType() string {
+	return "InvalidAuthKeyConfigError"
+}
+
+func (e *InvalidAuthKeyConfigError) Error() string {
+	return fmt.Sprintf("Invalid settings for authKey %s: %s", e.Name, e.Reason)
+}
+
+func newInvalidAuthKeyConfigError(name, reason string) *InvalidAuthKeyConfigError {
+	return &InvalidAuthKeyConfigError{
+		Name:   name,
+		Reason: reason,
+	}
+}
+
+// AuthKeyDisabledError is returned for requests with a disabled authKey
+type AuthKeyDisabledError struct {
+	Name string
+}
+
+// GetType returns a string containing the error Type
+func (e *AuthKeyDisabledError) GetType() string {
+	return "AuthKeyDisabledError"
+}
+
+func (e *AuthKeyDisabledError) Error() string {
+	return fmt.Sprintf("AuthKey %s is disabled", e.Name)
+}
+
+func newAuthKeyDisabledError(name string) *AuthKeyDisabledError {
+	return &AuthKeyDisabledError{
+		Name: name,
+	}
+}
+
+// AuthKeyNotYetValidError is returned for requests before NotBefore of an authKey
+type AuthKeyNotYetValidError struct {
+	Name      string
+	NotBefore time.Time
+}
+
+// GetType returns a string containing the error Type
+func (e *AuthKeyNotYetValidError) GetType() string {
+	return "AuthKeyNotYetValidError"
+}
+
+func (e *AuthKeyNotYetValidError) Error() string {
+	return fmt.Sprintf("AuthKey %s is not valid before %s", e.Name, e.NotBefore.Format(time.RFC3339))
+}
+
+func newAuthKeyNotYetValidError(name string, notBefore time.Time) *AuthKeyNotYetValidError {
+	return &AuthKeyNotYetValidError{
+		Name:      name,
+		NotBefore: notBefore,
+	}
+}
+
+// AuthKeyExpiredError is returned for requests after ExpiresAt of an authKey
+type AuthKeyExpiredError struct {
+	Name      string
+	ExpiresAt time.Time
+}
+
+// GetType returns a string containing the error Type
+func (e *AuthKeyExpiredError) GetType() string {
+	return "AuthKeyExpiredError"
+}
+
+func (e *AuthKeyExpiredError) Error() string {
+	return fmt.Sprintf("AuthKey %s expired at %s", e.Name, e.ExpiresAt.Format(time.RFC3339))
+}
+
+func newAuthKeyExpiredError(name string, expiresAt time.Time) *AuthKeyExpiredError {
+	return &AuthKeyExpiredError{
+		Name:      name,
+		ExpiresAt: expiresAt,
+	}
+}
+
+// AuthKeyOutsideWindowError is returned for requests outside of the time
+// window of an authKey
+type AuthKeyOutsideWindowError struct {
+	Name   string
+	Window TimeWindow
+}
+
+// GetType returns a string containing the error Type
+func (e *AuthKeyOutsideWindowError) GetType() string {
+	return "AuthKeyOutsideWindowError"
+}
+
+func (e *AuthKeyOutsideWindowError) Error() string {
+	return fmt.Sprintf("AuthKey %s is only valid %s", e.Name, e.Window.String())
+}
+
+func newAuthKeyOutsideWindowError(name string, window TimeWindow) *AuthKeyOutsideWindowError {
+	return &AuthKeyOutsideWindowError{
+		Name:   name,
+		Window: window,
+	}
+}
+
+// AuthKeyUsesExceededError is returned if an authKey was used MaxUses times
+type AuthKeyUsesExceededError struct {
+	Name    string
+	MaxUses int
+}
+
+// GetType returns a string containing the error Type
+func (e *AuthKeyUsesExceededError) GetType() string {
+	return "AuthKeyUsesExceededError"
+}
+
+func (e *AuthKeyUsesExceededError) Error() string {
+	return fmt.Sprintf("AuthKey %s has been used %d times and is exhausted", e.Name, e.MaxUses)
+}
+
+func newAuthKeyUsesExceededError(name string, maxUses int) *AuthKeyUsesExceededError {
+	return &AuthKeyUsesExceededError{
+		Name:    name,
+		MaxUses: maxUses,
+	}
+}
+
+// InvalidNetworkError is an error type for invalid AllowedNetworks
+type InvalidNetworkError struct {
+	Reason string
+}
+
+// GetType returns a string containing the error Type
+func (e *InvalidNetworkError) GetType() string {
+	return "InvalidNetworkError"
+}
+
+func (e *InvalidNetworkError) Error() string {
+	return fmt.Sprintf("Invalid AllowedNetworks: %s", e.Reason)
+}
+
+func newInvalidNetworkError(reason string) *InvalidNetworkError {
+	return &InvalidNetworkError{
+		Reason: reason,
+	}
+}
@@ -0,0 +1,283 @@
+package controls
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+// KeyHashAlgorithms are the supported algorithms for hashed keys
+var KeyHashAlgorithms = []string{"argon2id", "bcrypt", "sha256"}
+
+// Parameters of new argon2id hashes
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// argon2MaxMemory is the maximum memory in KiB an argon2id hash may use
+const argon2MaxMemory = 256 * 1024
+
+// argon2Slots limits the concurrent argon2id verifications. Each one
+// allocates the memory set in the hash, 64 MiB for generated hashes.
+var argon2Slots = make(chan struct{}, 2)
+
+const (
+	keyIDLen     = 4  // random bytes of a key id
+	keySecretLen = 32 // random bytes of a generated key
+)
+
+// keyHash is a parsed hashed key. Hashed keys are stored as
+// [<key id>]$<algorithm>$<algorithm specific part>. The optional key id is
+// the part of the key in front of the first "." and allows to find the hash
+// without trying every configured key.
+type keyHash struct {
+	id        string
+	algorithm string
+	hash      string // Hash in its original encoding starting with $
+}
+
+// parseKeyHash returns the hash of a hashed key. ok is false for plain keys.
+func parseKeyHash(s string) (h keyHash, ok bool) {
+	i := strings.Index(s, "$")
+	if i < 0 {
+		return keyHash{}, false
+	}
+	h = keyHash{id: s[:i], hash: s[i:]}
+	switch {
+	case strings.HasPrefix(h.hash, "$argon2id$"):
+		h.algorithm = "argon2id"
+	case strings.HasPrefix(h.hash, "$2a$"), strings.HasPrefix(h.hash, "$2b$"), strings.HasPrefix(h.hash, "$2y$"):
+		h.algorithm = "bcrypt"
+	case strings.HasPrefix(h.hash, "$sha256$"):
+		h.algorithm = "sha256"
+	default:
+		return keyHash{}, false
+	}
+	return h, true
+}
+
+// check returns an error if the hash cannot be used to verify keys
+func (h keyHash) check() error {
+	switch h.algorithm {
+	case "argon2id":
+		_, _, _, err := decodeArgon2id(h.hash)
+		return err
+	case "bcrypt":
+		_, err := bcrypt.Cost([]byte(h.hash))
+		return err
+	case "sha256":
+		b, err := hex.DecodeString(strings.TrimPrefix(h.hash, "$sha256$"))
+		if err != nil || len(b) != sha256.Size {
+			return errors.New("Invalid sha256 hash")
+		}
+	}
+	return nil
+}
+
+// verify returns true if key matches the hash
+func (h keyHash) verify(key string) bool {
+	switch h.algorithm {
+	case "argon2id":
+		p, salt, hash, err := decodeArgon2id(h.hash)
+		if err != nil {
+			return false
+		}
+		argon2Slots <- struct{}{}
+		got := argon2.IDKey([]byte(key), salt, p.time, p.memory, p.threads, uint32(len(hash)))
+		<-argon2Slots
+		return subtle.ConstantTimeCompare(got, hash) == 1
+	case "bcrypt":
+		return bcrypt.CompareHashAndPassword([]byte(h.hash), []byte(key)) == nil
+	case "sha256":
+		want, err := hex.DecodeString(strings.TrimPrefix(h.hash, "$sha256$"))
+		if err != nil {
+			return false
+		}
+		got := sha256.Sum256([]byte(key))
+		return subtle.ConstantTimeCompare(got[:], want) == 1
+	}
+	return false
+}
+
+// expensive returns true for hashes that are too slow to try for every request
+func (h keyHash) expensive() bool {
+	return h.algorithm == "argon2id" || h.algorithm == "bcrypt"
+}
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// decodeArgon2id parses a hash in the PHC string format
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func decodeArgon2id(s string) (p argon2Params, salt, hash []byte, err error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errors.New("Invalid argon2id hash")
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errors.New("Unsupported argon2id version")
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, errors.Wrap(err, "Invalid argon2id parameters")
+	}
+	if p.memory == 0 || p.time == 0 || p.threads == 0 {
+		return p, nil, nil, errors.New("Invalid argon2id parameters")
+	}
+	if p.memory > argon2MaxMemory {
+		return p, nil, nil, fmt.Errorf("argon2id hash must not use more than %d KiB memory", argon2MaxMemory)
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, errors.Wrap(err, "Invalid argon2id salt")
+	}
+	if hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(hash) == 0 {
+		return p, nil, nil, errors.New("Invalid argon2id hash")
+	}
+	return p, salt, hash, nil
+}
+
+// getKeyID returns the key id of a key sent with a request
+func getKeyID(key string) string {
+	i := strings.Index(key, ".")
+	if i < 0 {
+		return ""
+	}
+	return key[:i]
+}
+
+// GenerateKey returns a new random key. The key starts with a random key id
+// followed by a "." and the secret.
+func GenerateKey() (string, error) {
+	b := make([]byte, keyIDLen+keySecretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "Cannot read random bytes")
+	}
+	return hex.EncodeToString(b[:keyIDLen]) + "." + base64.RawURLEncoding.EncodeToString(b[keyIDLen:]), nil
+}
+
+// HashKey returns the hash of key to be stored in a controls file. The key id
+// of key is put in front of the hash.
+func HashKey(key, algorithm string) (string, error) {
+	var hash string
+	switch algorithm {
+	case "argon2id":
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", errors.Wrap(err, "Cannot read random bytes")
+		}
+		h := argon2.IDKey([]byte(key), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		hash = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(h))
+	case "bcrypt":
+		h, err := bcrypt.GenerateFromPassword([]byte(key), bcrypt.DefaultCost)
+		if err != nil {
+			return "", errors.Wrap(err, "Cannot hash key")
+		}
+		hash = string(h)
+	case "sha256":
+		h := sha256.Sum256([]byte(key))
+		hash = "$sha256$" + hex.EncodeToString(h[:])
+	default:
+		return "", fmt.Errorf("Unknown hash algorithm %s", algorithm)
+	}
+	return getKeyID(key) + hash, nil
+}
+
+// AuthKeyIndex finds the auth key of a request. Keys with a key id are
+// looked up directly, only keys without key id are compared one by one.
+type AuthKeyIndex struct {
+	authKeys  map[string]AuthKey
+	byID      map[string]string   // key id -> name
+	byCert    map[string][]string // client certificate identity -> names
+	unindexed []string
+}
+
+// NewAuthKeyIndex returns an index of validated authKeys
+func NewAuthKeyIndex(authKeys map[string]AuthKey) *AuthKeyIndex {
+	idx := &AuthKeyIndex{
+		authKeys: authKeys,
+		byID:     make(map[string]string),
+		byCert:   make(map[string][]string),
+	}
+	for name, k := range authKeys {
+		for _, c := range k.ClientCerts {
+			id, _ := normalizeCertIdentity(c)
+			idx.byCert[id] = append(idx.byCert[id], name)
+		}
+		if k.GetMode() == "cert" {
+			// Keys without secret must never match a request key
+			continue
+		}
+		if id := k.keyID(); id != "" {
+			idx.byID[id] = name
+		} else {
+			idx.unindexed = append(idx.unindexed, name)
+		}
+	}
+	return idx
+}
+
+// Get returns the auth key with name
+func (idx *AuthKeyIndex) Get(name string) (AuthKey, bool) {
+	k, ok := idx.authKeys[name]
+	return k, ok
+}
+
+// Lookup returns the name of the auth key that matches key
+func (idx *AuthKeyIndex) Lookup(key string) (string, bool) {
+	if id := getKeyID(key); id != "" {
+		if name, ok := idx.byID[id]; ok {
+			if k := idx.authKeys[name]; k.Verify(key) {
+				return name, true
+			}
+		}
+	}
+	// All remaining keys are compared to not leak which one matched
+	var found string
+	for _, name := range idx.unindexed {
+		k := idx.authKeys[name]
+		if k.Verify(key) && found == "" {
+			found = name
+		}
+	}
+	return found, found != ""
+}
+
+// LookupCert returns the name of the auth key mapped to the client
+// certificate cert. If several keys are mapped, a key in preferred wins.
+func (idx *AuthKeyIndex) LookupCert(cert *x509.Certificate, preferred []string) (string, bool) {
+	var names []string
+	for _, id := range certIdentities(cert) {
+		names = append(names, idx.byCert[id]...)
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if helpers.IsStringInSlice(name, preferred) {
+			return name, true
+		}
+	}
+	return names[0], true
+}
@@ -0,0 +1,146 @@
+package controls
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHashKey(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	id := getKeyID(key)
+	if len(id) != 2*keyIDLen {
+		t.Fatalf("GenerateKey() = %s, want a key id of %d characters", key, 2*keyIDLen)
+	}
+	for _, algorithm := range KeyHashAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			hash, err := HashKey(key, algorithm)
+			if err != nil {
+				t.Fatalf("HashKey() error = %v", err)
+			}
+			if !strings.HasPrefix(hash, id+"$") {
+				t.Errorf("HashKey() = %s, want prefix %s$", hash, id)
+			}
+			k := AuthKey{Key: hash}
+			if err := k.Validate(algorithm); err != nil {
+				t.Fatalf("AuthKey.Validate() error = %v", err)
+			}
+			if !k.IsHashed() {
+				t.Errorf("AuthKey.IsHashed() = false, want true")
+			}
+			if k.keyID() != id {
+				t.Errorf("AuthKey.keyID() = %s, want %s", k.keyID(), id)
+			}
+			if !k.Verify(key) {
+				t.Errorf("AuthKey.Verify() = false for the generated key")
+			}
+			if k.Verify(key + "x") {
+				t.Errorf("AuthKey.Verify() = true for a wrong key")
+			}
+		})
+	}
+	if _, err := HashKey(key, "md5"); err == nil {
+		t.Errorf("HashKey() with unknown algorithm returned no error")
+	}
+}
+
+func TestAuthKeyIndex_Lookup(t *testing.T) {
+	hashed, err := HashKey("0badc0de.secret", "bcrypt")
+	if err != nil {
+		t.Fatalf("HashKey() error = %v", err)
+	}
+	cheap, err := HashKey("cheapSecret", "sha256")
+	if err != nil {
+		t.Fatalf("HashKey() error = %v", err)
+	}
+	idx := NewAuthKeyIndex(map[string]AuthKey{
+		"plain":  {Key: "43b2c690-f281-42bb-af2d-979f5dbe9517"},
+		"hashed": {Key: hashed},
+		"cheap":  {Key: cheap},
+	})
+	tests := []struct {
+		name   string
+		key    string
+		want   string
+		wantOk bool
+	}{
+		{
+			name:   "Plain",
+			key:    "43b2c690-f281-42bb-af2d-979f5dbe9517",
+			want:   "plain",
+			wantOk: true,
+		},
+		{
+			name:   "Indexed",
+			key:    "0badc0de.secret",
+			want:   "hashed",
+			wantOk: true,
+		},
+		{
+			name:   "Unindexed",
+			key:    "cheapSecret",
+			want:   "cheap",
+			wantOk: true,
+		},
+		{
+			name:   "WrongSecret",
+			key:    "0badc0de.wrong",
+			want:   "",
+			wantOk: false,
+		},
+		{
+			name:   "Unknown",
+			key:    "unknown",
+			want:   "",
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotOk := idx.Lookup(tt.key)
+			if got != tt.want || gotOk != tt.wantOk {
+				t.Errorf("AuthKeyIndex.Lookup() = %s, %v, want %s, %v", got, gotOk, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestKeyHash_verifyArgon2id(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	newHash := func(memory uint32) keyHash {
+		h := argon2.IDKey([]byte("secret"), salt, 1, memory, 1, argon2KeyLen)
+		h64 := base64.RawStdEncoding.EncodeToString(h)
+		hash, _ := parseKeyHash(fmt.Sprintf("$argon2id$v=%d$m=%d,t=1,p=1$%s$%s",
+			argon2.Version, memory, base64.RawStdEncoding.EncodeToString(salt), h64))
+		return hash
+	}
+	if err := newHash(argon2MaxMemory + 1).check(); err == nil {
+		t.Errorf("keyHash.check() error = nil for more than argon2MaxMemory")
+	}
+	h := newHash(64)
+	if err := h.check(); err != nil {
+		t.Fatalf("keyHash.check() error = %v", err)
+	}
+	// More verifications than argon2Slots wait for a free slot
+	var wg sync.WaitGroup
+	for i := 0; i < 3*cap(argon2Slots); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !h.verify("secret") {
+				t.Errorf("keyHash.verify() = false for the right key")
+			}
+		}()
+	}
+	wg.Wait()
+	if len(argon2Slots) != 0 {
+		t.Errorf("argon2Slots holds %d slots after all verifications", len(argon2Slots))
+	}
+}
@@ -0,0 +1,222 @@
+package controls
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// weekdays are the day names of a TimeWindow
+var weekdays = map[string]time.Weekday{
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+	"Sun": time.Sunday,
+}
+
+// TimeWindow limits an authKey to some hours of some days of the week in
+// local time. An authKey that is valid Mon-Fri from 08:00 to 18:00:
+//
+//	[AuthKeys.contractor.Window]
+//	Days = ["Mon", "Tue", "Wed", "Thu", "Fri"]
+//	From = "08:00"
+//	To = "18:00"
+type TimeWindow struct {
+	Days []string // Mon, Tue, Wed, Thu, Fri, Sat or Sun. Empty allows all days.
+	From string   // HH:MM, empty means 00:00
+	To   string   // HH:MM, exclusive. Empty means 24:00.
+}
+
+// IsSet returns true if the window limits the use of a key
+func (w *TimeWindow) IsSet() bool {
+	return len(w.Days) > 0 || w.From != "" || w.To != ""
+}
+
+// parseClock returns the minutes since midnight of a HH:MM string
+func parseClock(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("Invalid time %s, must be HH:MM", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("Invalid time %s, must be HH:MM", s)
+	}
+	return h*60 + m, nil
+}
+
+func (w *TimeWindow) validate() error {
+	for _, d := range w.Days {
+		if _, ok := weekdays[d]; !ok {
+			return fmt.Errorf("Unknown day %s in Window", d)
+		}
+	}
+	from, err := parseClock(w.From, 0)
+	if err != nil {
+		return err
+	}
+	to, err := parseClock(w.To, 24*60)
+	if err != nil {
+		return err
+	}
+	if from >= to {
+		return errors.New("From of Window must be before To")
+	}
+	return nil
+}
+
+// Contains returns true if t is inside of the window
+func (w *TimeWindow) Contains(t time.Time) bool {
+	if len(w.Days) > 0 {
+		found := false
+		for _, d := range w.Days {
+			if weekdays[d] == t.Weekday() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	// Windows are validated by Read
+	from, _ := parseClock(w.From, 0)
+	to, _ := parseClock(w.To, 24*60)
+	minute := t.Hour()*60 + t.Minute()
+	return minute >= from && minute < to
+}
+
+func (w TimeWindow) String() string {
+	days := "every day"
+	if len(w.Days) > 0 {
+		days = strings.Join(w.Days, ", ")
+	}
+	from, to := w.From, w.To
+	if from == "" {
+		from = "00:00"
+	}
+	if to == "" {
+		to = "24:00"
+	}
+	return fmt.Sprintf("%s from %s to %s", days, from, to)
+}
+
+// CheckLifecycle returns an error if the key called name must not be used at now
+func (k *AuthKey) CheckLifecycle(name string, now time.Time) ControlError {
+	if k.Disabled {
+		return newAuthKeyDisabledError(name)
+	}
+	if !k.NotBefore.IsZero() && now.Before(k.NotBefore) {
+		return newAuthKeyNotYetValidError(name, k.NotBefore)
+	}
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return newAuthKeyExpiredError(name, k.ExpiresAt)
+	}
+	if k.Window.IsSet() && !k.Window.Contains(now.In(time.Local)) {
+		return newAuthKeyOutsideWindowError(name, k.Window)
+	}
+	return nil
+}
+
+// UseCounter counts the uses of authKeys with MaxUses. The counts are saved
+// to a state file after every use to survive restarts.
+type UseCounter struct {
+	mu     sync.Mutex
+	file   string
+	counts map[string]int
+}
+
+type useCounterState struct {
+	Uses map[string]int
+}
+
+// NewUseCounter loads the counts from file. A missing file starts with no
+// uses. An empty file name keeps the counts in memory only.
+func NewUseCounter(file string) (*UseCounter, error) {
+	u := &UseCounter{
+		file:   file,
+		counts: make(map[string]int),
+	}
+	if file == "" {
+		return u, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot read state file")
+	}
+	var state useCounterState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrap(err, "Cannot parse state file")
+	}
+	for name, n := range state.Uses {
+		u.counts[name] = n
+	}
+	return u, nil
+}
+
+// Get returns how often the key called name was used
+func (u *UseCounter) Get(name string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.counts[name]
+}
+
+// Check returns an AuthKeyUsesExceededError if the key called name was
+// already used maxUses times
+func (u *UseCounter) Check(name string, maxUses int) error {
+	if u.Get(name) >= maxUses {
+		return newAuthKeyUsesExceededError(name, maxUses)
+	}
+	return nil
+}
+
+// Use counts one use of the key called name. It returns an
+// AuthKeyUsesExceededError if the key was already used maxUses times.
+func (u *UseCounter) Use(name string, maxUses int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.counts[name] >= maxUses {
+		return newAuthKeyUsesExceededError(name, maxUses)
+	}
+	u.counts[name]++
+	if err := u.save(); err != nil {
+		u.counts[name]--
+		return err
+	}
+	return nil
+}
+
+// save writes the counts to a temporary file and renames it to not leave a
+// broken state file behind
+func (u *UseCounter) save() error {
+	if u.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(useCounterState{Uses: u.counts})
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode state")
+	}
+	if err := os.MkdirAll(filepath.Dir(u.file), 0700); err != nil {
+		return errors.Wrap(err, "Cannot create state directory")
+	}
+	tmp := u.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "Cannot write state file")
+	}
+	return errors.Wrap(os.Rename(tmp, u.file), "Cannot write state file")
+}
@@ -0,0 +1,175 @@
+package controls
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestAuthKey_UnmarshalTOML_Lifecycle(t *testing.T) {
+	data := `
+[AuthKeys.guest]
+Key = "43b2c690-f281-42bb-af2d-979f5dbe9517"
+NotBefore = 2020-09-01T00:00:00Z
+ExpiresAt = 2020-10-01T00:00:00Z
+MaxUses = 10
+[AuthKeys.guest.Window]
+Days = ["Mon", "Fri"]
+From = "08:00"
+To = "18:00"
+`
+	want := AuthKey{
+		Key:       "43b2c690-f281-42bb-af2d-979f5dbe9517",
+		NotBefore: time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresAt: time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC),
+		MaxUses:   10,
+		Window:    TimeWindow{Days: []string{"Mon", "Fri"}, From: "08:00", To: "18:00"},
+	}
+	var ci controlImport
+	if err := toml.Unmarshal([]byte(data), &ci); err != nil {
+		t.Fatalf("toml.Unmarshal() error = %v", err)
+	}
+	got := ci.AuthKeys["guest"]
+	if !got.NotBefore.Equal(want.NotBefore) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("toml.Unmarshal() NotBefore, ExpiresAt = %v, %v, want %v, %v", got.NotBefore, got.ExpiresAt, want.NotBefore, want.ExpiresAt)
+	}
+	got.NotBefore, got.ExpiresAt = want.NotBefore, want.ExpiresAt
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("toml.Unmarshal() = %v, want %v", got, want)
+	}
+}
+
+func TestAuthKey_CheckLifecycle(t *testing.T) {
+	// Sunday
+	now := time.Date(2020, 9, 13, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name string
+		key  AuthKey
+		want string
+	}{
+		{
+			name: "NoLimits",
+			key:  AuthKey{},
+			want: "",
+		},
+		{
+			name: "Disabled",
+			key:  AuthKey{Disabled: true},
+			want: "AuthKeyDisabledError",
+		},
+		{
+			name: "NotYetValid",
+			key:  AuthKey{NotBefore: now.Add(time.Hour)},
+			want: "AuthKeyNotYetValidError",
+		},
+		{
+			name: "Expired",
+			key:  AuthKey{ExpiresAt: now},
+			want: "AuthKeyExpiredError",
+		},
+		{
+			name: "ValidPeriod",
+			key:  AuthKey{NotBefore: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+			want: "",
+		},
+		{
+			name: "InsideWindow",
+			key:  AuthKey{Window: TimeWindow{Days: []string{"Sat", "Sun"}, From: "12:00", To: "12:01"}},
+			want: "",
+		},
+		{
+			name: "WrongDay",
+			key:  AuthKey{Window: TimeWindow{Days: []string{"Mon", "Tue", "Wed", "Thu", "Fri"}}},
+			want: "AuthKeyOutsideWindowError",
+		},
+		{
+			name: "WrongTime",
+			key:  AuthKey{Window: TimeWindow{From: "08:00", To: "12:00"}},
+			want: "AuthKeyOutsideWindowError",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.key.CheckLifecycle(tt.name, now)
+			got := ""
+			if err != nil {
+				got = err.GetType()
+			}
+			if got != tt.want {
+				t.Errorf("AuthKey.CheckLifecycle() = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindow_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  TimeWindow
+		wantErr bool
+	}{
+		{
+			name:   "Empty",
+			window: TimeWindow{},
+		},
+		{
+			name:   "UntilMidnight",
+			window: TimeWindow{Days: []string{"Sat"}, From: "20:00", To: "24:00"},
+		},
+		{
+			name:    "UnknownDay",
+			window:  TimeWindow{Days: []string{"Monday"}},
+			wantErr: true,
+		},
+		{
+			name:    "InvalidTime",
+			window:  TimeWindow{From: "8:00"},
+			wantErr: true,
+		},
+		{
+			name:    "FromAfterTo",
+			window:  TimeWindow{From: "18:00", To: "08:00"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.window.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("TimeWindow.validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUseCounter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state", "state.json")
+	u, err := NewUseCounter(file)
+	if err != nil {
+		t.Fatalf("NewUseCounter() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := u.Use("guest", 2); err != nil {
+			t.Fatalf("Use() error = %v", err)
+		}
+	}
+	if err := u.Use("guest", 2); err == nil {
+		t.Errorf("Use() accepted a third use of a key with MaxUses 2")
+	}
+	// The counts survive a restart
+	u, err = NewUseCounter(file)
+	if err != nil {
+		t.Fatalf("NewUseCounter() error = %v", err)
+	}
+	if got := u.Get("guest"); got != 2 {
+		t.Errorf("Get() after reload = %d, want 2", got)
+	}
+	if err := u.Check("guest", 2); err == nil {
+		t.Errorf("Check() after reload returned no error")
+	}
+	if err := u.Check("guest", 3); err != nil {
+		t.Errorf("Check() with higher MaxUses error = %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package controls
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Snapshot is a validated set of authKeys and controls. A snapshot is never
+// changed, reloads replace it with a new one.
+type Snapshot struct {
+	AuthKeys map[string]AuthKey
+	Controls map[string]Control
+	KeyIndex *AuthKeyIndex
+}
+
+// Store holds the active snapshot of the controls files in a directory.
+// Requests take the snapshot once and keep using it if a reload replaces it
+// in the meantime.
+type Store struct {
+	mu      sync.Mutex // Serializes reloads
+	dir     string
+	check   func(*Snapshot) error
+	current atomic.Value
+}
+
+// NewStore reads the controls files in dir. check validates a snapshot
+// beyond the controls files, e.g. that all Miniservers exist. It may be nil.
+func NewStore(dir string, check func(*Snapshot) error) (*Store, error) {
+	s := &Store{
+		dir:   dir,
+		check: check,
+	}
+	snap, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	s.current.Store(snap)
+	return s, nil
+}
+
+// Get returns the active snapshot
+func (s *Store) Get() *Snapshot {
+	return s.current.Load().(*Snapshot)
+}
+
+// Reload reads and validates the controls files again and activates them.
+// If they are invalid the active snapshot is kept and the error returned.
+func (s *Store) Reload() (*Snapshot, error) {
+	if s.dir == "" {
+		return nil, errors.New("Controls cannot be reloaded")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	s.current.Store(snap)
+	return snap, nil
+}
+
+func (s *Store) read() (*Snapshot, error) {
+	authKeys, controls, err := Read(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{
+		AuthKeys: authKeys,
+		Controls: controls,
+		KeyIndex: NewAuthKeyIndex(authKeys),
+	}
+	if s.check != nil {
+		if err := s.check(snap); err != nil {
+			return nil, err
+		}
+	}
+	return snap, nil
+}
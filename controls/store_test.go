@@ -0,0 +1,88 @@
+package controls
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const storeTestControls = `
+[AuthKeys]
+testOne = "43b2c690-f281-42bb-af2d-979f5dbe9517"
+
+[Controls.test1]
+Category = "dvi"
+ID = 1
+Allowed = ["pulse"]
+AuthKeys = ["testOne"]
+`
+
+func TestStore_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loxwebhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "controls.toml")
+	if err := ioutil.WriteFile(fn, []byte(storeTestControls), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var rejectAll bool
+	s, err := NewStore(dir, func(snap *Snapshot) error {
+		if rejectAll {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	first := s.Get()
+	if _, ok := first.KeyIndex.Lookup("43b2c690-f281-42bb-af2d-979f5dbe9517"); !ok {
+		t.Errorf("KeyIndex does not find testOne")
+	}
+
+	added := storeTestControls + `
+[Controls.test2]
+Category = "dvi"
+ID = 2
+Allowed = ["on"]
+AuthKeys = ["testOne"]
+`
+	if err := ioutil.WriteFile(fn, []byte(added), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if _, ok := s.Get().Controls["test2"]; !ok {
+		t.Errorf("Reload() did not activate the new control")
+	}
+	if _, ok := first.Controls["test2"]; ok {
+		t.Errorf("Reload() changed the previous snapshot")
+	}
+
+	active := s.Get()
+	if err := ioutil.WriteFile(fn, []byte("[Controls.broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Reload(); err == nil {
+		t.Errorf("Reload() of an invalid file error = nil")
+	}
+	if s.Get() != active {
+		t.Errorf("Reload() of an invalid file replaced the active snapshot")
+	}
+
+	if err := ioutil.WriteFile(fn, []byte(storeTestControls), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rejectAll = true
+	if _, err := s.Reload(); err == nil {
+		t.Errorf("Reload() error = nil, want error of check")
+	}
+	if s.Get() != active {
+		t.Errorf("Reload() activated a snapshot rejected by check")
+	}
+}
@@ -0,0 +1,101 @@
+//go:build linux
+// +build linux
+
+package controls
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const watchEvents = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF
+
+// watcher watches a directory and its subdirectories with inotify
+type watcher struct {
+	fd   int
+	dirs map[int]string // watch descriptor -> directory
+}
+
+// Watch reports changes of files in dir and its subdirectories on the
+// returned channel. Changes that happen while a report is pending are
+// merged into it. The channel is closed if watching fails.
+func Watch(dir string) (<-chan struct{}, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot start inotify")
+	}
+	w := &watcher{
+		fd:   fd,
+		dirs: make(map[int]string),
+	}
+	if err := w.addTree(dir); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	changed := make(chan struct{}, 1)
+	go w.run(changed)
+	return changed, nil
+}
+
+// addTree watches dir and all directories below it
+func (w *watcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, path, watchEvents)
+		if err != nil {
+			return errors.Wrap(err, "Cannot watch "+path)
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+}
+
+func (w *watcher) run(changed chan<- struct{}) {
+	defer close(changed)
+	defer unix.Close(w.fd)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(w.fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || n < unix.SizeofInotifyEvent {
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[nameStart:nameStart+int(event.Len)], "\x00"))
+			offset = nameStart + int(event.Len)
+			if event.Mask&unix.IN_IGNORED != 0 {
+				delete(w.dirs, int(event.Wd))
+				continue
+			}
+			if event.Mask&unix.IN_ISDIR != 0 && event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+				// Files in new directories are read by the next reload
+				if dir, ok := w.dirs[int(event.Wd)]; ok {
+					w.addTree(filepath.Join(dir, name))
+				}
+			}
+		}
+		if len(w.dirs) == 0 {
+			// The watched directory was removed
+			return
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
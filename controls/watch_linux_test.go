@@ -0,0 +1,58 @@
+package controls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loxwebhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	changed, err := Watch(dir)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	waitForChange := func(what string) {
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Watch() did not report %s", what)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.toml"), []byte(""), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange("a new file")
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0700); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange("a new directory")
+	if err := ioutil.WriteFile(filepath.Join(sub, "b.toml"), []byte(""), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange("a file in a new directory")
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-changed:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("Watch() did not stop after the directory was removed")
+		}
+	}
+}
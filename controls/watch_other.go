@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package controls
+
+import "github.com/pkg/errors"
+
+// Watch is only supported on Linux
+func Watch(dir string) (<-chan struct{}, error) {
+	return nil, errors.New("Watching controls files is only supported on Linux")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/axxelG/loxwebhook/controls"
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+// genKey implements the genkey subcommand. It prints a new random key and
+// its hash for the [AuthKeys] table of a controls file.
+func genKey(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("genkey", flag.ContinueOnError)
+	flags.SetOutput(out)
+	name := flags.String("name", "newKey", "Name of the key in the controls file")
+	algorithm := flags.String("hash", "argon2id", fmt.Sprintf("Hash algorithm %v", controls.KeyHashAlgorithms))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !helpers.IsStringInSlice(*algorithm, controls.KeyHashAlgorithms) {
+		return fmt.Errorf("Unknown hash algorithm %s", *algorithm)
+	}
+	key, err := controls.GenerateKey()
+	if err != nil {
+		return err
+	}
+	hash, err := controls.HashKey(key, *algorithm)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Key (send with requests): %s\n", key)
+	fmt.Fprintf(out, "Hash (add to controls file):\n\n")
+	fmt.Fprintf(out, "[AuthKeys]\n%s = '%s'\n", *name, hash)
+	return nil
+}
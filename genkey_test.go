@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/axxelG/loxwebhook/controls"
+)
+
+func Test_genKey(t *testing.T) {
+	var out bytes.Buffer
+	if err := genKey([]string{"-name", "garage", "-hash", "sha256"}, &out); err != nil {
+		t.Fatalf("genKey() error = %v", err)
+	}
+	m := regexp.MustCompile(`Key \(send with requests\): (\S+)\n(?s:.*)garage = '(\S+)'`).FindStringSubmatch(out.String())
+	if m == nil {
+		t.Fatalf("genKey() unexpected output:\n%s", out.String())
+	}
+	k := controls.AuthKey{Key: m[2]}
+	if !k.Verify(m[1]) {
+		t.Errorf("genKey() printed a hash that does not match the key")
+	}
+	if err := genKey([]string{"-hash", "md5"}, &out); err == nil {
+		t.Errorf("genKey() with unknown algorithm returned no error")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/axxelG/loxwebhook/config"
+	"github.com/axxelG/loxwebhook/miniserver"
+	"github.com/axxelG/loxwebhook/proxy"
+)
+
+// probeInterval is the time between two health probes
+const probeInterval = 15 * time.Second
+
+// probeHealth probes all Miniservers every interval and records the results
+// in health. A Miniserver is only healthy if its transport is connected and
+// authenticated as well. It returns when done is closed.
+func probeHealth(cfg *config.Config, transports map[string]miniserver.Transport, tlsConfig *tls.Config, health *proxy.Health, live *liveness, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	clients := make(map[string]*http.Client)
+	for {
+		for _, name := range cfg.GetMiniserverNames() {
+			version, err := probeMiniserver(cfg, name, clients)
+			if err == nil {
+				err = transports[name].Status()
+			}
+			health.SetMiniserver(name, version, err, time.Now())
+		}
+		if tlsConfig != nil && hasLocalCertificates(cfg) {
+			for _, hostname := range cfg.GetPublicHostnames() {
+				// The certificates are observed by observeCertificates
+				tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: hostname})
+			}
+		}
+		live.beat("probe", time.Now())
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeMaxAge returns the time after which the probe loop counts as stuck.
+// Probing a Miniserver and connecting its transport take at most a few
+// Miniserver timeouts.
+func probeMaxAge(cfg *config.Config, interval time.Duration) time.Duration {
+	maxAge := 2 * interval
+	for _, name := range cfg.GetMiniserverNames() {
+		m, _ := cfg.GetMiniserver(name)
+		maxAge += 5 * m.Timeout
+	}
+	return maxAge
+}
+
+// probeMiniserver probes the Miniserver name. The probe clients are created
+// on first use and kept in clients.
+func probeMiniserver(cfg *config.Config, name string, clients map[string]*http.Client) (string, error) {
+	m, _ := cfg.GetMiniserver(name)
+	client, ok := clients[name]
+	if !ok {
+		var err error
+		client, err = m.ProbeClient()
+		if err != nil {
+			return "", err
+		}
+		clients[name] = client
+	}
+	return m.Probe(client)
+}
+
+// hasLocalCertificates returns true if the certificates can be looked up
+// without asking the ACME CA. With the tls-alpn-01 challenge a lookup may
+// request a new certificate, so they are only known after a handshake.
+func hasLocalCertificates(cfg *config.Config) bool {
+	return cfg.ListenMode == "tls" || cfg.ACMEChallenge == "dns-01"
+}
@@ -1,5 +1,14 @@
 package helpers
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
 // IsStringInSlice returns true if str is in list
 func IsStringInSlice(str string, list []string) bool {
 	for _, i := range list {
@@ -21,3 +30,50 @@ func GetMapStringKeyFromStringValue(str string, m map[string]string) (key string
 	}
 	return
 }
+
+// ParseNetworks parses a list of networks in CIDR notation. Single IP
+// addresses are networks with only this address.
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP address %s", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network %s", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// IsIPInNetworks returns true if ip is part of one of nets
+func IsIPInNetworks(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CertificateLeaf returns the parsed leaf certificate of cert
+func CertificateLeaf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("Certificate is empty")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"net"
 	"testing"
 )
 
@@ -72,3 +73,51 @@ func TestGetMapStringKeyFromStringValue(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    []string
+		ip      string
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "cidr",
+			list: []string{"10.0.0.0/8"},
+			ip:   "10.1.2.3",
+			want: true,
+		},
+		{
+			name: "singleIPv4",
+			list: []string{"192.0.2.1"},
+			ip:   "192.0.2.2",
+			want: false,
+		},
+		{
+			name: "singleIPv6",
+			list: []string{"10.0.0.0/8", "2001:db8::1"},
+			ip:   "2001:db8::1",
+			want: true,
+		},
+		{
+			name:    "invalid",
+			list:    []string{"example.com"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nets, err := ParseNetworks(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNetworks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := IsIPInNetworks(net.ParseIP(tt.ip), nets); got != tt.want {
+				t.Errorf("IsIPInNetworks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/axxelG/loxwebhook/acmedns"
+	"github.com/axxelG/loxwebhook/config"
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+// certCheckInterval is the time between two checks for changed certificate files
+const certCheckInterval = 10 * time.Second
+
+// startListener returns the listener selected by ListenMode. The socket
+// activated is used instead of ListenAddress if systemd passed one. The
+// listener uses the returned tls.Config, so changes before the first
+// connection is accepted take effect. The tls.Config is nil in mode http.
+func startListener(cfg *config.Config, activated net.Listener, logger *slog.Logger) (net.Listener, *tls.Config, error) {
+	var tlsConfig *tls.Config
+	switch cfg.ListenMode {
+	case "autocert":
+		var err error
+		tlsConfig, err = newACMETLSConfig(cfg, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+	case "tls":
+		r, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig = &tls.Config{
+			GetCertificate: r.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+	}
+	listener := activated
+	if listener == nil {
+		network, address := cfg.GetListenAddress()
+		if network == "unix" {
+			// A socket left over by an unclean shutdown blocks the address
+			if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+				os.Remove(address)
+			}
+		}
+		var err error
+		listener, err = net.Listen(network, address)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Error starting listener")
+		}
+	} else if tlsConfig == nil && !isLocalListener(listener) {
+		// The same rule as for ListenAddress applies to sockets from systemd
+		return nil, nil, errors.New("ListenMode http only accepts loopback addresses or Unix sockets from systemd")
+	}
+	if tlsConfig == nil {
+		return listener, nil, nil
+	}
+	return tls.NewListener(listener, tlsConfig), tlsConfig, nil
+}
+
+// observeCertificates calls observe with the certificates tlsConfig hands
+// out to clients
+func observeCertificates(tlsConfig *tls.Config, observe func(*tls.Certificate)) {
+	getCertificate := tlsConfig.GetCertificate
+	var last atomic.Value // Parse each certificate only once
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+		if err != nil || cert == nil {
+			return cert, err
+		}
+		for _, proto := range hello.SupportedProtos {
+			if proto == acme.ALPNProto {
+				// Certificates of the tls-alpn-01 challenge are not served to clients
+				return cert, nil
+			}
+		}
+		if last.Load() != cert {
+			observe(cert)
+			last.Store(cert)
+		}
+		return cert, nil
+	}
+}
+
+// isLocalListener returns true if l is a Unix socket or listens on a
+// loopback address
+func isLocalListener(l net.Listener) bool {
+	addr, ok := l.Addr().(*net.TCPAddr)
+	return !ok || addr.IP.IsLoopback()
+}
+
+// newACMETLSConfig returns a tls.Config with certificates from the ACME CA.
+// With the dns-01 challenge the certificate is requested before it returns.
+func newACMETLSConfig(cfg *config.Config, logger *slog.Logger) (*tls.Config, error) {
+	httpClient, err := cfg.ACMEHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		DirectoryURL: cfg.ACMEDirectoryURL,
+		HTTPClient:   httpClient,
+		UserAgent:    "loxwebhook",
+	}
+	var eab *acme.ExternalAccountBinding
+	if cfg.ACMEEABKeyID != "" {
+		key, err := cfg.ACMEEABKey()
+		if err != nil {
+			return nil, err
+		}
+		eab = &acme.ExternalAccountBinding{KID: cfg.ACMEEABKeyID, Key: key}
+	}
+	if cfg.ACMEChallenge != "dns-01" {
+		m := &autocert.Manager{
+			Cache:                  autocert.DirCache(cfg.LetsEncryptCache),
+			Prompt:                 autocert.AcceptTOS,
+			HostPolicy:             autocert.HostWhitelist(cfg.GetACMEDomains()...),
+			Client:                 client,
+			ExternalAccountBinding: eab,
+		}
+		return m.TLSConfig(), nil
+	}
+	var provider acmedns.Provider
+	switch cfg.ACMEDNSProvider {
+	case "rfc2136":
+		provider, err = acmedns.NewRFC2136Provider(cfg.ACMEDNSServer, cfg.ACMEDNSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	case "exec":
+		provider = acmedns.NewExecProvider(cfg.ACMEDNSCommand)
+	}
+	m := &acmedns.Manager{
+		Client:                 client,
+		Provider:               provider,
+		Domains:                cfg.GetACMEDomains(),
+		Cache:                  autocert.DirCache(cfg.LetsEncryptCache),
+		ExternalAccountBinding: eab,
+		PropagationDelay:       time.Duration(cfg.ACMEDNSDelay) * time.Second,
+		Logger:                 logger,
+	}
+	logger.Info("Getting certificate with the dns-01 challenge", "domains", m.Domains)
+	if err := m.Start(context.Background()); err != nil {
+		return nil, errors.Wrap(err, "Error getting certificate")
+	}
+	return m.TLSConfig(), nil
+}
+
+// certReloader serves the certificate of certFile and keyFile. The files are
+// reloaded when they change, so renewed certificates are used without a
+// restart.
+type certReloader struct {
+	certFile      string
+	keyFile       string
+	logger        *slog.Logger
+	checkInterval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // Latest modification time of the loaded files
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	r := &certReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		logger:        logger,
+		checkInterval: certCheckInterval,
+		checked:       time.Now(),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// modified returns the latest modification time of the files
+func (r *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.modified()
+	if err != nil {
+		return errors.Wrap(err, "Error reading TLS certificate")
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "Error loading TLS certificate")
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate returns the current certificate. If the files changed but
+// cannot be loaded, the previous certificate is kept.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.checked) < r.checkInterval {
+		return r.cert, nil
+	}
+	r.checked = now
+	if modTime, err := r.modified(); err != nil || modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	if err := r.reload(); err != nil {
+		r.logger.Error("Keeping the previous certificate", logging.Err(err))
+	} else {
+		r.logger.Info("Reloaded TLS certificate", "file", r.certFile)
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/axxelG/loxwebhook/config"
+	"github.com/axxelG/loxwebhook/logging"
+	"github.com/axxelG/loxwebhook/metrics"
+)
+
+// writeTestCert writes a self-signed certificate for cn to certFile and keyFile
+func writeTestCert(t *testing.T, cn, certFile, keyFile string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_certReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loxwebhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, "first", certFile, keyFile)
+	r, err := newCertReloader(certFile, keyFile, logging.Discard())
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+	r.checkInterval = 0
+	commonName := func() string {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate() error = %v", err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Subject.CommonName
+	}
+	if got := commonName(); got != "first" {
+		t.Errorf("GetCertificate() CN = %s, want first", got)
+	}
+	later := time.Now().Add(time.Minute)
+	writeTestCert(t, "second", certFile, keyFile)
+	os.Chtimes(certFile, later, later)
+	if got := commonName(); got != "second" {
+		t.Errorf("GetCertificate() CN = %s after change, want second", got)
+	}
+	// Broken files keep the previous certificate
+	if err := ioutil.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	if got := commonName(); got != "second" {
+		t.Errorf("GetCertificate() CN = %s with broken files, want second", got)
+	}
+}
+
+func Test_startListener_unixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loxwebhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "loxwebhook.sock")
+	cfg := &config.Config{ListenMode: "http", ListenAddress: "unix:" + socket}
+	listener, tlsConfig, err := startListener(cfg, nil, logging.Discard())
+	if err != nil {
+		t.Fatalf("startListener() error = %v", err)
+	}
+	defer listener.Close()
+	if tlsConfig != nil {
+		t.Errorf("startListener() returned a tls.Config in http mode")
+	}
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://loxwebhook/")
+	if err != nil {
+		t.Fatalf("GET over Unix socket error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET over Unix socket status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func Test_startListener_tls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loxwebhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{
+		ListenMode:    "tls",
+		ListenAddress: "127.0.0.1:0",
+		TLSCertFile:   filepath.Join(dir, "cert.pem"),
+		TLSKeyFile:    filepath.Join(dir, "key.pem"),
+	}
+	writeTestCert(t, "loxwebhook", cfg.TLSCertFile, cfg.TLSKeyFile)
+	listener, tlsConfig, err := startListener(cfg, nil, logging.Discard())
+	if err != nil {
+		t.Fatalf("startListener() error = %v", err)
+	}
+	defer listener.Close()
+	if tlsConfig == nil || tlsConfig.GetCertificate == nil {
+		t.Fatalf("startListener() returned no tls.Config with GetCertificate")
+	}
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("TLS handshake error = %v", err)
+	}
+	defer conn.Close()
+	if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "loxwebhook" {
+		t.Errorf("Server certificate CN = %s, want loxwebhook", cn)
+	}
+}
+
+func Test_startListener_activated(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		wantErr bool
+	}{
+		{name: "Loopback", address: "127.0.0.1:0"},
+		{name: "Public", address: "0.0.0.0:0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activated, err := net.Listen("tcp", tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer activated.Close()
+			// ListenAddress is ignored if systemd passed a socket
+			cfg := &config.Config{ListenMode: "http", ListenAddress: "127.0.0.1:1"}
+			listener, _, err := startListener(cfg, activated, logging.Discard())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("startListener() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && listener != activated {
+				t.Errorf("startListener() did not return the activated listener")
+			}
+		})
+	}
+}
+
+func Test_observeCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loxwebhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, "loxwebhook.example.com", certFile, keyFile)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	tlsConfig := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil },
+	}
+	m := metrics.New()
+	observeCertificates(tlsConfig, m.ObserveCertificate)
+
+	tlsConfig.GetCertificate(&tls.ClientHelloInfo{SupportedProtos: []string{"acme-tls/1"}})
+	if got := m.CertificateExpiry.Get("loxwebhook.example.com"); got != 0 {
+		t.Errorf("Expiry of a tls-alpn-01 challenge certificate recorded: %v", got)
+	}
+	tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "loxwebhook.example.com"})
+	if got, want := m.CertificateExpiry.Get("loxwebhook.example.com"), float64(leaf.NotAfter.Unix()); got != want {
+		t.Errorf("CertificateExpiry = %v, want %v", got, want)
+	}
+}
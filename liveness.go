@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// beatInterval is the time between two beats of loops that wait for events
+const beatInterval = 15 * time.Second
+
+// liveness records when the background loops of loxwebhook last ran. The
+// systemd watchdog is only pinged while all of them run. The serve loop is
+// not tracked, loxwebhook stops if it ends.
+type liveness struct {
+	mu    sync.Mutex
+	loops map[string]*loopBeat
+}
+
+type loopBeat struct {
+	maxAge time.Duration // Time without beat after which the loop is stuck
+	last   time.Time
+}
+
+func newLiveness() *liveness {
+	return &liveness{loops: make(map[string]*loopBeat)}
+}
+
+// register adds the loop name that must beat at least every maxAge
+func (l *liveness) register(name string, maxAge time.Duration, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loops[name] = &loopBeat{maxAge: maxAge, last: now}
+}
+
+// beat records that the loop name is running
+func (l *liveness) beat(name string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lb, ok := l.loops[name]; ok {
+		lb.last = now
+	}
+}
+
+// check returns an error if a loop did not beat within its maxAge
+func (l *liveness) check(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.loops))
+	for name := range l.loops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lb := l.loops[name]
+		if now.Sub(lb.last) > lb.maxAge {
+			return fmt.Errorf("Loop %s did not run since %s", name, lb.last.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_liveness(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	tests := []struct {
+		name      string
+		probeBeat time.Duration // Time of the last beat of the probe loop after start
+		checkAt   time.Duration
+		wantErr   bool
+	}{
+		{
+			name:    "Registered",
+			checkAt: time.Minute,
+			wantErr: false,
+		},
+		{
+			name:      "Beating",
+			probeBeat: 2 * time.Minute,
+			checkAt:   150 * time.Second,
+			wantErr:   false,
+		},
+		{
+			name:      "Stuck",
+			probeBeat: 30 * time.Second,
+			checkAt:   3 * time.Minute,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLiveness()
+			l.register("probe", time.Minute, start)
+			l.register("reload", 5*time.Minute, start)
+			l.beat("probe", start.Add(tt.probeBeat))
+			l.beat("unknown", start)
+			if err := l.check(start.Add(tt.checkAt)); (err != nil) != tt.wantErr {
+				t.Errorf("liveness.check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
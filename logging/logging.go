@@ -0,0 +1,58 @@
+// Package logging creates the structured loggers of loxwebhook
+package logging
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"log/slog"
+)
+
+// ParseLevel returns the level named debug, info, warn or error
+func ParseLevel(level string) (slog.Level, error) {
+	switch level {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("Unknown log level %s", level)
+}
+
+// New returns a logger that writes entries of at least level to w. format
+// logfmt writes key=value pairs, json one JSON object per entry.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "logfmt":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("Unknown log format %s", format)
+}
+
+// Discard returns a logger that drops all entries
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+}
+
+// NewStdLogger returns a log.Logger that writes to logger with level. It is
+// used by packages that only accept a log.Logger like net/http.
+func NewStdLogger(logger *slog.Logger, level slog.Level) *log.Logger {
+	return slog.NewLogLogger(logger.Handler(), level)
+}
+
+// Err returns the attribute of an error
+func Err(err error) slog.Attr {
+	return slog.String("error", err.Error())
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "Logfmt",
+			format: "logfmt",
+			level:  "info",
+			want:   `level=INFO msg="Reloaded controls" controls=2 error="file not found"`,
+		},
+		{
+			name:   "JSON",
+			format: "json",
+			level:  "debug",
+			want:   `"level":"INFO","msg":"Reloaded controls","controls":2,"error":"file not found"}`,
+		},
+		{
+			name:   "Filtered",
+			format: "logfmt",
+			level:  "warn",
+			want:   "",
+		},
+		{
+			name:    "UnknownFormat",
+			format:  "xml",
+			level:   "info",
+			wantErr: true,
+		},
+		{
+			name:    "UnknownLevel",
+			format:  "json",
+			level:   "trace",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := New(&buf, tt.format, tt.level)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			logger.Info("Reloaded controls", "controls", 2, Err(errors.New("file not found")))
+			got := buf.String()
+			if tt.want == "" {
+				if got != "" {
+					t.Errorf("New() logged %q below the level", got)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("New() logged %q, want it to contain %q", got, tt.want)
+			}
+			if tt.format == "json" && !json.Valid(buf.Bytes()) {
+				t.Errorf("New() logged invalid JSON %q", got)
+			}
+		})
+	}
+}
@@ -1,47 +1,55 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/axxelG/crypto/acme/autocert"
-	"github.com/coreos/go-systemd/daemon"
 	"github.com/pkg/errors"
 
 	"github.com/axxelG/loxwebhook/config"
 	"github.com/axxelG/loxwebhook/controls"
+	"github.com/axxelG/loxwebhook/logging"
+	"github.com/axxelG/loxwebhook/metrics"
+	"github.com/axxelG/loxwebhook/miniserver"
 	"github.com/axxelG/loxwebhook/proxy"
 )
 
 var version string // Will be set on compile time
 
-func initLogging(FileName string) (*log.Logger, *os.File, error) {
-	logFormat := log.Ldate | log.Ltime | log.Lshortfile
+func initLogging(FileName, format, level string) (*slog.Logger, *os.File, error) {
 	if FileName == "" {
-		logger := log.New(os.Stderr, "", logFormat)
-		return logger, nil, nil
+		logger, err := logging.New(os.Stderr, format, level)
+		return logger, nil, err
 	}
 	logFile, err := os.OpenFile(FileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "Error opening logfile")
 	}
-	logger := log.New(logFile, "", logFormat)
-	return logger, logFile, nil
-}
-
-func startLetsEncryptListener(cfg *config.Config) (net.Listener, *tls.Config) {
-	m := &autocert.Manager{
-		Cache:      autocert.DirCache(cfg.LetsEncryptCache),
-		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(cfg.PublicURI),
+	logger, err := logging.New(logFile, format, level)
+	if err != nil {
+		logFile.Close()
+		return nil, nil, err
 	}
-	return m.ListenerCustomAddress(cfg.GetListenPort()), m.TLSConfig()
+	return logger, logFile, nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "genkey" {
+		if err := genKey(os.Args[2:], os.Stdout); err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	cfg, err := config.NewConfig(version)
 	if err != nil {
 		log.Print(errors.Wrap(err, "Cannot read/load config"))
@@ -52,50 +60,166 @@ func main() {
 		log.Print(errors.Wrap(err, "Error validating config"))
 		os.Exit(1)
 	}
-	loggerMain, logFileMain, err := initLogging(cfg.LogFileMain)
+	loggerMain, logFileMain, err := initLogging(cfg.LogFileMain, cfg.LogFormat, cfg.LogLevel)
 	if err != nil {
 		log.Print(errors.Wrap(err, "Cannot write logfile"))
 		os.Exit(1)
 	}
 	defer logFileMain.Close()
 
-	loggerMain.Println()
-	loggerMain.Println("Starting loxwebhook")
-	loggerMain.Println("====================")
-	loggerMain.Print(cfg.String())
-	loggerMain.Println("====================")
+	loggerMain.Info("Starting loxwebhook", "version", version)
+	loggerMain.Info("Config", "config", cfg)
 	logErrAndExit := func(err error) {
-		loggerMain.Print(err)
+		loggerMain.Error("Stopping loxwebhook", logging.Err(err))
 		if cfg.LogFileMain != "" {
 			fmt.Printf("An error occured. See %s for details.\n", cfg.LogFileMain)
 		}
 		os.Exit(1)
 	}
 
-	authKeys, controls, err := controls.Read(cfg.ControlsFiles)
+	uses, err := controls.NewUseCounter(cfg.StateFile)
 	if err != nil {
-		logErrAndExit(errors.Wrap(err, "Error importing controls"))
+		logErrAndExit(errors.Wrap(err, "Error loading auth key use counts"))
 	}
 
-	LoggerHTTPErrors, logFileHTTPErrors, err := initLogging(cfg.LogFileHTTPError)
+	LoggerHTTPErrors, logFileHTTPErrors, err := initLogging(cfg.LogFileHTTPError, cfg.LogFormat, cfg.LogLevel)
 	if err != nil {
 		logErrAndExit(errors.Wrap(err, "Cannot write logfile http errors"))
 	}
 	defer logFileHTTPErrors.Close()
 
-	LoggerHTTPAccess, LogFileHTTPAccess, err := initLogging(cfg.LogFileHTTPAccess)
+	LoggerHTTPAccess, LogFileHTTPAccess, err := initLogging(cfg.LogFileHTTPAccess, cfg.LogFormat, cfg.LogLevel)
 	if err != nil {
 		logErrAndExit(errors.Wrap(err, "Cannot write logfile http access"))
 	}
 	defer LogFileHTTPAccess.Close()
 
-	listener, tlsConfig := startLetsEncryptListener(cfg)
-	daemon.SdNotify(false, daemon.SdNotifyReady)
-	loggerMain.Println("Listener started")
-	loggerMain.Println("====================")
-	err = proxy.StartServer(listener, tlsConfig, cfg, LoggerHTTPErrors, LoggerHTTPAccess, authKeys, controls)
+	miniservers, err := miniserver.NewTransports(cfg, loggerMain)
+	if err != nil {
+		logErrAndExit(errors.Wrap(err, "Error connecting to Miniserver"))
+	}
+	// The clients keep what they need. With token authentication the
+	// passwords are not needed anymore.
+	cfg.MiniserverPassword = ""
+	for _, m := range cfg.Miniservers {
+		m.Password = ""
+	}
+	store, err := controls.NewStore(cfg.ControlsFiles, func(snap *controls.Snapshot) error {
+		return proxy.CheckControls(cfg, miniservers, snap)
+	})
+	if err != nil {
+		logErrAndExit(errors.Wrap(err, "Error importing controls"))
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var changed <-chan struct{}
+	if cfg.ControlsWatch {
+		changed, err = controls.Watch(cfg.ControlsFiles)
+		if err != nil {
+			loggerMain.Warn("Cannot watch controls files, reload them with SIGHUP", logging.Err(err))
+		}
+	}
+	m := metrics.New()
+	m.ControlsLoaded.Set(float64(time.Now().Unix()))
+	health := proxy.NewHealth(cfg.GetMiniserverNames(), time.Now())
+	live := newLiveness()
+	live.register("reload", 2*beatInterval, time.Now())
+	live.register("probe", probeMaxAge(cfg, probeInterval), time.Now())
+	go reloadControls(store, hup, changed, m, health, live, loggerMain)
+	// Signals that arrive while starting stop loxwebhook after the start
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	closeMiniservers := func() {
+		for name, ms := range miniservers {
+			if err := ms.Close(); err != nil {
+				loggerMain.Error("Error closing Miniserver connection", "miniserver", name, logging.Err(err))
+			}
+		}
+	}
+
+	activatedProxy, activatedAdmin, err := activatedListeners()
+	if err != nil {
+		logErrAndExit(err)
+	}
+	limits := proxy.NewLimits(cfg)
+	var adminServer *http.Server
+	if cfg.AdminListenAddress != "" || activatedAdmin != nil {
+		adminListener := activatedAdmin
+		if adminListener == nil {
+			adminListener, err = net.Listen("tcp", cfg.AdminListenAddress)
+			if err != nil {
+				logErrAndExit(errors.Wrap(err, "Error starting admin listener"))
+			}
+		}
+		adminServer = proxy.NewAdminServer(LoggerHTTPErrors, limits, health)
+		go func() {
+			if err := adminServer.Serve(adminListener); err != http.ErrServerClosed {
+				loggerMain.Error("Error starting admin server", logging.Err(err))
+			}
+		}()
+	}
+
+	var metricsServer *http.Server
+	if cfg.MetricsListenAddress != "" {
+		metricsListener, err := net.Listen("tcp", cfg.MetricsListenAddress)
+		if err != nil {
+			logErrAndExit(errors.Wrap(err, "Error starting metrics listener"))
+		}
+		metricsServer = proxy.NewMetricsServer(LoggerHTTPErrors, m)
+		go func() {
+			if err := metricsServer.Serve(metricsListener); err != http.ErrServerClosed {
+				loggerMain.Error("Error starting metrics server", logging.Err(err))
+			}
+		}()
+	}
+
+	listener, tlsConfig, err := startListener(cfg, activatedProxy, loggerMain)
+	if err != nil {
+		logErrAndExit(err)
+	}
+	if tlsConfig != nil {
+		observeCertificates(tlsConfig, func(cert *tls.Certificate) {
+			m.ObserveCertificate(cert)
+			health.ObserveCertificate(cert)
+		})
+	}
+	done := make(chan struct{})
+	go probeHealth(cfg, miniservers, tlsConfig, health, live, probeInterval, done)
+	server, err := proxy.NewServer(tlsConfig, cfg, miniservers, LoggerHTTPErrors, LoggerHTTPAccess, store, uses, limits, m)
 	if err != nil {
 		logErrAndExit(errors.Wrap(err, "Error starting server"))
-		os.Exit(1)
 	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	notifyReady()
+	loggerMain.Info("Listener started", "mode", cfg.ListenMode, "address", listener.Addr().String())
+	startWatchdog(func() error { return live.check(time.Now()) }, loggerMain, done)
+
+	select {
+	case err := <-served:
+		closeMiniservers()
+		logErrAndExit(errors.Wrap(err, "Error starting server"))
+	case sig := <-stop:
+		loggerMain.Info("Stopping loxwebhook", "signal", sig.String())
+	}
+	notifyStopping()
+	close(done)
+	timeout := time.Duration(cfg.ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if adminServer != nil {
+		adminServer.Close()
+	}
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	// Shutdown stops accepting connections and waits for running requests
+	if err := server.Shutdown(ctx); err != nil {
+		loggerMain.Warn("Running requests did not finish in time, closing their connections", "timeout", timeout.String())
+		server.Close()
+	}
+	closeMiniservers()
+	loggerMain.Info("Stopped loxwebhook")
 }
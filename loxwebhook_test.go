@@ -1,65 +1,49 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"os"
-	"reflect"
 	"strings"
 	"testing"
 )
 
 func Test_initLogging(t *testing.T) {
-	logFormat := log.Ldate | log.Ltime | log.Lshortfile
 	logFileName := "./test_initLogging.log"
 	randomID := rand.Intn(10000)
 	logFileContent := fmt.Sprintf("Test_initLogging %d", randomID)
 
-	// Test stderr
-	// cfg := &config.Config{
-	// 	LogFile: "",
-	// }
 	t.Run("stderr", func(t *testing.T) {
-		wantLogger := log.New(
-			os.Stderr,
-			"",
-			logFormat,
-		)
-		gotLogger, gotFile, err := initLogging("")
+		gotLogger, gotFile, err := initLogging("", "logfmt", "info")
 		if err != nil {
 			t.Errorf("initLogging() error = %v", err)
 			return
 		}
-		if !reflect.DeepEqual(gotLogger, wantLogger) {
-			t.Errorf("initLogging() got = %v, want %v", gotLogger, wantLogger)
+		if gotLogger == nil {
+			t.Errorf("initLogging() returned no logger")
 		}
 		if gotFile != nil {
 			t.Errorf("initLogging() got1 = %v, want %v", gotFile, nil)
 		}
 	})
 
-	// Test file
-	// cfg = &config.Config{
-	// 	LogFile: logFileName,
-	// }
 	t.Run("file", func(t *testing.T) {
-		gotLogger, gotFile, err := initLogging(logFileName)
-		gotLogger.Print(logFileContent)
+		gotLogger, gotFile, err := initLogging(logFileName, "json", "info")
+		if err != nil {
+			t.Errorf("initLogging() error = %v", err)
+			return
+		}
 		defer func() {
-			gotLogger.SetFlags(0)
-			gotLogger.SetOutput(ioutil.Discard)
 			gotFile.Close()
 			err = os.Remove(logFileName)
 			if err != nil {
 				t.Errorf("Deleting logfile failed: %v", err)
 			}
 		}()
-		if err != nil {
-			t.Errorf("initLogging() error = %v", err)
-			return
-		}
+		gotLogger.Debug("Below the level")
+		gotLogger.Info(logFileContent)
 		if gotFile.Name() != logFileName {
 			t.Errorf("Wrong filename. Expected: %v, got: %v", logFileName, gotFile.Name())
 		}
@@ -70,5 +54,17 @@ func Test_initLogging(t *testing.T) {
 		if !strings.Contains(string(content), logFileContent) {
 			t.Errorf("Wrong content in logfile. Expected: %v, got: %v", logFileContent, string(content))
 		}
+		if strings.Contains(string(content), "Below the level") {
+			t.Errorf("Logfile contains an entry below the level: %v", string(content))
+		}
+		if !json.Valid(content) {
+			t.Errorf("Logfile is not JSON: %v", string(content))
+		}
+	})
+
+	t.Run("unknownFormat", func(t *testing.T) {
+		if _, _, err := initLogging("", "xml", "info"); err == nil {
+			t.Errorf("initLogging() error = nil for an unknown format")
+		}
 	})
 }
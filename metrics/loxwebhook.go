@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"crypto/tls"
+
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+// miniserverBuckets are the upper bounds of the Miniserver latency
+// histogram in seconds
+var miniserverBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics are the metrics of loxwebhook
+type Metrics struct {
+	Registry
+	Requests           *CounterVec   // control, category, command, code
+	RateLimited        *CounterVec   // limiter: ip, key, control or ban
+	AuthFailures       *CounterVec   // reason
+	MiniserverDuration *HistogramVec // miniserver
+	MiniserverErrors   *CounterVec   // miniserver, type: timeout, bad_gateway or non_200
+	CertificateExpiry  *GaugeVec     // name
+	ControlsLoaded     *GaugeVec
+}
+
+// New returns the metrics of loxwebhook
+func New() *Metrics {
+	m := &Metrics{}
+	m.Requests = m.NewCounterVec("loxwebhook_requests_total",
+		"Requests to controls by control, category, command and response code.",
+		"control", "category", "command", "code")
+	m.RateLimited = m.NewCounterVec("loxwebhook_rate_limited_requests_total",
+		"Requests rejected by a rate limiter or because the client IP is banned.",
+		"limiter")
+	m.AuthFailures = m.NewCounterVec("loxwebhook_auth_failures_total",
+		"Requests that failed authentication or authorization by reason.",
+		"reason")
+	m.MiniserverDuration = m.NewHistogramVec("loxwebhook_miniserver_request_duration_seconds",
+		"Duration of requests to the Miniserver.",
+		miniserverBuckets, "miniserver")
+	m.MiniserverErrors = m.NewCounterVec("loxwebhook_miniserver_errors_total",
+		"Failed requests to the Miniserver by type.",
+		"miniserver", "type")
+	m.CertificateExpiry = m.NewGaugeVec("loxwebhook_certificate_expiry_timestamp_seconds",
+		"Expiry of the TLS certificates served to clients as Unix timestamp.",
+		"name")
+	m.ControlsLoaded = m.NewGaugeVec("loxwebhook_controls_loaded_timestamp_seconds",
+		"Time the active controls were loaded or reloaded as Unix timestamp.")
+	return m
+}
+
+// ObserveCertificate records the expiry of cert
+func (m *Metrics) ObserveCertificate(cert *tls.Certificate) {
+	leaf, err := helpers.CertificateLeaf(cert)
+	if err != nil {
+		return
+	}
+	name := leaf.Subject.CommonName
+	if name == "" && len(leaf.DNSNames) > 0 {
+		name = leaf.DNSNames[0]
+	}
+	m.CertificateExpiry.Set(float64(leaf.NotAfter.Unix()), name)
+}
@@ -0,0 +1,274 @@
+// Package metrics collects metrics and exposes them in the Prometheus text
+// format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is a metric family that can write itself in the text format
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds metric families in the order they were added
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func (r *Registry) add(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes all metrics in the Prometheus text format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	bw.Flush()
+}
+
+// Handler serves the metrics of r
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// family holds the values of a metric by label values
+type family struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+}
+
+// labelString returns {a="1",b="2"} for the labels of f, extra labels are
+// appended
+func (f *family) labelString(values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range f.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of values sorted to get a stable output
+func sortedKeys(values map[string][]string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a counter with labels
+type CounterVec struct {
+	family
+	values map[string]float64
+	labelv map[string][]string
+}
+
+// NewCounterVec adds a counter to r
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		family: family{name: name, help: help, typ: "counter", labels: labels},
+		values: make(map[string]float64),
+		labelv: make(map[string][]string),
+	}
+	r.add(c)
+	return c
+}
+
+// Inc adds 1 to the counter with the label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter with the label values
+func (c *CounterVec) Add(v float64, values ...string) {
+	k := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.labelv[k]; !ok {
+		c.labelv[k] = append([]string(nil), values...)
+	}
+	c.values[k] += v
+}
+
+// Get returns the counter with the label values
+func (c *CounterVec) Get(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[c.key(values)]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range sortedKeys(c.labelv) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(c.labelv[k]), formatFloat(c.values[k]))
+	}
+}
+
+// GaugeVec is a gauge with labels
+type GaugeVec struct {
+	family
+	values map[string]float64
+	labelv map[string][]string
+}
+
+// NewGaugeVec adds a gauge to r
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{
+		family: family{name: name, help: help, typ: "gauge", labels: labels},
+		values: make(map[string]float64),
+		labelv: make(map[string][]string),
+	}
+	r.add(g)
+	return g
+}
+
+// Set sets the gauge with the label values to v
+func (g *GaugeVec) Set(v float64, values ...string) {
+	k := g.key(values)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.labelv[k]; !ok {
+		g.labelv[k] = append([]string(nil), values...)
+	}
+	g.values[k] = v
+}
+
+// Get returns the gauge with the label values
+func (g *GaugeVec) Get(values ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[g.key(values)]
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w)
+	for _, k := range sortedKeys(g.labelv) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(g.labelv[k]), formatFloat(g.values[k]))
+	}
+}
+
+// HistogramVec is a histogram with labels
+type HistogramVec struct {
+	family
+	buckets []float64 // Upper bounds, sorted
+	values  map[string]*histogram
+	labelv  map[string][]string
+}
+
+type histogram struct {
+	counts []uint64 // Observations per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec adds a histogram with the upper bounds buckets to r
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &HistogramVec{
+		family:  family{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: b,
+		values:  make(map[string]*histogram),
+		labelv:  make(map[string][]string),
+	}
+	r.add(h)
+	return h
+}
+
+// Observe adds v to the histogram with the label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	k := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[k]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hist
+		h.labelv[k] = append([]string(nil), values...)
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range sortedKeys(h.labelv) {
+		hist := h.values[k]
+		values := h.labelv[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(values), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(values), hist.count)
+	}
+}
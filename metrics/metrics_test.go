@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := &Registry{}
+	c := r.NewCounterVec("test_requests_total", "Requests.", "control", "code")
+	g := r.NewGaugeVec("test_loaded_timestamp_seconds", "Load time.")
+	h := r.NewHistogramVec("test_duration_seconds", "Duration.", []float64{0.5, 0.1}, "miniserver")
+	c.Inc("garage", "200")
+	c.Inc("garage", "200")
+	c.Inc(`ga"r\age`+"\n", "404")
+	g.Set(1600000000)
+	h.Observe(0.05, "default")
+	h.Observe(0.2, "default")
+	h.Observe(3, "default")
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{control="ga\"r\\age\n",code="404"} 1
+test_requests_total{control="garage",code="200"} 2
+# HELP test_loaded_timestamp_seconds Load time.
+# TYPE test_loaded_timestamp_seconds gauge
+test_loaded_timestamp_seconds 1.6e+09
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{miniserver="default",le="0.1"} 1
+test_duration_seconds_bucket{miniserver="default",le="0.5"} 2
+test_duration_seconds_bucket{miniserver="default",le="+Inf"} 3
+test_duration_seconds_sum{miniserver="default"} 3.25
+test_duration_seconds_count{miniserver="default"} 3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+	if got := c.Get("garage", "200"); got != 2 {
+		t.Errorf("Get() = %v, want 2", got)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	m := New()
+	m.RateLimited.Inc("ip")
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %s, want text format 0.0.4", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `loxwebhook_rate_limited_requests_total{limiter="ip"} 1`) {
+		t.Errorf("Handler() body misses the rate limit counter:\n%s", rec.Body.String())
+	}
+}
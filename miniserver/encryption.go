@@ -0,0 +1,196 @@
+package miniserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	saltMaxUses = 30
+	saltMaxAge  = time.Hour
+)
+
+// encrypter implements the Loxone command encryption. Commands are AES-256-CBC
+// encrypted with a session key that is sent RSA encrypted to the Miniserver.
+type encrypter struct {
+	mode       string // enc encrypts commands, fenc commands and responses
+	key        []byte
+	iv         []byte
+	sessionKey string // AES key and iv encrypted with the public key of the Miniserver
+
+	mu          sync.Mutex
+	salt        string
+	saltUses    int
+	saltCreated time.Time
+}
+
+// parsePublicKey parses the public key sent by jdev/sys/getPublicKey. The
+// Miniserver sends it as certificate without line breaks.
+func parsePublicKey(s string) (*rsa.PublicKey, error) {
+	s = strings.NewReplacer(
+		"-----BEGIN CERTIFICATE-----", "",
+		"-----END CERTIFICATE-----", "",
+		"-----BEGIN PUBLIC KEY-----", "",
+		"-----END PUBLIC KEY-----", "",
+		"\n", "",
+		"\r", "",
+	).Replace(s)
+	der, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot decode public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot parse public key")
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Public key of Miniserver is not a RSA key")
+	}
+	return pub, nil
+}
+
+func newEncrypter(pub *rsa.PublicKey, mode string) (*encrypter, error) {
+	e := &encrypter{
+		mode: mode,
+		key:  make([]byte, 32),
+		iv:   make([]byte, aes.BlockSize),
+	}
+	if _, err := rand.Read(e.key); err != nil {
+		return nil, errors.Wrap(err, "Cannot create AES key")
+	}
+	if _, err := rand.Read(e.iv); err != nil {
+		return nil, errors.Wrap(err, "Cannot create AES iv")
+	}
+	sk, err := rsa.EncryptPKCS1v15(rand.Reader, pub, []byte(hex.EncodeToString(e.key)+":"+hex.EncodeToString(e.iv)))
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot encrypt session key")
+	}
+	e.sessionKey = base64.StdEncoding.EncodeToString(sk)
+	return e, nil
+}
+
+func newSalt() string {
+	b := make([]byte, 2)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// saltCommand prefixes cmd with a salt. The salt is changed after some time
+// and some uses as recommended by Loxone.
+func (e *encrypter) saltCommand(cmd string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.salt == "" {
+		e.salt = newSalt()
+		e.saltCreated = time.Now()
+		e.saltUses = 1
+		return fmt.Sprintf("salt/%s/%s", e.salt, cmd)
+	}
+	e.saltUses++
+	if e.saltUses > saltMaxUses || time.Since(e.saltCreated) > saltMaxAge {
+		oldSalt := e.salt
+		e.salt = newSalt()
+		e.saltCreated = time.Now()
+		e.saltUses = 1
+		return fmt.Sprintf("nextSalt/%s/%s/%s", oldSalt, e.salt, cmd)
+	}
+	return fmt.Sprintf("salt/%s/%s", e.salt, cmd)
+}
+
+// aesEncrypt encrypts plaintext with zero padding
+func aesEncrypt(key, iv, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if pad := len(plaintext) % aes.BlockSize; pad != 0 {
+		plaintext = append(plaintext, make([]byte, aes.BlockSize-pad)...)
+	}
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+	return ciphertext, nil
+}
+
+// aesDecrypt decrypts ciphertext and removes the zero padding
+func aesDecrypt(key, iv, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("Ciphertext is not a multiple of the block size")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return bytes.TrimRight(plaintext, "\x00"), nil
+}
+
+// encryptCommand returns the path that sends cmd encrypted
+func (e *encrypter) encryptCommand(cmd string) (string, error) {
+	plaintext := append([]byte(e.saltCommand(cmd)), 0)
+	ciphertext, err := aesEncrypt(e.key, e.iv, plaintext)
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot encrypt command")
+	}
+	return fmt.Sprintf("jdev/sys/%s/%s",
+		e.mode,
+		url.QueryEscape(base64.StdEncoding.EncodeToString(ciphertext)),
+	), nil
+}
+
+// decrypt decrypts a response to a fenc command. Errors are sent unencrypted
+// by the Miniserver and returned as they are.
+func (e *encrypter) decrypt(body []byte) ([]byte, error) {
+	body = bytes.TrimSpace(body)
+	if e.mode != "fenc" || len(body) == 0 || body[0] == '{' || body[0] == '<' {
+		return body, nil
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot decode encrypted Miniserver response")
+	}
+	plaintext, err := aesDecrypt(e.key, e.iv, ciphertext)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot decrypt Miniserver response")
+	}
+	return plaintext, nil
+}
+
+// decryptResponse replaces the body of resp with the decrypted body
+func (e *encrypter) decryptResponse(resp *http.Response) error {
+	if e.mode != "fenc" {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return errors.Wrap(err, "Error reading Miniserver response")
+	}
+	body, err = e.decrypt(body)
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	if bytes.HasPrefix(body, []byte("{")) {
+		resp.Header.Set("Content-Type", "application/json")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package miniserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+// sessionKey decrypts the AES key and iv sent by the client
+func (fm *fakeMiniserver) sessionKey(sk string) (key, iv []byte, err error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(sk)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := rsa.DecryptPKCS1v15(rand.Reader, fm.privateKey, ciphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+	parts := strings.Split(string(plaintext), ":")
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("Invalid session key %s", plaintext)
+	}
+	if key, err = hex.DecodeString(parts[0]); err != nil {
+		return nil, nil, err
+	}
+	if iv, err = hex.DecodeString(parts[1]); err != nil {
+		return nil, nil, err
+	}
+	return key, iv, nil
+}
+
+// decryptCommand decrypts an escaped cipher and removes the salt
+func (fm *fakeMiniserver) decryptCommand(key, iv []byte, escapedCipher string) (string, error) {
+	b64, err := url.QueryUnescape(escapedCipher)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := aesDecrypt(key, iv, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(string(plaintext), "/", 4)
+	switch {
+	case parts[0] == "salt" && len(parts) >= 3:
+		return strings.Join(parts[2:], "/"), nil
+	case parts[0] == "nextSalt" && len(parts) == 4:
+		return parts[3], nil
+	}
+	return "", fmt.Errorf("Command without salt: %s", plaintext)
+}
+
+func (fm *fakeMiniserver) encryptResponse(key, iv []byte, response string) string {
+	ciphertext, _ := aesEncrypt(key, iv, []byte(response))
+	return base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+// serveEncrypted handles jdev/sys/enc and jdev/sys/fenc requests
+func (fm *fakeMiniserver) serveEncrypted(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(req.URL.EscapedPath(), "/")
+	key, iv, err := fm.sessionKey(req.URL.Query().Get("sk"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	cmd, err := fm.decryptCommand(key, iv, parts[4])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	fm.mu.Lock()
+	fm.encrypted++
+	fm.mu.Unlock()
+	u, err := url.Parse("/" + cmd)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	inner := *req
+	inner.URL = u
+	rec := httptest.NewRecorder()
+	fm.ServeHTTP(rec, &inner)
+	body := rec.Body.String()
+	if parts[3] == "fenc" && rec.Code == http.StatusOK {
+		body = fm.encryptResponse(key, iv, body)
+	}
+	w.WriteHeader(rec.Code)
+	fmt.Fprint(w, body)
+}
+
+func TestEncrypter_SaltCommand(t *testing.T) {
+	e := &encrypter{}
+	first := e.saltCommand("jdev/sps/io/VI1/On")
+	salt := e.salt
+	if first != "salt/"+salt+"/jdev/sps/io/VI1/On" {
+		t.Errorf("saltCommand() = %s", first)
+	}
+	for i := 1; i < saltMaxUses; i++ {
+		e.saltCommand("jdev/sps/io/VI1/On")
+	}
+	if e.salt != salt {
+		t.Errorf("Salt changed before %d uses", saltMaxUses)
+	}
+	next := e.saltCommand("jdev/sps/io/VI1/On")
+	if e.salt == salt {
+		t.Errorf("Salt not changed after %d uses", saltMaxUses)
+	}
+	if next != fmt.Sprintf("nextSalt/%s/%s/jdev/sps/io/VI1/On", salt, e.salt) {
+		t.Errorf("saltCommand() after %d uses = %s", saltMaxUses, next)
+	}
+}
+
+func TestAESRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	iv := make([]byte, 16)
+	tests := []string{
+		"a",
+		"salt/1234/jdev/sps/io/VI1/On\x00",
+		"exactly 16 bytes",
+	}
+	for _, plaintext := range tests {
+		ciphertext, err := aesEncrypt(key, iv, []byte(plaintext))
+		if err != nil {
+			t.Fatalf("aesEncrypt() error = %v", err)
+		}
+		got, err := aesDecrypt(key, iv, ciphertext)
+		if err != nil {
+			t.Fatalf("aesDecrypt() error = %v", err)
+		}
+		if want := strings.TrimRight(plaintext, "\x00"); string(got) != want {
+			t.Errorf("aesDecrypt() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestClient_Encrypted(t *testing.T) {
+	tests := []struct {
+		name       string
+		transport  string
+		encryption string
+	}{
+		{
+			name:       "HTTPEnc",
+			transport:  "http",
+			encryption: "enc",
+		},
+		{
+			name:       "HTTPFenc",
+			transport:  "http",
+			encryption: "fenc",
+		},
+		{
+			name:       "WebsocketEnc",
+			transport:  "websocket",
+			encryption: "enc",
+		},
+		{
+			name:       "WebsocketFenc",
+			transport:  "websocket",
+			encryption: "fenc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm, server := newFakeMiniserver()
+			defer server.Close()
+			logger := logging.Discard()
+			cfg := newTestConfig(server.URL, "token", fm.password)
+			cfg.Transport = tt.transport
+			cfg.Encryption = tt.encryption
+
+			ms, err := NewTransport(cfg, logger)
+			if err != nil {
+				t.Fatalf("NewTransport() error = %v", err)
+			}
+			resp, err := ms.Send("/dev/sps/io/VI1/Pulse")
+			if err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Send() status code = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if !strings.Contains(string(body), `"value": "1"`) {
+				t.Errorf("Send() body = %s", body)
+			}
+			if err := ms.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+			fm.mu.Lock()
+			defer fm.mu.Unlock()
+			if len(fm.commands) != 1 {
+				t.Errorf("Miniserver received %v", fm.commands)
+			}
+			if fm.encrypted < 2 {
+				t.Errorf("Miniserver received %d encrypted commands, want at least 2", fm.encrypted)
+			}
+			if !fm.killed {
+				t.Errorf("Close() did not kill the token")
+			}
+		})
+	}
+}
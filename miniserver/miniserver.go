@@ -0,0 +1,347 @@
+// Package miniserver handles the communication with a Loxone Miniserver
+package miniserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/axxelG/loxwebhook/config"
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+// Transport sends commands to a Miniserver
+type Transport interface {
+	Send(path string) (*http.Response, error)
+	// Status returns why commands cannot be sent at the moment, nil if
+	// the transport is connected and authenticated
+	Status() error
+	Close() error
+}
+
+// NewTransports returns a Transport for every Miniserver in cfg by name. It
+// fails if the default Miniserver cannot be connected. The other Miniservers
+// are connected later if they are offline.
+func NewTransports(cfg *config.Config, logger *slog.Logger) (map[string]Transport, error) {
+	transports := make(map[string]Transport)
+	for _, name := range cfg.GetMiniserverNames() {
+		m, _ := cfg.GetMiniserver(name)
+		msLogger := logger.With("miniserver", name)
+		t, err := NewTransport(m, msLogger)
+		if err != nil && name != config.DefaultMiniserver {
+			msLogger.Warn("Cannot connect to Miniserver, retrying with the next request or health probe", logging.Err(err))
+			t, err = newPendingTransport(m, msLogger), nil
+		}
+		if err != nil {
+			for _, t := range transports {
+				t.Close()
+			}
+			return nil, errors.Wrap(err, "Miniserver "+name)
+		}
+		transports[name] = t
+	}
+	return transports, nil
+}
+
+// pendingTransport connects to a Miniserver that was offline at startup.
+// Send and Status retry to connect until it succeeds.
+type pendingTransport struct {
+	m      config.Miniserver // Copy, the config drops the passwords after startup
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	transport Transport
+}
+
+func newPendingTransport(m *config.Miniserver, logger *slog.Logger) *pendingTransport {
+	return &pendingTransport{m: *m, logger: logger}
+}
+
+// connect returns the Transport of the Miniserver and connects it first if
+// necessary
+func (p *pendingTransport) connect() (Transport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.transport != nil {
+		return p.transport, nil
+	}
+	t, err := NewTransport(&p.m, p.logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "Miniserver not connected")
+	}
+	p.logger.Info("Connected to Miniserver")
+	p.transport = t
+	return t, nil
+}
+
+// Send connects to the Miniserver if necessary and sends the command
+func (p *pendingTransport) Send(path string) (*http.Response, error) {
+	t, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	return t.Send(path)
+}
+
+// Status connects to the Miniserver if necessary and returns the status of
+// its Transport
+func (p *pendingTransport) Status() error {
+	t, err := p.connect()
+	if err != nil {
+		return err
+	}
+	return t.Status()
+}
+
+// Close closes the Transport if the Miniserver was connected
+func (p *pendingTransport) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.transport == nil {
+		return nil
+	}
+	return p.transport.Close()
+}
+
+// NewTransport returns the Transport selected by m.Transport
+func NewTransport(m *config.Miniserver, logger *slog.Logger) (Transport, error) {
+	c, err := NewClient(m, logger)
+	if err != nil {
+		return nil, err
+	}
+	switch m.Transport {
+	case "", "http":
+		return c, nil
+	case "websocket":
+		tlsConfig, err := m.TLSConfig()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		ws := newWsClient(c, m.Timeout, tlsConfig, logger)
+		if err := ws.start(); err != nil {
+			c.Close()
+			return nil, errors.Wrap(err, "Error connecting to Miniserver websocket")
+		}
+		return ws, nil
+	default:
+		c.Close()
+		return nil, fmt.Errorf("Unknown Miniserver transport %s", m.Transport)
+	}
+}
+
+// Client sends requests to a Miniserver. Depending on the config it
+// authenticates with a token or with Basic Auth.
+type Client struct {
+	baseURL    url.URL
+	httpClient *http.Client
+	user       string
+	password   string // Basic Auth only
+	token      *tokenAuth
+	enc        *encrypter
+}
+
+// NewClient returns a Client for the Miniserver m. With token
+// authentication the password is only used to request the token.
+func NewClient(m *config.Miniserver, logger *slog.Logger) (*Client, error) {
+	httpClient, err := m.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		baseURL:    *m.URL,
+		httpClient: httpClient,
+		user:       m.User,
+	}
+	switch m.Encryption {
+	case "", "none":
+		// Nothing to do
+	case "enc", "fenc":
+		var key string
+		if err := c.getJSON("/jdev/sys/getPublicKey", &key); err != nil {
+			return nil, errors.Wrap(err, "Error requesting public key from Miniserver")
+		}
+		pub, err := parsePublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if c.enc, err = newEncrypter(pub, m.Encryption); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown Miniserver encryption %s", m.Encryption)
+	}
+	switch m.Auth {
+	case "basic":
+		c.password = m.Password
+	case "token":
+		c.token = newTokenAuth(c, m.User, logger)
+		if err := c.token.acquire(m.Password); err != nil {
+			return nil, errors.Wrap(err, "Error requesting token from Miniserver")
+		}
+		c.token.start()
+	default:
+		return nil, fmt.Errorf("Unknown Miniserver authentication %s", m.Auth)
+	}
+	return c, nil
+}
+
+// getURL returns the URL for path. path might contain escaped user input.
+func (c *Client) getURL(path string, query url.Values) (string, error) {
+	u := c.baseURL
+	unescapedPath, err := url.PathUnescape(path)
+	if err != nil {
+		return "", err
+	}
+	u.Path = unescapedPath
+	u.RawPath = path
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (c *Client) do(method, path string, query url.Values) (*http.Response, error) {
+	u, err := c.getURL(path, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error preparing request")
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error preparing request")
+	}
+	if c.password != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error sending request to Miniserver")
+	}
+	return resp, nil
+}
+
+// send sends path with the query parameters query. If encryption is enabled
+// path and query are sent encrypted.
+func (c *Client) send(path string, query url.Values) (*http.Response, error) {
+	if c.enc == nil {
+		return c.do("POST", path, query)
+	}
+	cmd := strings.TrimPrefix(path, "/")
+	if len(query) > 0 {
+		cmd += "?" + query.Encode()
+	}
+	encPath, err := c.enc.encryptCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do("GET", "/"+encPath, url.Values{"sk": {c.enc.sessionKey}})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.enc.decryptResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Send sends a command to the Miniserver
+func (c *Client) Send(path string) (*http.Response, error) {
+	if c.token == nil {
+		return c.send(path, nil)
+	}
+	resp, err := c.send(path, c.token.authParams())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	// The Miniserver might have been restarted or the token is about to
+	// expire. Refresh the token and try once more.
+	resp.Body.Close()
+	if err := c.token.refresh(); err != nil {
+		return nil, errors.Wrap(err, "Error refreshing token")
+	}
+	return c.send(path, c.token.authParams())
+}
+
+// Status returns an error if the token expired or could not be refreshed
+func (c *Client) Status() error {
+	if c.token == nil {
+		return nil
+	}
+	return c.token.status(time.Now())
+}
+
+// Close releases the token on the Miniserver
+func (c *Client) Close() error {
+	if c.token == nil {
+		return nil
+	}
+	return c.token.stop()
+}
+
+// getJSON sends an unauthenticated request to the Miniserver and unmarshals
+// the value of the LoxLIVE JSON response into v
+func (c *Client) getJSON(path string, v interface{}) error {
+	resp, err := c.do("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	return readLL(resp, v)
+}
+
+// getSecureJSON works like getJSON but sends the request encrypted if
+// encryption is enabled
+func (c *Client) getSecureJSON(path string, v interface{}) error {
+	resp, err := c.send(path, nil)
+	if err != nil {
+		return err
+	}
+	return readLL(resp, v)
+}
+
+// readLL reads resp and unmarshals the value of the LoxLIVE JSON response into v
+func readLL(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Miniserver responded with status code %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "Error reading Miniserver response")
+	}
+	return parseLL(body, v)
+}
+
+// parseLL unmarshals the value of a LoxLIVE JSON response into v
+func parseLL(body []byte, v interface{}) error {
+	var ll struct {
+		LL struct {
+			Control string          `json:"control"`
+			Value   json.RawMessage `json:"value"`
+			Code    json.RawMessage `json:"Code"`
+		}
+	}
+	if err := json.Unmarshal(body, &ll); err != nil {
+		return errors.Wrap(err, "Cannot parse Miniserver response")
+	}
+	code, err := strconv.Atoi(strings.Trim(string(ll.LL.Code), `"`))
+	if err != nil {
+		return fmt.Errorf("Invalid code in Miniserver response: %s", ll.LL.Code)
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Miniserver responded with code %d to %s", code, ll.LL.Control)
+	}
+	if err := json.Unmarshal(ll.LL.Value, v); err != nil {
+		return errors.Wrap(err, "Cannot parse value of Miniserver response")
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package miniserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/axxelG/loxwebhook/config"
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+// fakeMiniserver implements the token handshake of a Loxone Miniserver
+type fakeMiniserver struct {
+	user     string
+	password string
+	salt     string
+	key      []byte // getkey2
+	tokenKey []byte // getkey
+
+	mu         sync.Mutex
+	token      string
+	tokenCount int
+	killed     bool
+	commands   []string
+	conns      []*websocket.Conn
+	encrypted  int
+	privateKey *rsa.PrivateKey
+}
+
+func newFakeMiniserver() (*fakeMiniserver, *httptest.Server) {
+	fm := &fakeMiniserver{
+		user:     "loxwebhook",
+		password: "secret",
+		salt:     "a1b2c3",
+		key:      []byte("keyForGetkey2"),
+		tokenKey: []byte("keyForGetkey"),
+	}
+	fm.privateKey, _ = rsa.GenerateKey(rand.Reader, 1024)
+	return fm, httptest.NewServer(fm)
+}
+
+// respond sends a LoxLIVE response. value must be valid JSON.
+func (fm *fakeMiniserver) respond(w http.ResponseWriter, control, value string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"LL": {"control": "%s", "value": %s, "Code": "%d"}}`, control, value, code)
+}
+
+func (fm *fakeMiniserver) newToken() string {
+	fm.tokenCount++
+	fm.token = fmt.Sprintf("token%d", fm.tokenCount)
+	return fmt.Sprintf(`{"token": "%s", "validUntil": %d}`,
+		fm.token,
+		int64(time.Now().Add(72*time.Hour).Sub(loxoneEpoch).Seconds()),
+	)
+}
+
+func (fm *fakeMiniserver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == wsPath {
+		fm.serveWs(w, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/jdev/sys/enc/") || strings.HasPrefix(req.URL.Path, "/jdev/sys/fenc/") {
+		fm.serveEncrypted(w, req)
+		return
+	}
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	control := strings.TrimPrefix(req.URL.Path, "/")
+	tokenHash := hmacHex("SHA256", fm.tokenKey, fm.token)
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/jdev/sys/getkey2/"):
+		fm.respond(w, control, fmt.Sprintf(`{"key": "%s", "salt": "%s", "hashAlg": "SHA256"}`,
+			hex.EncodeToString(fm.key), fm.salt), 200)
+	case req.URL.Path == "/jdev/sys/getPublicKey":
+		der, _ := x509.MarshalPKIXPublicKey(&fm.privateKey.PublicKey)
+		fm.respond(w, control, fmt.Sprintf(`"-----BEGIN CERTIFICATE-----%s-----END CERTIFICATE-----"`,
+			base64.StdEncoding.EncodeToString(der)), 200)
+	case req.URL.Path == "/jdev/sys/getkey":
+		fm.respond(w, control, fmt.Sprintf("%q", hex.EncodeToString(fm.tokenKey)), 200)
+	case strings.HasPrefix(req.URL.Path, "/jdev/sys/getjwt/"):
+		pwHash := strings.ToUpper(hashHex("SHA256", fm.password+":"+fm.salt))
+		if parts[3] != hmacHex("SHA256", fm.key, fm.user+":"+pwHash) || parts[4] != fm.user {
+			fm.respond(w, control, `""`, 401)
+			return
+		}
+		fm.respond(w, control, fm.newToken(), 200)
+	case strings.HasPrefix(req.URL.Path, "/jdev/sys/refreshjwt/"):
+		if parts[3] != tokenHash {
+			fm.respond(w, control, `""`, 401)
+			return
+		}
+		fm.respond(w, control, fm.newToken(), 200)
+	case strings.HasPrefix(req.URL.Path, "/jdev/sys/killtoken/"):
+		if parts[3] != tokenHash {
+			fm.respond(w, control, `""`, 401)
+			return
+		}
+		fm.killed = true
+		fm.respond(w, control, `""`, 200)
+	case strings.HasPrefix(req.URL.Path, "/dev/sps/io/"):
+		user, password, basicAuth := req.BasicAuth()
+		tokenAuth := fm.token != "" && !fm.killed &&
+			req.URL.Query().Get("autht") == tokenHash &&
+			req.URL.Query().Get("user") == fm.user
+		if !tokenAuth && !(basicAuth && user == fm.user && password == fm.password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fm.commands = append(fm.commands, req.URL.Path)
+		fm.respond(w, control, `"1"`, 200)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestConfig(serverURL, auth, password string) *config.Miniserver {
+	u, _ := url.Parse(serverURL)
+	return &config.Miniserver{
+		Name:     config.DefaultMiniserver,
+		URL:      u,
+		User:     "loxwebhook",
+		Password: password,
+		Timeout:  2 * time.Second,
+		Auth:     auth,
+	}
+}
+
+func TestClient_Token(t *testing.T) {
+	fm, server := newFakeMiniserver()
+	defer server.Close()
+	logger := logging.Discard()
+
+	c, err := NewClient(newTestConfig(server.URL, "token", fm.password), logger)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	resp, err := c.Send("/dev/sps/io/VI1/Pulse")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Send() status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if err := c.token.refresh(); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+	if fm.token != "token2" {
+		t.Errorf("refresh() did not replace token. Got: %s", fm.token)
+	}
+	resp, err = c.Send("/dev/sps/io/VI1/On")
+	if err != nil {
+		t.Fatalf("Send() after refresh error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Send() after refresh status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if !fm.killed {
+		t.Errorf("Close() did not kill the token")
+	}
+	if len(fm.commands) != 2 {
+		t.Errorf("Miniserver received %d commands, want 2", len(fm.commands))
+	}
+}
+
+func TestClient_TokenExpiredOnMiniserver(t *testing.T) {
+	fm, server := newFakeMiniserver()
+	defer server.Close()
+	logger := logging.Discard()
+
+	c, err := NewClient(newTestConfig(server.URL, "token", fm.password), logger)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer c.Close()
+	// A changed key invalidates the current hash. Send must refresh and retry.
+	fm.mu.Lock()
+	fm.tokenKey = []byte("newKeyForGetkey")
+	fm.mu.Unlock()
+	resp, err := c.Send("/dev/sps/io/VI1/Pulse")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Send() status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestTokenAuth_status(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		validUntil time.Time
+		refreshErr error
+		wantErr    bool
+	}{
+		{
+			name:       "Valid",
+			validUntil: now.Add(time.Hour),
+			wantErr:    false,
+		},
+		{
+			name:       "Expired",
+			validUntil: now.Add(-time.Second),
+			wantErr:    true,
+		},
+		{
+			name:       "RefreshFailed",
+			validUntil: now.Add(time.Hour),
+			refreshErr: errors.New("Error refreshing token"),
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := &tokenAuth{validUntil: tt.validUntil, refreshErr: tt.refreshErr}
+			if err := ta.status(now); (err != nil) != tt.wantErr {
+				t.Errorf("status() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPendingTransport(t *testing.T) {
+	fm, server := newFakeMiniserver()
+	defer server.Close()
+	logger := logging.Discard()
+
+	// The Miniserver rejects the password until it is changed below
+	p := newPendingTransport(newTestConfig(server.URL, "token", "changed"), logger)
+	if err := p.Status(); err == nil {
+		t.Fatalf("Status() before connect error = nil")
+	}
+	if _, err := p.Send("/dev/sps/io/VI1/Pulse"); err == nil {
+		t.Fatalf("Send() before connect error = nil")
+	}
+	fm.mu.Lock()
+	fm.password = "changed"
+	fm.mu.Unlock()
+	if err := p.Status(); err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	resp, err := p.Send("/dev/sps/io/VI1/Pulse")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Send() status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if !fm.killed {
+		t.Errorf("Close() did not kill the token")
+	}
+}
+
+func TestClient_TokenWrongPassword(t *testing.T) {
+	_, server := newFakeMiniserver()
+	defer server.Close()
+	logger := logging.Discard()
+
+	_, err := NewClient(newTestConfig(server.URL, "token", "wrong"), logger)
+	if err == nil {
+		t.Errorf("NewClient() with wrong password returned no error")
+	}
+}
+
+func TestClient_Basic(t *testing.T) {
+	fm, server := newFakeMiniserver()
+	defer server.Close()
+	logger := logging.Discard()
+
+	c, err := NewClient(newTestConfig(server.URL, "basic", fm.password), logger)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer c.Close()
+	resp, err := c.Send("/dev/sps/io/VI1/Pulse")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Send() status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestClient_SendEscapedPath(t *testing.T) {
+	fm, server := newFakeMiniserver()
+	defer server.Close()
+	logger := logging.Discard()
+
+	c, err := NewClient(newTestConfig(server.URL, "basic", fm.password), logger)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	resp, err := c.Send("/dev/sps/io/VI5/a%2Fb%20c")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	resp.Body.Close()
+	if len(fm.commands) != 1 || fm.commands[0] != "/dev/sps/io/VI5/a/b c" {
+		t.Errorf("Miniserver received %v", fm.commands)
+	}
+}
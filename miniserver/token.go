@@ -0,0 +1,249 @@
+package miniserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+// loxoneEpoch is the reference of the validUntil values sent by the Miniserver
+var loxoneEpoch = time.Date(2009, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	tokenPermission    = 4 // App permission for long lived tokens
+	tokenClientInfo    = "loxwebhook"
+	tokenRefreshMargin = 24 * time.Hour
+	tokenRetryInterval = time.Minute
+)
+
+type keyInfo struct {
+	Key     string `json:"key"`
+	Salt    string `json:"salt"`
+	HashAlg string `json:"hashAlg"`
+}
+
+type tokenInfo struct {
+	Token      string `json:"token"`
+	ValidUntil int64  `json:"validUntil"`
+}
+
+// tokenAuth implements the Loxone token authentication
+type tokenAuth struct {
+	client *Client
+	user   string
+	uuid   string
+	logger *slog.Logger
+
+	mu         sync.Mutex
+	token      string
+	hashAlg    string
+	key        []byte // From jdev/sys/getkey, used to hash the token
+	validUntil time.Time
+	refreshErr error // Error of the last refresh
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newTokenAuth(c *Client, user string, logger *slog.Logger) *tokenAuth {
+	return &tokenAuth{
+		client: c,
+		user:   user,
+		uuid:   newClientUUID(),
+		logger: logger,
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// newClientUUID returns a random UUID that identifies loxwebhook on the Miniserver
+func newClientUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func newHash(alg string) func() hash.Hash {
+	if strings.ToUpper(alg) == "SHA256" {
+		return sha256.New
+	}
+	return sha1.New
+}
+
+func hashHex(alg, msg string) string {
+	h := newHash(alg)()
+	h.Write([]byte(msg))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hmacHex(alg string, key []byte, msg string) string {
+	h := hmac.New(newHash(alg), key)
+	h.Write([]byte(msg))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// acquire requests a new token. This is the only place the password is used.
+func (t *tokenAuth) acquire(password string) error {
+	var ki keyInfo
+	if err := t.client.getJSON("/jdev/sys/getkey2/"+url.PathEscape(t.user), &ki); err != nil {
+		return errors.Wrap(err, "Error requesting key")
+	}
+	key, err := hex.DecodeString(ki.Key)
+	if err != nil {
+		return errors.Wrap(err, "Invalid key")
+	}
+	pwHash := strings.ToUpper(hashHex(ki.HashAlg, password+":"+ki.Salt))
+	hash := hmacHex(ki.HashAlg, key, t.user+":"+pwHash)
+	path := fmt.Sprintf("/jdev/sys/getjwt/%s/%s/%d/%s/%s",
+		hash,
+		url.PathEscape(t.user),
+		tokenPermission,
+		t.uuid,
+		url.PathEscape(tokenClientInfo),
+	)
+	var ti tokenInfo
+	if err := t.client.getSecureJSON(path, &ti); err != nil {
+		// Miniservers before version 10.2 only know gettoken
+		path = strings.Replace(path, "/getjwt/", "/gettoken/", 1)
+		if errToken := t.client.getSecureJSON(path, &ti); errToken != nil {
+			return errors.Wrap(err, "Error requesting token")
+		}
+	}
+	if ti.Token == "" {
+		return errors.New("Miniserver sent an empty token")
+	}
+	t.mu.Lock()
+	t.token = ti.Token
+	t.hashAlg = ki.HashAlg
+	t.validUntil = loxoneEpoch.Add(time.Duration(ti.ValidUntil) * time.Second)
+	t.mu.Unlock()
+	return t.updateKey()
+}
+
+// updateKey requests the key used to hash the token
+func (t *tokenAuth) updateKey() error {
+	var keyHex string
+	if err := t.client.getJSON("/jdev/sys/getkey", &keyHex); err != nil {
+		return errors.Wrap(err, "Error requesting key")
+	}
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return errors.Wrap(err, "Invalid key")
+	}
+	t.mu.Lock()
+	t.key = key
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *tokenAuth) tokenHash() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return hmacHex(t.hashAlg, t.key, t.token)
+}
+
+// authParams returns the query parameters that authenticate a request
+func (t *tokenAuth) authParams() url.Values {
+	return url.Values{
+		"autht": {t.tokenHash()},
+		"user":  {t.user},
+	}
+}
+
+// refresh extends the lifetime of the token
+func (t *tokenAuth) refresh() (err error) {
+	defer func() {
+		t.mu.Lock()
+		t.refreshErr = err
+		t.mu.Unlock()
+	}()
+	if err := t.updateKey(); err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/jdev/sys/refreshjwt/%s/%s", t.tokenHash(), url.PathEscape(t.user))
+	var ti tokenInfo
+	if err := t.client.getSecureJSON(path, &ti); err != nil {
+		// Miniservers before version 10.2 only know refreshtoken
+		path = strings.Replace(path, "/refreshjwt/", "/refreshtoken/", 1)
+		if errToken := t.client.getSecureJSON(path, &ti); errToken != nil {
+			return errors.Wrap(err, "Error refreshing token")
+		}
+	}
+	t.mu.Lock()
+	if ti.Token != "" {
+		t.token = ti.Token
+	}
+	t.validUntil = loxoneEpoch.Add(time.Duration(ti.ValidUntil) * time.Second)
+	t.mu.Unlock()
+	return nil
+}
+
+// status returns an error if the token expired or the last refresh failed
+func (t *tokenAuth) status(now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !now.Before(t.validUntil) {
+		return errors.New("Miniserver token expired")
+	}
+	return t.refreshErr
+}
+
+// nextRefresh returns the time until the token should be refreshed
+func (t *tokenAuth) nextRefresh() time.Duration {
+	t.mu.Lock()
+	remaining := time.Until(t.validUntil)
+	t.mu.Unlock()
+	if remaining > 2*tokenRefreshMargin {
+		return remaining - tokenRefreshMargin
+	}
+	if remaining/2 < tokenRetryInterval {
+		return tokenRetryInterval
+	}
+	return remaining / 2
+}
+
+// start refreshes the token in the background until stop is called
+func (t *tokenAuth) start() {
+	go func() {
+		defer close(t.done)
+		wait := t.nextRefresh()
+		for {
+			select {
+			case <-time.After(wait):
+				if err := t.refresh(); err != nil {
+					t.logger.Error("Token refresh failed, retrying", "retry", tokenRetryInterval.String(), logging.Err(err))
+					wait = tokenRetryInterval
+					continue
+				}
+				wait = t.nextRefresh()
+			case <-t.quit:
+				return
+			}
+		}
+	}()
+}
+
+// stop ends the background refresh and kills the token on the Miniserver
+func (t *tokenAuth) stop() error {
+	close(t.quit)
+	<-t.done
+	path := fmt.Sprintf("/jdev/sys/killtoken/%s/%s", t.tokenHash(), url.PathEscape(t.user))
+	var v interface{}
+	if err := t.client.getSecureJSON(path, &v); err != nil {
+		return errors.Wrap(err, "Error killing token")
+	}
+	return nil
+}
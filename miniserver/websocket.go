@@ -0,0 +1,464 @@
+package miniserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+const (
+	wsPath              = "/ws/rfc6455"
+	wsProtocol          = "remotecontrol"
+	wsKeepaliveInterval = time.Minute
+	wsMinBackoff        = time.Second
+	wsMaxBackoff        = time.Minute
+)
+
+// Identifiers of Loxone websocket message headers
+const (
+	wsTextMessage         = 0
+	wsOutOfServiceMessage = 5
+	wsKeepaliveMessage    = 6
+)
+
+var errNotConnected = errors.New("Not connected to Miniserver")
+
+type wsTimeoutError struct {
+	command string
+}
+
+func (e *wsTimeoutError) Error() string {
+	return fmt.Sprintf("Timeout waiting for Miniserver response to %s", e.command)
+}
+
+// Timeout marks the error as timeout like net.Error
+func (e *wsTimeoutError) Timeout() bool {
+	return true
+}
+
+type wsHeader struct {
+	Identifier byte
+	Estimated  bool
+	Length     uint32
+}
+
+// parseWsHeader returns the header of a Loxone websocket message.
+// ok is false if msg is not a header.
+func parseWsHeader(msg []byte) (h wsHeader, ok bool) {
+	if len(msg) != 8 || msg[0] != 0x03 {
+		return h, false
+	}
+	h.Identifier = msg[1]
+	h.Estimated = msg[2]&0x80 != 0
+	h.Length = binary.LittleEndian.Uint32(msg[4:])
+	return h, true
+}
+
+type wsRequest struct {
+	control string
+	result  chan []byte
+}
+
+// wsClient keeps one authenticated websocket connection to the Miniserver.
+// Tokens are managed by the embedded HTTP client.
+type wsClient struct {
+	client    *Client
+	url       string
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	conn    *websocket.Conn
+	ready   bool
+	pending []*wsRequest
+	writeMu sync.Mutex
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newWsClient(c *Client, timeout time.Duration, tlsConfig *tls.Config, logger *slog.Logger) *wsClient {
+	u := c.baseURL
+	u.Scheme = "ws"
+	if c.baseURL.Scheme == "https" {
+		u.Scheme = "wss"
+	}
+	u.Path = wsPath
+	return &wsClient{
+		client:    c,
+		url:       u.String(),
+		timeout:   timeout,
+		tlsConfig: tlsConfig,
+		logger:    logger,
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// normalizeControl makes commands and the control field of responses comparable
+func normalizeControl(control string) string {
+	if c, err := url.PathUnescape(control); err == nil {
+		control = c
+	}
+	control = strings.Trim(control, "/")
+	return strings.TrimPrefix(control, "j")
+}
+
+// request sends cmd and waits for the matching response
+func (ws *wsClient) request(cmd string, needReady bool) ([]byte, error) {
+	r := &wsRequest{
+		control: normalizeControl(cmd),
+		result:  make(chan []byte, 1),
+	}
+	ws.mu.Lock()
+	conn := ws.conn
+	if conn == nil || (needReady && !ws.ready) {
+		ws.mu.Unlock()
+		return nil, errNotConnected
+	}
+	ws.pending = append(ws.pending, r)
+	ws.mu.Unlock()
+
+	ws.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(ws.timeout))
+	err := conn.WriteMessage(websocket.TextMessage, []byte(cmd))
+	ws.writeMu.Unlock()
+	if err != nil {
+		ws.removePending(r)
+		return nil, errors.Wrap(err, "Error sending command to Miniserver")
+	}
+
+	select {
+	case body, ok := <-r.result:
+		if !ok {
+			return nil, errNotConnected
+		}
+		return body, nil
+	case <-time.After(ws.timeout):
+		ws.removePending(r)
+		return nil, &wsTimeoutError{command: cmd}
+	}
+}
+
+// command sends cmd encrypted if encryption is enabled
+func (ws *wsClient) command(cmd string, needReady bool) ([]byte, error) {
+	enc := ws.client.enc
+	if enc == nil {
+		return ws.request(cmd, needReady)
+	}
+	encCmd, err := enc.encryptCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ws.request(encCmd, needReady)
+	if err != nil {
+		return nil, err
+	}
+	return enc.decrypt(body)
+}
+
+// keyExchange sends the session key that is used for encrypted commands
+func (ws *wsClient) keyExchange() error {
+	body, err := ws.request("jdev/sys/keyexchange/"+ws.client.enc.sessionKey, false)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	if err := parseLL(body, &v); err != nil {
+		return errors.Wrap(err, "Key exchange failed")
+	}
+	return nil
+}
+
+func (ws *wsClient) removePending(r *wsRequest) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for i, p := range ws.pending {
+		if p == r {
+			ws.pending = append(ws.pending[:i], ws.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch hands a text message to the matching request. The Miniserver
+// answers in order, so the oldest request is used if no control matches.
+func (ws *wsClient) dispatch(msg []byte) {
+	var ll struct {
+		LL struct {
+			Control string `json:"control"`
+		}
+	}
+	json.Unmarshal(msg, &ll)
+	control := normalizeControl(ll.LL.Control)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if len(ws.pending) == 0 {
+		return
+	}
+	i := 0
+	for j, p := range ws.pending {
+		if p.control == control {
+			i = j
+			break
+		}
+	}
+	r := ws.pending[i]
+	ws.pending = append(ws.pending[:i], ws.pending[i+1:]...)
+	r.result <- msg
+}
+
+// readLoop reads messages until the connection fails
+func (ws *wsClient) readLoop(conn *websocket.Conn, errc chan<- error) {
+	var expect *wsHeader
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			errc <- err
+			return
+		}
+		if mt == websocket.BinaryMessage {
+			if h, ok := parseWsHeader(msg); ok {
+				switch {
+				case h.Estimated:
+					// The exact header follows
+				case h.Identifier == wsKeepaliveMessage:
+					// Keepalive responses have no payload
+				case h.Identifier == wsOutOfServiceMessage:
+					errc <- errors.New("Miniserver is out of service")
+					return
+				default:
+					expect = &h
+				}
+				continue
+			}
+		}
+		if expect != nil && expect.Identifier == wsTextMessage {
+			ws.dispatch(msg)
+		}
+		expect = nil
+	}
+}
+
+// authenticate authenticates the connection with the token or with user and password
+func (ws *wsClient) authenticate() error {
+	body, err := ws.request("jdev/sys/getkey", false)
+	if err != nil {
+		return err
+	}
+	var keyHex string
+	if err := parseLL(body, &keyHex); err != nil {
+		return err
+	}
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return errors.Wrap(err, "Invalid key")
+	}
+	c := ws.client
+	var cmd string
+	if c.token != nil {
+		c.token.mu.Lock()
+		hash := hmacHex(c.token.hashAlg, key, c.token.token)
+		c.token.mu.Unlock()
+		cmd = fmt.Sprintf("authwithtoken/%s/%s", hash, url.PathEscape(c.user))
+	} else {
+		cmd = "authenticate/" + hmacHex("SHA1", key, c.user+":"+c.password)
+	}
+	body, err = ws.command(cmd, false)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	if err := parseLL(body, &v); err != nil {
+		return errors.Wrap(err, "Websocket authentication failed")
+	}
+	return nil
+}
+
+// connect opens and authenticates a new connection. The returned channel
+// receives the error that ends the connection.
+func (ws *wsClient) connect() (<-chan error, error) {
+	dialer := websocket.Dialer{
+		HandshakeTimeout: ws.timeout,
+		Subprotocols:     []string{wsProtocol},
+		TLSClientConfig:  ws.tlsConfig,
+	}
+	conn, _, err := dialer.Dial(ws.url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error opening websocket")
+	}
+	errc := make(chan error, 1)
+	ws.mu.Lock()
+	ws.conn = conn
+	ws.mu.Unlock()
+	go ws.readLoop(conn, errc)
+	if ws.client.enc != nil {
+		if err := ws.keyExchange(); err != nil {
+			ws.disconnect()
+			return nil, err
+		}
+	}
+	if err := ws.authenticate(); err != nil {
+		ws.disconnect()
+		return nil, err
+	}
+	ws.mu.Lock()
+	ws.ready = true
+	ws.mu.Unlock()
+	return errc, nil
+}
+
+// disconnect closes the connection and fails all pending requests
+func (ws *wsClient) disconnect() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.conn != nil {
+		ws.conn.Close()
+	}
+	ws.conn = nil
+	ws.ready = false
+	for _, r := range ws.pending {
+		close(r.result)
+	}
+	ws.pending = nil
+}
+
+// serve sends keepalives until the connection fails or the client is closed
+func (ws *wsClient) serve(errc <-chan error) error {
+	ticker := time.NewTicker(wsKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case <-ticker.C:
+			ws.mu.Lock()
+			conn := ws.conn
+			ws.mu.Unlock()
+			ws.writeMu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(ws.timeout))
+			err := conn.WriteMessage(websocket.TextMessage, []byte("keepalive"))
+			ws.writeMu.Unlock()
+			if err != nil {
+				return err
+			}
+		case <-ws.quit:
+			return nil
+		}
+	}
+}
+
+// run keeps the connection open and reconnects with backoff
+func (ws *wsClient) run(errc <-chan error) {
+	defer close(ws.done)
+	backoff := wsMinBackoff
+	for {
+		err := ws.serve(errc)
+		ws.disconnect()
+		for {
+			select {
+			case <-ws.quit:
+				return
+			default:
+			}
+			ws.logger.Warn("Websocket connection to Miniserver lost", logging.Err(err))
+			select {
+			case <-time.After(backoff):
+			case <-ws.quit:
+				return
+			}
+			errc, err = ws.connect()
+			if err == nil {
+				ws.logger.Info("Websocket connection to Miniserver reestablished")
+				backoff = wsMinBackoff
+				break
+			}
+			backoff *= 2
+			if backoff > wsMaxBackoff {
+				backoff = wsMaxBackoff
+			}
+		}
+	}
+}
+
+// start opens the first connection and keeps it open in the background
+func (ws *wsClient) start() error {
+	errc, err := ws.connect()
+	if err != nil {
+		return err
+	}
+	go ws.run(errc)
+	return nil
+}
+
+// Send sends a command over the websocket. The LoxLIVE response is returned
+// as http.Response to be interchangeable with the HTTP transport.
+func (ws *wsClient) Send(path string) (*http.Response, error) {
+	cmd := strings.TrimPrefix(path, "/")
+	if strings.HasPrefix(cmd, "dev/") {
+		// Request JSON responses
+		cmd = "j" + cmd
+	}
+	body, err := ws.command(cmd, true)
+	if err != nil {
+		return nil, err
+	}
+	var ll struct {
+		LL struct {
+			Code json.RawMessage `json:"Code"`
+		}
+	}
+	statusCode := http.StatusOK
+	if json.Unmarshal(body, &ll) == nil {
+		if code, err := strconv.Atoi(strings.Trim(string(ll.LL.Code), `"`)); err == nil {
+			statusCode = code
+		}
+	}
+	req, _ := http.NewRequest("GET", ws.url, nil)
+	req.URL.Path = "/" + cmd
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// Status returns an error if the websocket is not connected and
+// authenticated or the token cannot be refreshed
+func (ws *wsClient) Status() error {
+	ws.mu.Lock()
+	ready := ws.conn != nil && ws.ready
+	ws.mu.Unlock()
+	if !ready {
+		return errNotConnected
+	}
+	return ws.client.Status()
+}
+
+// Close closes the websocket and releases the token
+func (ws *wsClient) Close() error {
+	close(ws.quit)
+	<-ws.done
+	ws.disconnect()
+	return ws.client.Close()
+}
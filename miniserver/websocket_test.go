@@ -0,0 +1,276 @@
+package miniserver
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+// serveWs implements the websocket of a Miniserver on top of fm
+func (fm *fakeMiniserver) serveWs(w http.ResponseWriter, req *http.Request) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{wsProtocol}}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fm.mu.Lock()
+	fm.conns = append(fm.conns, conn)
+	fm.mu.Unlock()
+	send := func(identifier byte, msg string) {
+		header := []byte{0x03, identifier, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(msg)))
+		conn.WriteMessage(websocket.BinaryMessage, header)
+		if msg != "" {
+			conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		}
+	}
+	ll := func(control, value string, code int) string {
+		return fmt.Sprintf(`{"LL": {"control": "%s", "value": %s, "Code": "%d"}}`, control, value, code)
+	}
+	authenticated := false
+	var key, iv []byte
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		cmd := string(msg)
+		if cmd == "keepalive" {
+			send(wsKeepaliveMessage, "")
+			continue
+		}
+		parts := strings.Split(cmd, "/")
+		mode := ""
+		if strings.HasPrefix(cmd, "jdev/sys/enc/") || strings.HasPrefix(cmd, "jdev/sys/fenc/") {
+			mode = parts[2]
+			if cmd, err = fm.decryptCommand(key, iv, parts[3]); err != nil {
+				send(wsTextMessage, ll(string(msg), `""`, 400))
+				continue
+			}
+			parts = strings.Split(cmd, "/")
+		}
+		fm.mu.Lock()
+		if mode != "" {
+			fm.encrypted++
+		}
+		tokenHash := hmacHex("SHA256", fm.tokenKey, fm.token)
+		passwordHash := hmacHex("SHA1", fm.tokenKey, fm.user+":"+fm.password)
+		var response string
+		switch {
+		case strings.HasPrefix(cmd, "jdev/sys/keyexchange/"):
+			key, iv, err = fm.sessionKey(strings.TrimPrefix(cmd, "jdev/sys/keyexchange/"))
+			code := 200
+			if err != nil {
+				code = 400
+			}
+			response = ll(cmd, `""`, code)
+		case cmd == "jdev/sys/getkey":
+			response = ll(cmd, fmt.Sprintf("%q", hex.EncodeToString(fm.tokenKey)), 200)
+		case strings.HasPrefix(cmd, "authwithtoken/"), strings.HasPrefix(cmd, "authenticate/"):
+			if strings.HasPrefix(cmd, "authwithtoken/") {
+				authenticated = parts[1] == tokenHash && parts[2] == fm.user
+			} else {
+				authenticated = parts[1] == passwordHash
+			}
+			code := 200
+			if !authenticated {
+				code = 401
+			}
+			response = ll(cmd, `""`, code)
+		case strings.HasSuffix(cmd, "/slow"):
+			// Never answered
+		case strings.HasPrefix(cmd, "jdev/sps/io/") && authenticated:
+			fm.commands = append(fm.commands, cmd)
+			response = ll(strings.TrimPrefix(cmd, "j"), `"1"`, 200)
+		default:
+			response = ll(cmd, `""`, 401)
+		}
+		fm.mu.Unlock()
+		if response == "" {
+			continue
+		}
+		if mode == "fenc" {
+			response = fm.encryptResponse(key, iv, response)
+		}
+		send(wsTextMessage, response)
+	}
+}
+
+// dropConnections closes all websocket connections from the server side
+func (fm *fakeMiniserver) dropConnections() {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	for _, conn := range fm.conns {
+		conn.Close()
+	}
+	fm.conns = nil
+}
+
+func TestParseWsHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    []byte
+		want   wsHeader
+		wantOk bool
+	}{
+		{
+			name:   "Text",
+			msg:    []byte{0x03, 0x00, 0x00, 0x00, 0x2a, 0x01, 0x00, 0x00},
+			want:   wsHeader{Identifier: wsTextMessage, Length: 298},
+			wantOk: true,
+		},
+		{
+			name:   "Estimated",
+			msg:    []byte{0x03, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00},
+			want:   wsHeader{Identifier: wsTextMessage, Estimated: true},
+			wantOk: true,
+		},
+		{
+			name:   "Keepalive",
+			msg:    []byte{0x03, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			want:   wsHeader{Identifier: wsKeepaliveMessage},
+			wantOk: true,
+		},
+		{
+			name:   "WrongLength",
+			msg:    []byte{0x03, 0x00, 0x00, 0x00},
+			wantOk: false,
+		},
+		{
+			name:   "WrongType",
+			msg:    []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseWsHeader(tt.msg)
+			if ok != tt.wantOk {
+				t.Fatalf("parseWsHeader() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if ok && got != tt.want {
+				t.Errorf("parseWsHeader() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWsClient(t *testing.T) {
+	tests := []struct {
+		name string
+		auth string
+	}{
+		{
+			name: "Token",
+			auth: "token",
+		},
+		{
+			name: "Basic",
+			auth: "basic",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm, server := newFakeMiniserver()
+			defer server.Close()
+			logger := logging.Discard()
+			cfg := newTestConfig(server.URL, tt.auth, fm.password)
+			cfg.Transport = "websocket"
+
+			ms, err := NewTransport(cfg, logger)
+			if err != nil {
+				t.Fatalf("NewTransport() error = %v", err)
+			}
+			defer ms.Close()
+			if err := ms.Status(); err != nil {
+				t.Errorf("Status() error = %v", err)
+			}
+			resp, err := ms.Send("/dev/sps/io/VI1/Pulse")
+			if err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Send() status code = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if !strings.Contains(string(body), `"control": "dev/sps/io/VI1/Pulse"`) {
+				t.Errorf("Send() body = %s", body)
+			}
+			fm.mu.Lock()
+			commands := fm.commands
+			fm.mu.Unlock()
+			if len(commands) != 1 || commands[0] != "jdev/sps/io/VI1/Pulse" {
+				t.Errorf("Miniserver received %v", commands)
+			}
+		})
+	}
+}
+
+func TestWsClient_Reconnect(t *testing.T) {
+	fm, server := newFakeMiniserver()
+	defer server.Close()
+	logger := logging.Discard()
+	cfg := newTestConfig(server.URL, "token", fm.password)
+	cfg.Transport = "websocket"
+
+	ms, err := NewTransport(cfg, logger)
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	defer ms.Close()
+	fm.dropConnections()
+	deadline := time.Now().Add(3 * wsMinBackoff)
+	for {
+		resp, err := ms.Send("/dev/sps/io/VI1/On")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Send() after reconnect error = %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestWsClient_Timeout(t *testing.T) {
+	fm, server := newFakeMiniserver()
+	defer server.Close()
+	logger := logging.Discard()
+	cfg := newTestConfig(server.URL, "basic", fm.password)
+	cfg.Transport = "websocket"
+	cfg.Timeout = 200 * time.Millisecond
+
+	ms, err := NewTransport(cfg, logger)
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	defer ms.Close()
+	_, err = ms.Send("/dev/sps/io/VI1/slow")
+	if e, ok := err.(interface{ Timeout() bool }); !ok || !e.Timeout() {
+		t.Errorf("Send() error = %v, want timeout", err)
+	}
+}
+
+func TestWsClient_WrongPassword(t *testing.T) {
+	_, server := newFakeMiniserver()
+	defer server.Close()
+	logger := logging.Discard()
+	cfg := newTestConfig(server.URL, "basic", "wrong")
+	cfg.Transport = "websocket"
+
+	if _, err := NewTransport(cfg, logger); err == nil {
+		t.Errorf("NewTransport() with wrong password returned no error")
+	}
+}
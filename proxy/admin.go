@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/axxelG/loxwebhook/logging"
+	"github.com/axxelG/loxwebhook/metrics"
+)
+
+// NewAdminServer returns the server of the administrative endpoints. It must
+// not be reachable from the public internet.
+func NewAdminServer(loggerErr *slog.Logger, limits *Limits, health *Health) *http.Server {
+	router := mux.NewRouter()
+	router.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(loggerErr, w, http.StatusOK, limits.status(time.Now()))
+	}).Methods(http.MethodGet)
+	// healthz only reports that the process serves requests
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		r := health.status(time.Now())
+		code := http.StatusOK
+		if !r.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(loggerErr, w, code, r)
+	}).Methods(http.MethodGet)
+	return &http.Server{
+		Handler:     router,
+		ReadTimeout: 10 * time.Second,
+		ErrorLog:    logging.NewStdLogger(loggerErr, slog.LevelError),
+	}
+}
+
+func writeJSON(loggerErr *slog.Logger, w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		loggerErr.Warn("Error writing status", logging.Err(err))
+	}
+}
+
+// NewMetricsServer returns the server of the Prometheus metrics endpoint
+// /metrics. It must not be reachable from the public internet.
+func NewMetricsServer(loggerErr *slog.Logger, m *metrics.Metrics) *http.Server {
+	router := mux.NewRouter()
+	router.Handle("/metrics", m.Handler()).Methods(http.MethodGet)
+	return &http.Server{
+		Handler:     router,
+		ReadTimeout: 10 * time.Second,
+		ErrorLog:    logging.NewStdLogger(loggerErr, slog.LevelError),
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+// trustedProxies are the reverse proxies whose forwarding headers are used
+type trustedProxies struct {
+	networks   []*net.IPNet
+	unixSocket bool // Trust the proxy connected over a Unix socket
+}
+
+// getClientIP returns the IP address of the client that sent req. The
+// headers X-Forwarded-For and Forwarded are only used if the request comes
+// from one of the trusted proxies. They are read from right to left and the
+// first address that is not a trusted proxy is the client. It returns nil if
+// the request came over a Unix socket without the headers of a trusted proxy.
+func getClientIP(req *http.Request, trusted trustedProxies) net.IP {
+	ip := getRemoteIP(req)
+	if !isFromTrustedProxy(req, trusted) {
+		return ip
+	}
+	var hops []string
+	if values := req.Header.Values("Forwarded"); len(values) > 0 {
+		hops = parseForwarded(values)
+	} else {
+		for _, v := range req.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hopIP := parseHop(hops[i])
+		if hopIP == nil {
+			// Unknown or obfuscated identifiers end the chain we can trust
+			return ip
+		}
+		ip = hopIP
+		if !helpers.IsIPInNetworks(ip, trusted.networks) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// getRemoteIP returns the IP of the peer of req. It is nil for connections
+// over a Unix socket.
+func getRemoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// isFromTrustedProxy returns true if req comes from one of the trusted
+// proxies. Only connections over a Unix socket have no IP.
+func isFromTrustedProxy(req *http.Request, trusted trustedProxies) bool {
+	ip := getRemoteIP(req)
+	if ip == nil {
+		return trusted.unixSocket
+	}
+	return helpers.IsIPInNetworks(ip, trusted.networks)
+}
+
+// parseForwarded returns the for= values of Forwarded headers (RFC 7239)
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+					hops = append(hops, strings.Trim(pair[4:], `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop returns the IP of a X-Forwarded-For or Forwarded entry. Entries
+// may contain a port and IPv6 addresses may be in brackets.
+func parseHop(hop string) net.IP {
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
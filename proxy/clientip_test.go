@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+func Test_getClientIP(t *testing.T) {
+	networks, err := helpers.ParseNetworks([]string{"10.0.0.1", "10.0.1.0/24", "fd00::/64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := trustedProxies{networks: networks, unixSocket: true}
+	tests := []struct {
+		name       string
+		trusted    *trustedProxies // nil uses trusted
+		remoteAddr string
+		header     map[string]string
+		want       string
+	}{
+		{
+			name:       "Direct",
+			remoteAddr: "203.0.113.7:51234",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "UntrustedProxy",
+			remoteAddr: "203.0.113.7:51234",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "XForwardedFor",
+			remoteAddr: "10.0.0.1:51234",
+			header:     map[string]string{"X-Forwarded-For": "192.0.2.99, 198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "ChainedProxies",
+			remoteAddr: "10.0.0.1:51234",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.1.5"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Forwarded",
+			remoteAddr: "[fd00::1]:443",
+			header:     map[string]string{"Forwarded": `for=192.0.2.60;proto=https, for="[2001:db8::17]:4711"`},
+			want:       "2001:db8::17",
+		},
+		{
+			name:       "UnixSocket",
+			remoteAddr: "@",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "UntrustedUnixSocket",
+			trusted:    &trustedProxies{networks: networks},
+			remoteAddr: "@",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "<nil>",
+		},
+		{
+			name:       "UnixSocketWithoutHeader",
+			remoteAddr: "@",
+			want:       "<nil>",
+		},
+		{
+			name:       "Obfuscated",
+			remoteAddr: "10.0.0.1:51234",
+			header:     map[string]string{"Forwarded": "for=_hidden"},
+			want:       "10.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			tr := trusted
+			if tt.trusted != nil {
+				tr = *tt.trusted
+			}
+			if got := getClientIP(req, tr); got.String() != tt.want {
+				t.Errorf("getClientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
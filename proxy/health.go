@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/axxelG/loxwebhook/helpers"
+)
+
+// Health holds the results of the background probes reported by the
+// readiness endpoint
+type Health struct {
+	mu           sync.Mutex
+	miniservers  map[string]miniserverHealth
+	certificates map[string]certificateValidity
+	controls     controlsHealth
+}
+
+type miniserverHealth struct {
+	OK      bool      `json:"ok"`
+	Version string    `json:"version,omitempty"` // Firmware version
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked,omitempty"`
+}
+
+type certificateValidity struct {
+	notBefore, notAfter time.Time
+}
+
+type certificateHealth struct {
+	OK       bool      `json:"ok"`
+	NotAfter time.Time `json:"notAfter"`
+}
+
+type controlsHealth struct {
+	OK     bool      `json:"ok"`
+	Loaded time.Time `json:"loaded"` // Last successful load
+	Error  string    `json:"error,omitempty"`
+}
+
+// readiness is the response of the readiness endpoint
+type readiness struct {
+	Ready        bool                         `json:"ready"`
+	Miniservers  map[string]miniserverHealth  `json:"miniservers"`
+	Certificates map[string]certificateHealth `json:"certificates"`
+	Controls     controlsHealth               `json:"controls"`
+}
+
+// NewHealth returns the health of the Miniservers called names. They are
+// not ready until they were probed. The controls were loaded at loaded.
+func NewHealth(names []string, loaded time.Time) *Health {
+	h := &Health{
+		miniservers:  make(map[string]miniserverHealth),
+		certificates: make(map[string]certificateValidity),
+		controls:     controlsHealth{OK: true, Loaded: loaded},
+	}
+	for _, name := range names {
+		h.miniservers[name] = miniserverHealth{Error: "Not probed yet"}
+	}
+	return h
+}
+
+// SetMiniserver records the result of a probe of the Miniserver name
+func (h *Health) SetMiniserver(name, version string, err error, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	mh := miniserverHealth{OK: err == nil, Version: version, Checked: now}
+	if err != nil {
+		// Keep the version of the last successful probe
+		mh.Version = h.miniservers[name].Version
+		mh.Error = err.Error()
+	}
+	h.miniservers[name] = mh
+}
+
+// ObserveCertificate records the validity of a certificate served to clients
+func (h *Health) ObserveCertificate(cert *tls.Certificate) {
+	leaf, err := helpers.CertificateLeaf(cert)
+	if err != nil {
+		return
+	}
+	name := leaf.Subject.CommonName
+	if name == "" && len(leaf.DNSNames) > 0 {
+		name = leaf.DNSNames[0]
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.certificates[name] = certificateValidity{notBefore: leaf.NotBefore, notAfter: leaf.NotAfter}
+}
+
+// SetControls records the result of loading the controls. After an error
+// the last good controls stay active, but loxwebhook is not ready until
+// they load again.
+func (h *Health) SetControls(err error, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.controls.OK = false
+		h.controls.Error = err.Error()
+		return
+	}
+	h.controls = controlsHealth{OK: true, Loaded: now}
+}
+
+func (h *Health) status(now time.Time) readiness {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := readiness{
+		Ready:        h.controls.OK,
+		Miniservers:  make(map[string]miniserverHealth),
+		Certificates: make(map[string]certificateHealth),
+		Controls:     h.controls,
+	}
+	for name, mh := range h.miniservers {
+		r.Miniservers[name] = mh
+		r.Ready = r.Ready && mh.OK
+	}
+	// Certificates that were not served yet are not known and do not
+	// affect the readiness
+	for name, cv := range h.certificates {
+		ok := !now.Before(cv.notBefore) && now.Before(cv.notAfter)
+		r.Certificates[name] = certificateHealth{OK: ok, NotAfter: cv.notAfter}
+		r.Ready = r.Ready && ok
+	}
+	return r
+}
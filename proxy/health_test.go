@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/axxelG/loxwebhook/config"
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+func TestHealth_status(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	cert := func(notAfter time.Time) *tls.Certificate {
+		return &tls.Certificate{Leaf: &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "loxwebhook.example.com"},
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  notAfter,
+		}}
+	}
+	tests := []struct {
+		name      string
+		update    func(h *Health)
+		wantReady bool
+	}{
+		{
+			name:      "NotProbed",
+			update:    func(h *Health) {},
+			wantReady: false,
+		},
+		{
+			name: "Ready",
+			update: func(h *Health) {
+				h.SetMiniserver(config.DefaultMiniserver, "12.0.2.24", nil, now)
+				h.ObserveCertificate(cert(now.Add(time.Hour)))
+			},
+			wantReady: true,
+		},
+		{
+			name: "MiniserverDown",
+			update: func(h *Health) {
+				h.SetMiniserver(config.DefaultMiniserver, "12.0.2.24", nil, now)
+				h.SetMiniserver(config.DefaultMiniserver, "", errors.New("Cannot reach miniserver"), now)
+			},
+			wantReady: false,
+		},
+		{
+			name: "CertificateExpired",
+			update: func(h *Health) {
+				h.SetMiniserver(config.DefaultMiniserver, "12.0.2.24", nil, now)
+				h.ObserveCertificate(cert(now))
+			},
+			wantReady: false,
+		},
+		{
+			name: "ControlsFailed",
+			update: func(h *Health) {
+				h.SetMiniserver(config.DefaultMiniserver, "12.0.2.24", nil, now)
+				h.SetControls(errors.New("Error validating controls"), now)
+			},
+			wantReady: false,
+		},
+		{
+			name: "ControlsReloaded",
+			update: func(h *Health) {
+				h.SetMiniserver(config.DefaultMiniserver, "12.0.2.24", nil, now)
+				h.SetControls(errors.New("Error validating controls"), now)
+				h.SetControls(nil, now)
+			},
+			wantReady: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealth([]string{config.DefaultMiniserver}, now)
+			tt.update(h)
+			if got := h.status(now).Ready; got != tt.wantReady {
+				t.Errorf("status().Ready = %v, want %v: %+v", got, tt.wantReady, h.status(now))
+			}
+		})
+	}
+}
+
+func TestHealth_versionKept(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	h := NewHealth([]string{config.DefaultMiniserver}, now)
+	h.SetMiniserver(config.DefaultMiniserver, "12.0.2.24", nil, now)
+	h.SetMiniserver(config.DefaultMiniserver, "", errors.New("timeout"), now)
+	if got := h.status(now).Miniservers[config.DefaultMiniserver].Version; got != "12.0.2.24" {
+		t.Errorf("Version = %q after a failed probe, want 12.0.2.24", got)
+	}
+}
+
+func TestNewAdminServer_readyz(t *testing.T) {
+	health := NewHealth([]string{config.DefaultMiniserver}, time.Now())
+	handler := NewAdminServer(logging.Discard(), NewLimits(&config.Config{LimiterMaxEntries: 1}), health).Handler
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz status = %d, want 200", rec.Code)
+	}
+	if rec := get("/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz before the first probe status = %d, want 503", rec.Code)
+	}
+	health.SetMiniserver(config.DefaultMiniserver, "12.0.2.24", nil, time.Now())
+	rec := get("/readyz")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /readyz status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var r readiness
+	if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+		t.Fatalf("Cannot decode readiness: %v", err)
+	}
+	if v := r.Miniservers[config.DefaultMiniserver].Version; v != "12.0.2.24" {
+		t.Errorf("readyz Miniserver version = %q, want 12.0.2.24", v)
+	}
+}
@@ -4,21 +4,27 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"log/slog"
 	"net"
 	"net/http"
-	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
-	"golang.org/x/time/rate"
 
 	"github.com/axxelG/loxwebhook/config"
 	"github.com/axxelG/loxwebhook/controls"
 	"github.com/axxelG/loxwebhook/helpers"
+	"github.com/axxelG/loxwebhook/logging"
+	"github.com/axxelG/loxwebhook/metrics"
+	"github.com/axxelG/loxwebhook/miniserver"
 )
 
-var limiter = rate.NewLimiter(1, 3)
+// maxFormBodySize limits the size of form bodies that carry an auth key
+const maxFormBodySize = 64 * 1024
 
 type authKeyError struct {
 	err string
@@ -28,8 +34,57 @@ func (e *authKeyError) Error() string {
 	return e.err
 }
 
-type commandError struct {
-	err string
+// unknownAuthKeyError is returned for keys that are not configured. Too many
+// of them get the client IP banned. name is only set for signed requests,
+// the values of plain keys must not end up in the logs.
+type unknownAuthKeyError struct {
+	name string
+}
+
+func (e *unknownAuthKeyError) Error() string {
+	if e.name == "" {
+		return "Unknown authKey"
+	}
+	return "Unknown authKey: " + e.name
+}
+
+// deniedError is returned if a request must not pass although the auth key
+// is known. reason is the label of the auth failure metric.
+type deniedError struct {
+	reason string
+	err    string
+}
+
+func (e *deniedError) Error() string {
+	return e.err
+}
+
+func newDeniedError(reason, format string, a ...interface{}) *deniedError {
+	return &deniedError{reason: reason, err: fmt.Sprintf(format, a...)}
+}
+
+// authFailureReason returns the reason of an authentication error for the
+// auth failure metric
+func authFailureReason(err error) string {
+	switch e := err.(type) {
+	case *unknownAuthKeyError:
+		return "unknown_key"
+	case *authKeyError:
+		return "missing_key"
+	case *deniedError:
+		return e.reason
+	case *controls.AuthKeyDisabledError:
+		return "disabled"
+	case *controls.AuthKeyNotYetValidError:
+		return "not_yet_valid"
+	case *controls.AuthKeyExpiredError:
+		return "expired"
+	case *controls.AuthKeyOutsideWindowError:
+		return "outside_window"
+	case *controls.AuthKeyUsesExceededError:
+		return "uses_exceeded"
+	}
+	return "other"
 }
 
 type controlError struct {
@@ -40,31 +95,29 @@ func (e *controlError) Error() string {
 	return e.err
 }
 
-func sendErrorPage(logger *log.Logger, w http.ResponseWriter, err error, responseCode int) {
-	logger.Println(err)
+// sendErrorPage logs err with the fields of req and sends it to the client.
+// Errors of the client are logged as warnings.
+func sendErrorPage(logger *slog.Logger, w http.ResponseWriter, req *http.Request, err error, responseCode int) {
+	level := slog.LevelWarn
+	if responseCode >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	requestLogger(logger, req).Log(req.Context(), level, "Request failed", "status", responseCode, logging.Err(err))
 	displayText := fmt.Sprintf("%d %s", responseCode, http.StatusText(responseCode))
 	w.WriteHeader(responseCode)
 	fmt.Fprintln(w, displayText)
 	fmt.Fprintf(w, "%s", err)
 }
 
-func sendRequest(cfg *config.Config, path string, logger *log.Logger) (*http.Response, error) {
-	url := cfg.MiniserverURL
-	url.Path = path
-	client := http.Client{
-		Timeout: cfg.MiniserverTimeout,
-	}
-	req, err := http.NewRequest("POST", url.String(), nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "Error preparing request")
-	}
-	req.SetBasicAuth(cfg.MiniserverUser, cfg.MiniserverPassword)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, errors.Wrap(err, "Error sending request to Miniserver")
+// getErrorCode returns the http status code matching an error of a Miniserver
+// Transport
+func getErrorCode(err error) int {
+	if e, ok := errors.Cause(err).(interface{ Timeout() bool }); ok {
+		if e.Timeout() {
+			return http.StatusGatewayTimeout
+		}
 	}
-	logger.Printf("%s:%s %s", req.RemoteAddr, req.Method, req.URL.Path)
-	return resp, nil
+	return http.StatusBadGateway
 }
 
 func forwardResponse(resp *http.Response, w http.ResponseWriter) {
@@ -74,48 +127,248 @@ func forwardResponse(resp *http.Response, w http.ResponseWriter) {
 	resp.Body.Close()
 }
 
-func getAuthKeyFromRequest(req *http.Request) (string, error) {
-	authKeys, ok := req.URL.Query()["t"]
-	if !ok {
-		return "", &authKeyError{
-			err: "Request without access authKey",
+// getAuthKeyFromRequest returns the auth key of a request and the transport
+// it was sent with. header is the name of the custom auth key header.
+func getAuthKeyFromRequest(req *http.Request, header string) (authKey, transport string, err error) {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			return strings.TrimSpace(auth[7:]), "bearer", nil
+		}
+		if _, password, ok := req.BasicAuth(); ok {
+			return password, "basic", nil
 		}
 	}
-	return authKeys[0], nil
+	if k := req.Header.Get(header); k != "" {
+		return k, "header", nil
+	}
+	if req.Method == http.MethodPost && strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if req.PostForm == nil {
+			req.Body = http.MaxBytesReader(nil, req.Body, maxFormBodySize)
+			if err := req.ParseForm(); err != nil {
+				return "", "", &authKeyError{
+					err: fmt.Sprintf("Cannot read request body: %s", err),
+				}
+			}
+		}
+		if k := req.PostForm.Get("k"); k != "" {
+			return k, "form", nil
+		}
+	}
+	if k, ok := req.URL.Query()["k"]; ok {
+		return k[0], "query", nil
+	}
+	return "", "", &authKeyError{
+		err: "Request without access authKey",
+	}
 }
 
-func authorize(control controls.Control, authKeys map[string]string, reqAuthKey, reqCommand string) error {
-	reqAuthKeyKey, ok := helpers.GetMapStringKeyFromStringValue(reqAuthKey, authKeys)
+// getCertAuthKey returns the name of the auth key mapped to the verified
+// client certificate of req. Keys of ctl are preferred.
+func getCertAuthKey(req *http.Request, keyIndex *controls.AuthKeyIndex, ctl controls.Control) (string, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	return keyIndex.LookupCert(req.TLS.VerifiedChains[0][0], ctl.AuthKeys)
+}
+
+// authorize checks the plain reqAuthKey and returns the name of the key
+func authorize(control controls.Control, keyIndex *controls.AuthKeyIndex, uses *controls.UseCounter, reqAuthKey, reqTransport, reqCommand string, clientIP net.IP, now time.Time) (string, error) {
+	reqAuthKeyKey, ok := keyIndex.Lookup(reqAuthKey)
 	if !ok {
-		return fmt.Errorf("Unknown authKey: %s", reqAuthKey)
+		return "", &unknownAuthKeyError{}
+	}
+	k, _ := keyIndex.Get(reqAuthKeyKey)
+	if k.GetMode() != "plain" {
+		return "", newDeniedError("transport", "AuthKey %s only accepts signed requests", reqAuthKeyKey)
 	}
-	if !helpers.IsStringInSlice(reqAuthKeyKey, control.AuthKeys) {
-		return fmt.Errorf("AuthKey %s is not valid for this control", reqAuthKeyKey)
+	if !k.AllowsTransport(reqTransport) {
+		return "", newDeniedError("transport", "AuthKey %s must not be sent via %s", reqAuthKeyKey, reqTransport)
 	}
-	if !helpers.IsStringInSlice(reqCommand, control.Allowed) {
-		return fmt.Errorf("Command %s is not allowed on this control", reqCommand)
+	return reqAuthKeyKey, authorizeKey(control, reqAuthKeyKey, k, uses, reqCommand, clientIP, now)
+}
+
+// authorizeKey checks if the authenticated key keyName may send reqCommand to control
+func authorizeKey(control controls.Control, keyName string, key controls.AuthKey, uses *controls.UseCounter, reqCommand string, clientIP net.IP, now time.Time) error {
+	if err := key.CheckLifecycle(keyName, now); err != nil {
+		return err
+	}
+	if !key.AllowsIP(clientIP) {
+		return newDeniedError("network", "AuthKey %s is not allowed from %s", keyName, clientIP)
+	}
+	if !control.AllowsIP(clientIP) {
+		return newDeniedError("network", "Control does not accept requests from %s", clientIP)
+	}
+	if key.MaxUses > 0 {
+		if err := uses.Check(keyName, key.MaxUses); err != nil {
+			return err
+		}
+	}
+	if !helpers.IsStringInSlice(keyName, control.AuthKeys) {
+		return newDeniedError("control", "AuthKey %s is not valid for this control", keyName)
+	}
+	category, ok := controls.GetCategory(control.Category)
+	if !ok {
+		return fmt.Errorf("Unknown category %s", control.Category)
+	}
+	if !category.IsAllowed(&control, reqCommand) {
+		return newDeniedError("command", "Command %s is not allowed on this control", reqCommand)
 	}
 	return nil
 }
 
-func getControlID(controls map[string]controls.Control, control string) (int, error) {
-	ctl, ok := controls[control]
+// getCommandLabel returns the label of command for the request metric.
+// Only allowed commands are used, values of controls without a list of
+// allowed commands are reported as value.
+func getCommandLabel(ctl controls.Control, command string) string {
+	if helpers.IsStringInSlice(command, ctl.Allowed) {
+		return command
+	}
+	if len(ctl.Allowed) == 0 {
+		return "value"
+	}
+	return "other"
+}
+
+// statusRecorder remembers the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// matchHost returns a matcher for requests to hostname. Unlike the Host
+// matcher of mux it ignores the case of the Host header.
+func matchHost(hostname string) mux.MatcherFunc {
+	return func(req *http.Request, _ *mux.RouteMatch) bool {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return strings.ToLower(host) == hostname
+	}
+}
+
+// getMiniserverName returns the name of the Miniserver the control belongs to
+func getMiniserverName(ctl controls.Control) string {
+	if ctl.Miniserver == "" {
+		return config.DefaultMiniserver
+	}
+	return ctl.Miniserver
+}
+
+// getMiniserver returns the Transport of the Miniserver the control belongs to
+func getMiniserver(miniservers map[string]miniserver.Transport, ctl controls.Control) (miniserver.Transport, error) {
+	name := getMiniserverName(ctl)
+	ms, ok := miniservers[name]
 	if !ok {
-		return 0, fmt.Errorf("Unknown control %s", control)
+		return nil, fmt.Errorf("Unknown Miniserver %s", name)
 	}
-	return ctl.ID, nil
+	return ms, nil
 }
 
-// StartServer starts the proxy server
-func StartServer(
-	listener net.Listener,
+// CheckControls returns an error if a control uses an unknown Miniserver or
+// a public host serves an unknown control
+func CheckControls(cfg *config.Config, miniservers map[string]miniserver.Transport, snap *controls.Snapshot) error {
+	for name, ctl := range snap.Controls {
+		if _, err := getMiniserver(miniservers, ctl); err != nil {
+			return errors.Wrap(err, "Control "+name)
+		}
+	}
+	for _, host := range cfg.GetPublicHosts() {
+		for _, name := range host.Controls {
+			if _, ok := snap.Controls[name]; !ok {
+				return fmt.Errorf("Public host %s: Unknown control %s", host.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// NewServer returns the proxy server. Every request uses the snapshot of
+// store that is active when the request arrives.
+func NewServer(
 	tlsConfig *tls.Config,
 	cfg *config.Config,
-	loggerErr *log.Logger,
-	loggerAcc *log.Logger,
-	authKeys map[string]string,
-	controls map[string]controls.Control,
-) error {
+	miniservers map[string]miniserver.Transport,
+	loggerErr *slog.Logger,
+	loggerAcc *slog.Logger,
+	store *controls.Store,
+	uses *controls.UseCounter,
+	limits *Limits,
+	m *metrics.Metrics,
+) (*http.Server, error) {
+	proxyNetworks, unixSocket, err := cfg.GetTrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	trusted := trustedProxies{networks: proxyNetworks, unixSocket: unixSocket}
+	clientCAs, err := cfg.ClientCAPool()
+	if err != nil {
+		return nil, err
+	}
+	if clientCAs != nil && tlsConfig != nil {
+		// Certificates are optional, clients without one use auth keys
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	nonces := newNonceCache(maxNonces)
+	if err := CheckControls(cfg, miniservers, store.Get()); err != nil {
+		return nil, err
+	}
+	hosts := cfg.GetPublicHosts()
+
+	// sendMiniserver sends path to the Miniserver msName and records the
+	// duration and errors
+	sendMiniserver := func(req *http.Request, ms miniserver.Transport, msName, path string) (*http.Response, error) {
+		start := time.Now()
+		resp, err := ms.Send(path)
+		duration := time.Since(start)
+		m.MiniserverDuration.Observe(duration.Seconds(), msName)
+		rl := getRequestLog(req)
+		rl.miniserver, rl.upstreamDuration = msName, duration
+		if resp != nil {
+			rl.upstreamStatus = resp.StatusCode
+		}
+		requestLogger(loggerAcc, req).Debug("Miniserver request", "path", path)
+		if err != nil {
+			if getErrorCode(err) == http.StatusGatewayTimeout {
+				m.MiniserverErrors.Inc(msName, "timeout")
+			} else {
+				m.MiniserverErrors.Inc(msName, "bad_gateway")
+			}
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			m.MiniserverErrors.Inc(msName, "non_200")
+		}
+		return resp, nil
+	}
+
+	sendAndForward := func(w http.ResponseWriter, req *http.Request, ms miniserver.Transport, msName, path string) {
+		resp, err := sendMiniserver(req, ms, msName, path)
+		if err != nil {
+			sendErrorPage(loggerErr, w, req, err, getErrorCode(err))
+			return
+		}
+		forwardResponse(resp, w)
+	}
+
+	// authFailed rejects a request that failed authentication. Clients
+	// that guess keys are banned.
+	authFailed := func(w http.ResponseWriter, req *http.Request, err error, now time.Time) {
+		if _, ok := err.(*unknownAuthKeyError); ok {
+			ip := getClientIP(req, trusted).String()
+			if limits.bans.fail(ip, now) {
+				requestLogger(loggerErr, req).Warn("Banned client after requests with unknown authKeys", "client", ip, "failures", cfg.BanThreshold)
+			}
+		}
+		m.AuthFailures.Inc(authFailureReason(err))
+		sendErrorPage(loggerErr, w, req, err, http.StatusUnauthorized)
+	}
 
 	notFoundHandler := func(w http.ResponseWriter, req *http.Request) {
 		http.NotFound(w, req)
@@ -123,9 +376,25 @@ func StartServer(
 
 	Limiter := func(nextHandler http.HandlerFunc) http.HandlerFunc {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if limiter.Allow() == false {
-				err := errors.New("Request rate limit reached")
-				sendErrorPage(loggerErr, w, err, http.StatusTooManyRequests)
+			now := time.Now()
+			clientIP := getClientIP(r, trusted)
+			if clientIP == nil {
+				// Clients without IP would share one entry of the limiter and the ban list
+				err := errors.New("Cannot determine the client IP, the request came over a Unix socket without the headers of a trusted proxy")
+				sendErrorPage(loggerErr, w, r, err, http.StatusBadRequest)
+				return
+			}
+			ip := clientIP.String()
+			if until, banned := limits.bans.bannedUntil(ip, now); banned {
+				m.RateLimited.Inc("ban")
+				err := fmt.Errorf("Client %s is banned until %s", ip, until.Format(time.RFC3339))
+				sendErrorPage(loggerErr, w, r, err, http.StatusForbidden)
+				return
+			}
+			if !limits.allowIP(ip, now) {
+				m.RateLimited.Inc("ip")
+				err := fmt.Errorf("Request rate limit reached for client %s", ip)
+				sendErrorPage(loggerErr, w, r, err, http.StatusTooManyRequests)
 				return
 			}
 
@@ -133,75 +402,166 @@ func StartServer(
 		})
 	}
 
-	LoggingHandler := func(nextHandler http.HandlerFunc) http.HandlerFunc {
-		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-			loggerAcc.Printf("%s:%s %s", req.RemoteAddr, req.Method, req.URL.Path)
-			nextHandler.ServeHTTP(w, req)
-		})
-	}
-
-	DigitalVirtualInputHandler := func(w http.ResponseWriter, req *http.Request) {
-		controlName, command, authKey := parseRequestDigitalVirtualInput(req)
-		ctl, ok := controls[controlName]
-		if !ok {
-			err := fmt.Errorf("Unknown control %s", controlName)
-			sendErrorPage(loggerErr, w, err, http.StatusNotFound)
-			return
-		}
-		err := authorize(ctl, authKeys, authKey, command)
+	sendAndConvert := func(w http.ResponseWriter, req *http.Request, ms miniserver.Transport, msName, path, controlName string, converter controls.ResponseConverter) {
+		resp, err := sendMiniserver(req, ms, msName, path)
 		if err != nil {
-			sendErrorPage(loggerErr, w, err, http.StatusUnauthorized)
+			sendErrorPage(loggerErr, w, req, err, getErrorCode(err))
 			return
 		}
-		vi, err := newDigitalVirtualInput(ctl.ID, command, authKey)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			sendErrorPage(loggerErr, w, err, http.StatusNotFound)
+			sendErrorPage(loggerErr, w, req, errors.Wrap(err, "Error reading Miniserver response"), http.StatusBadGateway)
 			return
 		}
-		if err != nil {
-			sendErrorPage(loggerErr, w, err, http.StatusUnauthorized)
+		if resp.StatusCode != http.StatusOK {
+			err := fmt.Errorf("Miniserver responded with status code %d", resp.StatusCode)
+			sendErrorPage(loggerErr, w, req, err, http.StatusBadGateway)
 			return
 		}
-		if _, ok := req.URL.Query()["simulate"]; ok {
-			fmt.Fprintf(w, "SIMULATE\n")
-			fmt.Fprintf(w, "Virtual Input: %d\n", vi.ID)
-			fmt.Fprintf(w, "Command:       %s\n", vi.Command)
-			fmt.Fprintf(w, "AuthKey:         %s\n", vi.AuthKey)
-			fmt.Fprintf(w, "Path:          %s\n", vi.GetPath())
+		contentType, content, err := converter.ConvertResponse(controlName, body)
+		if err != nil {
+			sendErrorPage(loggerErr, w, req, err, http.StatusBadGateway)
 			return
 		}
-		resp, err := sendRequest(cfg, vi.GetPath(), loggerAcc)
-		if err != nil {
-			code := http.StatusBadGateway
-			if e, ok := err.(*url.Error); ok {
-				if e.Timeout() {
-					code = http.StatusGatewayTimeout
+		w.Header().Set("Content-Type", contentType)
+		w.Write(content)
+	}
+
+	ControlHandler := func(host *config.PublicHost, categoryName string, category controls.Category) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			now := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+			w = rec
+			controlLabel, commandLabel := "unknown", "unknown"
+			defer func() {
+				m.Requests.Inc(controlLabel, categoryName, commandLabel, strconv.Itoa(rec.code))
+			}()
+			clientIP := getClientIP(req, trusted)
+			snap := store.Get()
+			authKeys, ctls, keyIndex := snap.AuthKeys, snap.Controls, snap.KeyIndex
+			// Signed requests are verified before the body is parsed
+			signedKey, err := verifySignature(req, authKeys, nonces, now)
+			if err != nil {
+				if _, ok := err.(*unknownAuthKeyError); !ok {
+					err = &deniedError{reason: "signature", err: err.Error()}
 				}
+				authFailed(w, req, err, now)
+				return
 			}
-			sendErrorPage(loggerErr, w, err, code)
-			return
+			controlName, command, err := category.ParseRequest(req)
+			rl := getRequestLog(req)
+			rl.control, rl.command = controlName, command
+			if err != nil {
+				sendErrorPage(loggerErr, w, req, err, http.StatusBadRequest)
+				return
+			}
+			ctl, ok := ctls[controlName]
+			if !ok || ctl.Category != categoryName || !host.AllowsControl(controlName) {
+				err := fmt.Errorf("Unknown control %s", controlName)
+				sendErrorPage(loggerErr, w, req, err, http.StatusNotFound)
+				return
+			}
+			// Names of unknown controls are not used as labels to keep
+			// the number of series bounded
+			controlLabel, commandLabel = controlName, getCommandLabel(ctl, command)
+			if !limits.allowControl(controlName, ctl, now) {
+				m.RateLimited.Inc("control")
+				err := fmt.Errorf("Request rate limit reached for control %s", controlName)
+				sendErrorPage(loggerErr, w, req, err, http.StatusTooManyRequests)
+				return
+			}
+			var keyName, authKey, transport string
+			if signedKey != "" {
+				keyName, authKey, transport = signedKey, signedKey, "signature"
+				err = authorizeKey(ctl, keyName, authKeys[keyName], uses, command, clientIP, now)
+			} else if certKey, ok := getCertAuthKey(req, keyIndex, ctl); ok {
+				keyName, authKey, transport = certKey, certKey, "certificate"
+				err = authorizeKey(ctl, keyName, authKeys[keyName], uses, command, clientIP, now)
+			} else {
+				authKey, transport, err = getAuthKeyFromRequest(req, cfg.AuthKeyHeader)
+				if err == nil {
+					keyName, err = authorize(ctl, keyIndex, uses, authKey, transport, command, clientIP, now)
+				}
+			}
+			if err != nil {
+				authFailed(w, req, err, now)
+				return
+			}
+			rl.authKey = keyName
+			if !limits.allowKey(keyName, authKeys[keyName], now) {
+				m.RateLimited.Inc("key")
+				err := fmt.Errorf("Request rate limit reached for authKey %s", keyName)
+				sendErrorPage(loggerErr, w, req, err, http.StatusTooManyRequests)
+				return
+			}
+			path, err := category.GetPath(&ctl, command)
+			if err != nil {
+				sendErrorPage(loggerErr, w, req, err, http.StatusBadRequest)
+				return
+			}
+			if _, ok := req.URL.Query()["simulate"]; ok {
+				fmt.Fprintf(w, "SIMULATE\n")
+				fmt.Fprintf(w, "Control:       %s\n", controlName)
+				fmt.Fprintf(w, "Miniserver:    %s\n", getMiniserverName(ctl))
+				fmt.Fprintf(w, "Address:       %s\n", ctl.GetAddress())
+				fmt.Fprintf(w, "Command:       %s\n", command)
+				fmt.Fprintf(w, "AuthKey:         %s\n", authKey)
+				fmt.Fprintf(w, "Transport:     %s\n", transport)
+				fmt.Fprintf(w, "Path:          %s\n", path)
+				return
+			}
+			if k := authKeys[keyName]; k.MaxUses > 0 {
+				if err := uses.Use(keyName, k.MaxUses); err != nil {
+					if _, ok := err.(*controls.AuthKeyUsesExceededError); ok {
+						m.AuthFailures.Inc(authFailureReason(err))
+						sendErrorPage(loggerErr, w, req, err, http.StatusUnauthorized)
+					} else {
+						sendErrorPage(loggerErr, w, req, err, http.StatusInternalServerError)
+					}
+					return
+				}
+			}
+			ms, err := getMiniserver(miniservers, ctl)
+			if err != nil {
+				sendErrorPage(loggerErr, w, req, err, http.StatusInternalServerError)
+				return
+			}
+			if converter, ok := category.(controls.ResponseConverter); ok {
+				sendAndConvert(w, req, ms, getMiniserverName(ctl), path, controlName, converter)
+				return
+			}
+			sendAndForward(w, req, ms, getMiniserverName(ctl), path)
 		}
-		forwardResponse(resp, w)
 	}
 
 	router := mux.NewRouter()
 	router.HandleFunc("/", notFoundHandler)
-	for _, control := range controls {
-		switch control.Category {
-		case "dvi":
-			router.HandleFunc("/dvi/{control}/{command}", LoggingHandler(Limiter(DigitalVirtualInputHandler)))
+	for _, host := range hosts {
+		route := router.NewRoute()
+		// Hosts are only matched if there is a choice. A single host is
+		// served for every Host header like before.
+		if len(hosts) > 1 {
+			route = route.MatcherFunc(matchHost(host.Hostname))
+		}
+		if host.PathPrefix != "" {
+			route = route.PathPrefix(host.PathPrefix)
+		}
+		hostRouter := route.Subrouter()
+		// Routes of all categories are registered, reloaded controls may
+		// use categories that are not in use yet
+		for _, name := range controls.GetCategoryNames() {
+			category, _ := controls.GetCategory(name)
+			for _, route := range category.Routes() {
+				hostRouter.HandleFunc("/"+name+route, logRequests(loggerAcc, trusted, Limiter(ControlHandler(host, name, category))))
+			}
 		}
 	}
 	s := &http.Server{
 		TLSConfig:   tlsConfig,
 		Handler:     router,
 		ReadTimeout: cfg.MiniserverTimeout,
-		ErrorLog:    loggerErr,
+		ErrorLog:    logging.NewStdLogger(loggerErr, slog.LevelError),
 	}
-	err := s.Serve(listener)
-	if err != nil {
-		return errors.Wrap(err, "Error starting server")
-	}
-
-	return nil
+	return s, nil
 }
@@ -1,17 +1,41 @@
 package proxy
 
 import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/axxelG/loxwebhook/controls"
+	"github.com/axxelG/loxwebhook/miniserver"
 )
 
 func Test_authorize(t *testing.T) {
-	authKeys := map[string]string{
-		"test1": "f7932d8a-b37f-46dc-84ee-276c545aec48",
-		"test2": "88f3cc74-b741-404e-b6a3-136d76796de8",
-		"test3": "d7d47ae7-44d6-4b4b-b65d-06e7f5bf108e",
+	authKeys := map[string]controls.AuthKey{
+		"test1":  {Key: "f7932d8a-b37f-46dc-84ee-276c545aec48"},
+		"test2":  {Key: "88f3cc74-b741-404e-b6a3-136d76796de8"},
+		"test3":  {Key: "d7d47ae7-44d6-4b4b-b65d-06e7f5bf108e"},
+		"test4":  {Key: "0123456789abcdef0123456789abcdef", Mode: "hmac"},
+		"test5":  {Key: "5c6d1e0b-8f57-4a0e-9d7c-2b1f3c4e5a6d", Transports: []string{"header", "bearer"}},
+		"test6":  {Key: "1a2b3c4d$sha256$46fcd220bd1a9f064cc6e2302279ecec9ca427617044b5e0f3db1d1a844b3ecc"},
+		"test7":  {Key: "0e1d2c3b-4a59-6877-8695-a4b3c2d1e0f9", Disabled: true},
+		"test8":  {Key: "9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0", ExpiresAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"test9":  {Key: "a1b2c3d4-e5f6-4789-8abc-def012345678", MaxUses: 1},
+		"test10": {Key: "b2c3d4e5-f6a7-4890-9bcd-ef0123456789", AllowedNetworks: []string{"192.168.0.0/16"}},
 	}
+	// Validate parses the networks like Read does
+	netKey := authKeys["test10"]
+	if err := netKey.Validate("test10"); err != nil {
+		t.Fatal(err)
+	}
+	authKeys["test10"] = netKey
+	keyIndex := controls.NewAuthKeyIndex(authKeys)
+	uses, _ := controls.NewUseCounter("")
+	uses.Use("test9", 1)
+	now := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
 	ctl := controls.Control{
 		Category: "dvi",
 		ID:       1,
@@ -24,11 +48,39 @@ func Test_authorize(t *testing.T) {
 			"test2",
 		},
 	}
+	ctlAvi := controls.Control{
+		Category: "avi",
+		ID:       2,
+		Min:      0,
+		Max:      10,
+		AuthKeys: []string{
+			"test1",
+			"test4",
+			"test5",
+			"test6",
+			"test7",
+			"test8",
+			"test9",
+			"test10",
+		},
+	}
+	ctlLan := controls.Control{
+		Category:        "dvi",
+		ID:              3,
+		Allowed:         []string{"pulse"},
+		AuthKeys:        []string{"test1"},
+		AllowedNetworks: []string{"10.0.0.0/8", "2001:db8::/32"},
+	}
+	if err := ctlLan.Validate(); err != nil {
+		t.Fatal(err)
+	}
 	type args struct {
-		control    controls.Control
-		authKeys   map[string]string
-		reqAuthKey string
-		reqCommand string
+		control      controls.Control
+		keyIndex     *controls.AuthKeyIndex
+		reqAuthKey   string
+		reqTransport string
+		reqCommand   string
+		clientIP     net.IP
 	}
 	tests := []struct {
 		name    string
@@ -38,49 +90,416 @@ func Test_authorize(t *testing.T) {
 		{
 			name: "ValidAuth",
 			args: args{
-				control:    ctl,
-				authKeys:   authKeys,
-				reqAuthKey: "88f3cc74-b741-404e-b6a3-136d76796de8",
-				reqCommand: "pulse",
+				control:      ctl,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "88f3cc74-b741-404e-b6a3-136d76796de8",
+				reqTransport: "query",
+				reqCommand:   "pulse",
 			},
 			wantErr: false,
 		},
 		{
 			name: "InvalidCommand",
 			args: args{
-				control:    ctl,
-				authKeys:   authKeys,
-				reqAuthKey: "88f3cc74-b741-404e-b6a3-136d76796de8",
-				reqCommand: "on",
+				control:      ctl,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "88f3cc74-b741-404e-b6a3-136d76796de8",
+				reqTransport: "query",
+				reqCommand:   "on",
 			},
 			wantErr: true,
 		},
 		{
 			name: "InvalidExistingAuthKey",
 			args: args{
-				control:    ctl,
-				authKeys:   authKeys,
-				reqAuthKey: "d7d47ae7-44d6-4b4b-b65d-06e7f5bf108e",
-				reqCommand: "pulse",
+				control:      ctl,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "d7d47ae7-44d6-4b4b-b65d-06e7f5bf108e",
+				reqTransport: "query",
+				reqCommand:   "pulse",
 			},
 			wantErr: true,
 		},
 		{
 			name: "InvalidNonExistingAuthKey",
 			args: args{
-				control:    ctl,
-				authKeys:   authKeys,
-				reqAuthKey: "8c9564a1-6af7-4ed0-8656-add107e882a6",
-				reqCommand: "pulse",
+				control:      ctl,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "8c9564a1-6af7-4ed0-8656-add107e882a6",
+				reqTransport: "query",
+				reqCommand:   "pulse",
+			},
+			wantErr: true,
+		},
+		{
+			name: "ValidAuthAnalogValue",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "f7932d8a-b37f-46dc-84ee-276c545aec48",
+				reqTransport: "query",
+				reqCommand:   "5",
+			},
+			wantErr: false,
+		},
+		{
+			name: "SignedKeyWithoutSignature",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "0123456789abcdef0123456789abcdef",
+				reqTransport: "query",
+				reqCommand:   "5",
+			},
+			wantErr: true,
+		},
+		{
+			name: "HashedKey",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "1a2b3c4d.hashedSecret",
+				reqTransport: "query",
+				reqCommand:   "5",
+			},
+			wantErr: false,
+		},
+		{
+			name: "WrongHashedKey",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "1a2b3c4d.wrongSecret",
+				reqTransport: "query",
+				reqCommand:   "5",
+			},
+			wantErr: true,
+		},
+		{
+			name: "DisabledKey",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "0e1d2c3b-4a59-6877-8695-a4b3c2d1e0f9",
+				reqTransport: "query",
+				reqCommand:   "5",
+			},
+			wantErr: true,
+		},
+		{
+			name: "ExpiredKey",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0",
+				reqTransport: "query",
+				reqCommand:   "5",
+			},
+			wantErr: true,
+		},
+		{
+			name: "ExhaustedKey",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "a1b2c3d4-e5f6-4789-8abc-def012345678",
+				reqTransport: "query",
+				reqCommand:   "5",
+			},
+			wantErr: true,
+		},
+		{
+			name: "AllowedTransport",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "5c6d1e0b-8f57-4a0e-9d7c-2b1f3c4e5a6d",
+				reqTransport: "bearer",
+				reqCommand:   "5",
+			},
+			wantErr: false,
+		},
+		{
+			name: "ForbiddenTransport",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "5c6d1e0b-8f57-4a0e-9d7c-2b1f3c4e5a6d",
+				reqTransport: "query",
+				reqCommand:   "5",
+			},
+			wantErr: true,
+		},
+		{
+			name: "KeyAllowedNetwork",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "b2c3d4e5-f6a7-4890-9bcd-ef0123456789",
+				reqTransport: "query",
+				reqCommand:   "5",
+				clientIP:     net.ParseIP("192.168.1.20"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "KeyForbiddenNetwork",
+			args: args{
+				control:      ctlAvi,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "b2c3d4e5-f6a7-4890-9bcd-ef0123456789",
+				reqTransport: "query",
+				reqCommand:   "5",
+				clientIP:     net.ParseIP("203.0.113.7"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "ControlAllowedNetwork",
+			args: args{
+				control:      ctlLan,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "f7932d8a-b37f-46dc-84ee-276c545aec48",
+				reqTransport: "query",
+				reqCommand:   "pulse",
+				clientIP:     net.ParseIP("2001:db8::1"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "ControlForbiddenNetwork",
+			args: args{
+				control:      ctlLan,
+				keyIndex:     keyIndex,
+				reqAuthKey:   "f7932d8a-b37f-46dc-84ee-276c545aec48",
+				reqTransport: "query",
+				reqCommand:   "pulse",
+				clientIP:     net.ParseIP("192.168.1.20"),
 			},
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := authorize(tt.args.control, tt.args.authKeys, tt.args.reqAuthKey, tt.args.reqCommand); (err != nil) != tt.wantErr {
+			if _, err := authorize(tt.args.control, tt.args.keyIndex, uses, tt.args.reqAuthKey, tt.args.reqTransport, tt.args.reqCommand, tt.args.clientIP, now); (err != nil) != tt.wantErr {
 				t.Errorf("authorize() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func Test_getAuthKeyFromRequest(t *testing.T) {
+	key := "f7932d8a-b37f-46dc-84ee-276c545aec48"
+	tests := []struct {
+		name          string
+		req           func() *http.Request
+		wantKey       string
+		wantTransport string
+		wantErr       bool
+	}{
+		{
+			name: "Query",
+			req: func() *http.Request {
+				return httptest.NewRequest("GET", "/dvi/test/on?k="+key, nil)
+			},
+			wantKey:       key,
+			wantTransport: "query",
+		},
+		{
+			name: "Form",
+			req: func() *http.Request {
+				req := httptest.NewRequest("POST", "/dvi/test/on", strings.NewReader("k="+key))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			wantKey:       key,
+			wantTransport: "form",
+		},
+		{
+			name: "Header",
+			req: func() *http.Request {
+				req := httptest.NewRequest("GET", "/dvi/test/on", nil)
+				req.Header.Set("X-Api-Key", key)
+				return req
+			},
+			wantKey:       key,
+			wantTransport: "header",
+		},
+		{
+			name: "Bearer",
+			req: func() *http.Request {
+				req := httptest.NewRequest("GET", "/dvi/test/on", nil)
+				req.Header.Set("Authorization", "bearer "+key)
+				return req
+			},
+			wantKey:       key,
+			wantTransport: "bearer",
+		},
+		{
+			name: "Basic",
+			req: func() *http.Request {
+				req := httptest.NewRequest("GET", "/dvi/test/on", nil)
+				req.SetBasicAuth("loxone", key)
+				return req
+			},
+			wantKey:       key,
+			wantTransport: "basic",
+		},
+		{
+			name: "HeaderBeforeQuery",
+			req: func() *http.Request {
+				req := httptest.NewRequest("GET", "/dvi/test/on?k=other", nil)
+				req.Header.Set("X-Api-Key", key)
+				return req
+			},
+			wantKey:       key,
+			wantTransport: "header",
+		},
+		{
+			name: "NoKey",
+			req: func() *http.Request {
+				return httptest.NewRequest("GET", "/dvi/test/on", nil)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotKey, gotTransport, err := getAuthKeyFromRequest(tt.req(), "X-Api-Key")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getAuthKeyFromRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotKey != tt.wantKey || gotTransport != tt.wantTransport {
+				t.Errorf("getAuthKeyFromRequest() = %s, %s, want %s, %s", gotKey, gotTransport, tt.wantKey, tt.wantTransport)
+			}
+		})
+	}
+}
+
+// fakeTransport records the name of the Miniserver it stands for
+type fakeTransport struct {
+	name string
+}
+
+func (f *fakeTransport) Send(path string) (*http.Response, error) {
+	return nil, nil
+}
+
+func (f *fakeTransport) Status() error {
+	return nil
+}
+
+func (f *fakeTransport) Close() error {
+	return nil
+}
+
+func Test_getMiniserver(t *testing.T) {
+	miniservers := map[string]miniserver.Transport{
+		"default": &fakeTransport{name: "default"},
+		"garage":  &fakeTransport{name: "garage"},
+	}
+	tests := []struct {
+		name       string
+		miniserver string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "Default",
+			miniserver: "",
+			want:       "default",
+		},
+		{
+			name:       "Named",
+			miniserver: "garage",
+			want:       "garage",
+		},
+		{
+			name:       "Unknown",
+			miniserver: "shed",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctl := controls.Control{
+				Category:   "dvi",
+				ID:         1,
+				Miniserver: tt.miniserver,
+			}
+			got, err := getMiniserver(miniservers, ctl)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getMiniserver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.(*fakeTransport).name != tt.want {
+				t.Errorf("getMiniserver() = %s, want %s", got.(*fakeTransport).name, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "UnknownKey", err: &unknownAuthKeyError{}, want: "unknown_key"},
+		{name: "MissingKey", err: &authKeyError{err: "Request without access authKey"}, want: "missing_key"},
+		{name: "Denied", err: newDeniedError("network", "Control does not accept requests from %s", "192.0.2.1"), want: "network"},
+		{name: "Disabled", err: (&controls.AuthKey{Disabled: true}).CheckLifecycle("test", time.Now()), want: "disabled"},
+		{name: "Other", err: errors.New("Something else"), want: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authFailureReason(tt.err); got != tt.want {
+				t.Errorf("authFailureReason() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getCommandLabel(t *testing.T) {
+	dvi := controls.Control{Category: "dvi", Allowed: []string{"on", "off"}}
+	vti := controls.Control{Category: "vti"}
+	tests := []struct {
+		name    string
+		ctl     controls.Control
+		command string
+		want    string
+	}{
+		{name: "Allowed", ctl: dvi, command: "on", want: "on"},
+		{name: "NotAllowed", ctl: dvi, command: "pulse", want: "other"},
+		{name: "Value", ctl: vti, command: "Hello World", want: "value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getCommandLabel(tt.ctl, tt.command); got != tt.want {
+				t.Errorf("getCommandLabel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchHost(t *testing.T) {
+	match := matchHost("home.example.com")
+	tests := []struct {
+		name string
+		host string
+		want bool
+	}{
+		{name: "Exact", host: "home.example.com", want: true},
+		{name: "UpperCase", host: "Home.Example.com", want: true},
+		{name: "Port", host: "HOME.example.com:443", want: true},
+		{name: "OtherHost", host: "hooks.example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/dvi/kitchen/on", nil)
+			req.Host = tt.host
+			if got := match(req, nil); got != tt.want {
+				t.Errorf("matchHost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package proxy
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/axxelG/loxwebhook/config"
+	"github.com/axxelG/loxwebhook/controls"
+)
+
+// limiterSet holds one token bucket per client IP, auth key or control. The
+// number of buckets is bounded to keep the memory usage constant.
+type limiterSet struct {
+	mu       sync.Mutex
+	entries  map[string]*limiterEntry
+	max      int
+	rejected uint64
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newLimiterSet(max int) *limiterSet {
+	return &limiterSet{
+		entries: make(map[string]*limiterEntry),
+		max:     max,
+	}
+}
+
+// allow returns true if a request for name may pass. perMinute 0 disables
+// the limit.
+func (ls *limiterSet) allow(name string, perMinute, burst int, now time.Time) bool {
+	if perMinute == 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	limit := rate.Limit(float64(perMinute) / 60)
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	e, ok := ls.entries[name]
+	if !ok {
+		if len(ls.entries) >= ls.max {
+			ls.evict(now)
+		}
+		e = &limiterEntry{limiter: rate.NewLimiter(limit, burst)}
+		ls.entries[name] = e
+	}
+	// Limits change if controls are reloaded
+	if e.limiter.Limit() != limit {
+		e.limiter.SetLimitAt(now, limit)
+	}
+	if e.limiter.Burst() != burst {
+		e.limiter.SetBurstAt(now, burst)
+	}
+	e.lastSeen = now
+	if !e.limiter.AllowN(now, 1) {
+		ls.rejected++
+		return false
+	}
+	return true
+}
+
+// evict drops all buckets that are full again. Dropping them does not change
+// any limit. If all buckets are in use the least recently used one is dropped.
+func (ls *limiterSet) evict(now time.Time) {
+	var oldest string
+	for name, e := range ls.entries {
+		if e.limiter.TokensAt(now) >= float64(e.limiter.Burst()) {
+			delete(ls.entries, name)
+			continue
+		}
+		if oldest == "" || e.lastSeen.Before(ls.entries[oldest].lastSeen) {
+			oldest = name
+		}
+	}
+	if len(ls.entries) >= ls.max {
+		delete(ls.entries, oldest)
+	}
+}
+
+// limiterStatus is the state of a limiterSet reported by the status endpoint
+type limiterStatus struct {
+	Entries    int      `json:"entries"`
+	MaxEntries int      `json:"maxEntries"`
+	Throttled  []string `json:"throttled"` // Names without tokens left
+	Rejected   uint64   `json:"rejected"`  // Rejected requests since start
+}
+
+func (ls *limiterSet) status(now time.Time) limiterStatus {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	s := limiterStatus{
+		Entries:    len(ls.entries),
+		MaxEntries: ls.max,
+		Throttled:  []string{},
+		Rejected:   ls.rejected,
+	}
+	for name, e := range ls.entries {
+		if e.limiter.TokensAt(now) < 1 {
+			s.Throttled = append(s.Throttled, name)
+		}
+	}
+	sort.Strings(s.Throttled)
+	return s
+}
+
+// banList bans client IPs that send too many requests with unknown auth keys
+type banList struct {
+	mu        sync.Mutex
+	threshold int
+	banTime   time.Duration
+	max       int
+	failures  map[string]*failureEntry
+	banned    map[string]time.Time // IP -> end of the ban
+}
+
+type failureEntry struct {
+	count int
+	since time.Time
+}
+
+func newBanList(threshold int, banTime time.Duration, max int) *banList {
+	return &banList{
+		threshold: threshold,
+		banTime:   banTime,
+		max:       max,
+		failures:  make(map[string]*failureEntry),
+		banned:    make(map[string]time.Time),
+	}
+}
+
+// bannedUntil returns the end of the ban if ip is banned
+func (b *banList) bannedUntil(ip string, now time.Time) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.banned[ip]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !now.Before(until) {
+		delete(b.banned, ip)
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// fail counts a failed request of ip. It returns true if ip is banned now.
+func (b *banList) fail(ip string, now time.Time) bool {
+	if b.threshold == 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f, ok := b.failures[ip]
+	if ok && now.Sub(f.since) > b.banTime {
+		// Failures are only counted within banTime
+		ok = false
+	}
+	if !ok {
+		if len(b.failures) >= b.max {
+			b.prune(now)
+		}
+		f = &failureEntry{since: now}
+		b.failures[ip] = f
+	}
+	f.count++
+	if f.count < b.threshold {
+		return false
+	}
+	delete(b.failures, ip)
+	if len(b.banned) >= b.max {
+		b.prune(now)
+	}
+	b.banned[ip] = now.Add(b.banTime)
+	return true
+}
+
+// prune drops expired entries. If the maps are still full the oldest
+// entries are dropped.
+func (b *banList) prune(now time.Time) {
+	var oldestFailure, firstBanEnd string
+	for ip, f := range b.failures {
+		if now.Sub(f.since) > b.banTime {
+			delete(b.failures, ip)
+			continue
+		}
+		if oldestFailure == "" || f.since.Before(b.failures[oldestFailure].since) {
+			oldestFailure = ip
+		}
+	}
+	if len(b.failures) >= b.max {
+		delete(b.failures, oldestFailure)
+	}
+	for ip, until := range b.banned {
+		if !now.Before(until) {
+			delete(b.banned, ip)
+			continue
+		}
+		if firstBanEnd == "" || until.Before(b.banned[firstBanEnd]) {
+			firstBanEnd = ip
+		}
+	}
+	if len(b.banned) >= b.max {
+		delete(b.banned, firstBanEnd)
+	}
+}
+
+// banStatus is the state of the banList reported by the status endpoint
+type banStatus struct {
+	Tracked int                  `json:"tracked"` // IPs with failed requests
+	Banned  map[string]time.Time `json:"banned"`  // IP -> end of the ban
+}
+
+func (b *banList) status(now time.Time) banStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := banStatus{
+		Tracked: len(b.failures),
+		Banned:  make(map[string]time.Time),
+	}
+	for ip, until := range b.banned {
+		if now.Before(until) {
+			s.Banned[ip] = until
+		}
+	}
+	return s
+}
+
+// Limits are the rate limiters per client IP, auth key and control and the
+// list of banned client IPs
+type Limits struct {
+	ips      *limiterSet
+	keys     *limiterSet
+	controls *limiterSet
+	bans     *banList
+
+	ipLimit, ipBurst           int
+	keyLimit, keyBurst         int
+	controlLimit, controlBurst int
+}
+
+// NewLimits returns the limiters configured in cfg
+func NewLimits(cfg *config.Config) *Limits {
+	return &Limits{
+		ips:          newLimiterSet(cfg.LimiterMaxEntries),
+		keys:         newLimiterSet(cfg.LimiterMaxEntries),
+		controls:     newLimiterSet(cfg.LimiterMaxEntries),
+		bans:         newBanList(cfg.BanThreshold, time.Duration(cfg.BanTime)*time.Second, cfg.LimiterMaxEntries),
+		ipLimit:      cfg.RateLimitIP,
+		ipBurst:      cfg.RateBurstIP,
+		keyLimit:     cfg.RateLimitKey,
+		keyBurst:     cfg.RateBurstKey,
+		controlLimit: cfg.RateLimitControl,
+		controlBurst: cfg.RateBurstControl,
+	}
+}
+
+func (l *Limits) allowIP(ip string, now time.Time) bool {
+	return l.ips.allow(ip, l.ipLimit, l.ipBurst, now)
+}
+
+func (l *Limits) allowKey(name string, k controls.AuthKey, now time.Time) bool {
+	limit, burst := k.RateLimit, k.RateBurst
+	if limit == 0 {
+		limit = l.keyLimit
+	}
+	if burst == 0 {
+		burst = l.keyBurst
+	}
+	return l.keys.allow(name, limit, burst, now)
+}
+
+func (l *Limits) allowControl(name string, ctl controls.Control, now time.Time) bool {
+	limit, burst := ctl.RateLimit, ctl.RateBurst
+	if limit == 0 {
+		limit = l.controlLimit
+	}
+	if burst == 0 {
+		burst = l.controlBurst
+	}
+	return l.controls.allow(name, limit, burst, now)
+}
+
+// limitsStatus is the response of the status endpoint
+type limitsStatus struct {
+	IPs      limiterStatus `json:"ips"`
+	Keys     limiterStatus `json:"keys"`
+	Controls limiterStatus `json:"controls"`
+	Bans     banStatus     `json:"bans"`
+}
+
+func (l *Limits) status(now time.Time) limitsStatus {
+	return limitsStatus{
+		IPs:      l.ips.status(now),
+		Keys:     l.keys.status(now),
+		Controls: l.controls.status(now),
+		Bans:     l.bans.status(now),
+	}
+}
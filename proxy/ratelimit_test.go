@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/axxelG/loxwebhook/config"
+	"github.com/axxelG/loxwebhook/controls"
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+func Test_limiterSet_allow(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	ls := newLimiterSet(10)
+	for i := 0; i < 3; i++ {
+		if !ls.allow("a", 60, 3, now) {
+			t.Fatalf("allow() rejected request %d within the burst", i+1)
+		}
+	}
+	if ls.allow("a", 60, 3, now) {
+		t.Errorf("allow() accepted a request above the burst")
+	}
+	if !ls.allow("b", 60, 3, now) {
+		t.Errorf("allow() limited b because of a")
+	}
+	if !ls.allow("a", 60, 3, now.Add(time.Second)) {
+		t.Errorf("allow() rejected a request after a token was refilled")
+	}
+	if !ls.allow("c", 0, 0, now) {
+		t.Errorf("allow() with limit 0 rejected a request")
+	}
+	if got := ls.status(now).Rejected; got != 1 {
+		t.Errorf("status().Rejected = %d, want 1", got)
+	}
+}
+
+func Test_limiterSet_bounded(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	ls := newLimiterSet(3)
+	for i := 0; i < 10; i++ {
+		ls.allow(strconv.Itoa(i), 60, 1, now.Add(time.Duration(i)*time.Millisecond))
+	}
+	s := ls.status(now.Add(time.Second))
+	if s.Entries > 3 {
+		t.Errorf("status().Entries = %d, want at most 3", s.Entries)
+	}
+	// The most recently used bucket is kept
+	if ls.allow("9", 60, 1, now.Add(10*time.Millisecond)) {
+		t.Errorf("allow() forgot the most recent bucket")
+	}
+}
+
+func Test_banList(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	b := newBanList(3, time.Minute, 10)
+	for i := 0; i < 2; i++ {
+		if b.fail("192.0.2.1", now) {
+			t.Fatalf("fail() banned after %d failures", i+1)
+		}
+	}
+	if _, banned := b.bannedUntil("192.0.2.1", now); banned {
+		t.Fatalf("bannedUntil() reports a ban below the threshold")
+	}
+	if !b.fail("192.0.2.1", now) {
+		t.Fatalf("fail() did not ban at the threshold")
+	}
+	until, banned := b.bannedUntil("192.0.2.1", now.Add(30*time.Second))
+	if !banned || !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("bannedUntil() = %v, %v, want %v, true", until, banned, now.Add(time.Minute))
+	}
+	if _, banned := b.bannedUntil("192.0.2.2", now); banned {
+		t.Errorf("bannedUntil() banned another IP")
+	}
+	if _, banned := b.bannedUntil("192.0.2.1", now.Add(time.Minute)); banned {
+		t.Errorf("bannedUntil() ban did not expire")
+	}
+	// Failures older than the ban time are not counted
+	b.fail("192.0.2.3", now)
+	b.fail("192.0.2.3", now)
+	if b.fail("192.0.2.3", now.Add(2*time.Minute)) {
+		t.Errorf("fail() counted expired failures")
+	}
+}
+
+func Test_banList_bounded(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	b := newBanList(1, time.Hour, 5)
+	for i := 0; i < 20; i++ {
+		b.fail("192.0.2."+strconv.Itoa(i), now.Add(time.Duration(i)*time.Second))
+	}
+	if s := b.status(now); len(s.Banned) > 5 {
+		t.Errorf("status() has %d bans, want at most 5", len(s.Banned))
+	}
+	if _, banned := b.bannedUntil("192.0.2.19", now); !banned {
+		t.Errorf("bannedUntil() dropped the latest ban")
+	}
+}
+
+func TestLimits_allowKey(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	l := NewLimits(&config.Config{
+		RateLimitKey:      60,
+		RateBurstKey:      1,
+		LimiterMaxEntries: 10,
+	})
+	if !l.allowKey("default", controls.AuthKey{}, now) || l.allowKey("default", controls.AuthKey{}, now) {
+		t.Errorf("allowKey() did not use the default burst of 1")
+	}
+	own := controls.AuthKey{RateLimit: 60, RateBurst: 2}
+	if !l.allowKey("own", own, now) || !l.allowKey("own", own, now) {
+		t.Errorf("allowKey() did not use the burst of the key")
+	}
+}
+
+func TestNewAdminServer_status(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	l := NewLimits(&config.Config{
+		BanThreshold:      1,
+		BanTime:           60,
+		LimiterMaxEntries: 10,
+	})
+	l.bans.fail("192.0.2.1", time.Now())
+	go NewAdminServer(logging.Discard(), l, NewHealth(nil, time.Now())).Serve(listener)
+	resp, err := http.Get("http://" + listener.Addr().String() + "/status")
+	if err != nil {
+		t.Fatalf("GET /status error = %v", err)
+	}
+	defer resp.Body.Close()
+	var s limitsStatus
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		t.Fatalf("Cannot decode status: %v", err)
+	}
+	if _, ok := s.Bans.Banned["192.0.2.1"]; !ok {
+		t.Errorf("status does not report the banned IP: %+v", s.Bans)
+	}
+	if s.IPs.MaxEntries != 10 {
+		t.Errorf("status IPs.MaxEntries = %d, want 10", s.IPs.MaxEntries)
+	}
+}
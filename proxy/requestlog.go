@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// requestIDHeader carries the ID of a request. It is returned to the client
+// and taken over from trusted proxies.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of request IDs sent by proxies
+const maxRequestIDLength = 128
+
+// requestLog collects the fields of the log entries of a request. The
+// handlers fill it in as soon as they know a field.
+type requestLog struct {
+	id               string
+	control          string
+	authKey          string // Name of the key, never its value
+	command          string
+	miniserver       string
+	upstreamStatus   int
+	upstreamDuration time.Duration
+}
+
+type requestLogKey struct{}
+
+// withRequestLog returns req with rl in its context
+func withRequestLog(req *http.Request, rl *requestLog) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestLogKey{}, rl))
+}
+
+// getRequestLog returns the requestLog of req. Requests that did not pass
+// the logging handler get an empty one.
+func getRequestLog(req *http.Request) *requestLog {
+	if rl, ok := req.Context().Value(requestLogKey{}).(*requestLog); ok {
+		return rl
+	}
+	return &requestLog{}
+}
+
+// attrs returns the known fields
+func (rl *requestLog) attrs() []interface{} {
+	var attrs []interface{}
+	add := func(key, value string) {
+		if value != "" {
+			attrs = append(attrs, key, value)
+		}
+	}
+	add("requestId", rl.id)
+	add("control", rl.control)
+	add("authKey", rl.authKey)
+	add("command", rl.command)
+	add("miniserver", rl.miniserver)
+	if rl.upstreamStatus != 0 {
+		attrs = append(attrs, "upstreamStatus", rl.upstreamStatus)
+	}
+	if rl.upstreamDuration != 0 {
+		attrs = append(attrs, "upstreamDuration", rl.upstreamDuration.Seconds())
+	}
+	return attrs
+}
+
+// requestLogger returns logger with the fields of req known so far
+func requestLogger(logger *slog.Logger, req *http.Request) *slog.Logger {
+	return logger.With(getRequestLog(req).attrs()...)
+}
+
+// getRequestID returns the X-Request-ID of req if it was set by a trusted
+// proxy. Other requests get a new random ID.
+func getRequestID(req *http.Request, trusted trustedProxies) string {
+	if id := req.Header.Get(requestIDHeader); isValidRequestID(id) && isFromTrustedProxy(req, trusted) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isValidRequestID returns true if id only contains characters that are
+// safe in logs and headers
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// logRequests passes requests to next and writes an access log entry after
+// next returned. Every request gets an ID that is sent back in the
+// X-Request-ID header.
+func logRequests(logger *slog.Logger, trusted trustedProxies, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rl := &requestLog{id: getRequestID(req, trusted)}
+		req = withRequestLog(req, rl)
+		w.Header().Set(requestIDHeader, rl.id)
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rec, req)
+		requestLogger(logger, req).Info("Request",
+			"method", req.Method,
+			"path", req.URL.Path,
+			"client", getClientIP(req, trusted).String(),
+			"status", rec.code,
+			"duration", time.Since(start).Seconds(),
+		)
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_getRequestID(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name       string
+		unixSocket bool
+		remoteAddr string
+		id         string
+		wantKept   bool
+	}{
+		{
+			name:       "TrustedProxy",
+			remoteAddr: "10.0.0.1:4711",
+			id:         "6f1c2a9e-8d4b-4c1e-9a7f-1b2c3d4e5f60",
+			wantKept:   true,
+		},
+		{
+			name:       "UnixSocket",
+			unixSocket: true,
+			remoteAddr: "@",
+			id:         "abc.123_x",
+			wantKept:   true,
+		},
+		{
+			name:       "UntrustedUnixSocket",
+			remoteAddr: "@",
+			id:         "abc.123_x",
+			wantKept:   false,
+		},
+		{
+			name:       "Client",
+			remoteAddr: "192.0.2.1:4711",
+			id:         "6f1c2a9e-8d4b-4c1e-9a7f-1b2c3d4e5f60",
+			wantKept:   false,
+		},
+		{
+			name:       "InvalidCharacters",
+			remoteAddr: "10.0.0.1:4711",
+			id:         "id\" level=ERROR",
+			wantKept:   false,
+		},
+		{
+			name:       "TooLong",
+			remoteAddr: "10.0.0.1:4711",
+			id:         strings.Repeat("a", maxRequestIDLength+1),
+			wantKept:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/dvi/kitchen/on", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(requestIDHeader, tt.id)
+			got := getRequestID(req, trustedProxies{networks: []*net.IPNet{network}, unixSocket: tt.unixSocket})
+			if (got == tt.id) != tt.wantKept {
+				t.Errorf("getRequestID() = %q, want kept %v", got, tt.wantKept)
+			}
+			if !isValidRequestID(got) {
+				t.Errorf("getRequestID() = %q is not a valid ID", got)
+			}
+		})
+	}
+}
+
+func Test_logRequests(t *testing.T) {
+	var accessLog, errorLog bytes.Buffer
+	loggerAcc := slog.New(slog.NewJSONHandler(&accessLog, nil))
+	loggerErr := slog.New(slog.NewJSONHandler(&errorLog, nil))
+	handler := logRequests(loggerAcc, trustedProxies{}, func(w http.ResponseWriter, req *http.Request) {
+		rl := getRequestLog(req)
+		rl.control, rl.command, rl.authKey = "kitchen", "on", "testOne"
+		rl.miniserver, rl.upstreamStatus = "default", http.StatusInternalServerError
+		sendErrorPage(loggerErr, w, req, errors.New("Miniserver responded with status code 500"), http.StatusBadGateway)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/dvi/kitchen/on?k=43b2c690-f281-42bb-af2d-979f5dbe9517", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	id := rec.Header().Get(requestIDHeader)
+	if id == "" {
+		t.Fatalf("Response without %s header", requestIDHeader)
+	}
+	var acc, errEntry map[string]interface{}
+	if err := json.Unmarshal(accessLog.Bytes(), &acc); err != nil {
+		t.Fatalf("Cannot decode access log %q: %v", accessLog.String(), err)
+	}
+	if err := json.Unmarshal(errorLog.Bytes(), &errEntry); err != nil {
+		t.Fatalf("Cannot decode error log %q: %v", errorLog.String(), err)
+	}
+	want := map[string]interface{}{
+		"requestId":      id,
+		"control":        "kitchen",
+		"command":        "on",
+		"authKey":        "testOne",
+		"miniserver":     "default",
+		"upstreamStatus": float64(http.StatusInternalServerError),
+		"status":         float64(http.StatusBadGateway),
+		"path":           "/dvi/kitchen/on",
+	}
+	for key, value := range want {
+		if acc[key] != value {
+			t.Errorf("Access log %s = %v, want %v", key, acc[key], value)
+		}
+	}
+	if _, ok := acc["duration"]; !ok {
+		t.Errorf("Access log without duration: %v", acc)
+	}
+	if errEntry["requestId"] != id || errEntry["control"] != "kitchen" || errEntry["level"] != "ERROR" {
+		t.Errorf("Error log = %v, want requestId %s, control kitchen and level ERROR", errEntry, id)
+	}
+	if strings.Contains(accessLog.String()+errorLog.String(), "43b2c690") {
+		t.Errorf("Logs contain the auth key value: %s %s", accessLog.String(), errorLog.String())
+	}
+}
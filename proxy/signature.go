@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/axxelG/loxwebhook/controls"
+)
+
+// Headers of signed requests
+const (
+	headerAuthKey   = "X-Loxwebhook-Key"
+	headerTimestamp = "X-Loxwebhook-Timestamp"
+	headerNonce     = "X-Loxwebhook-Nonce"
+	headerSignature = "X-Loxwebhook-Signature"
+)
+
+const (
+	maxSignedBodySize = 64 * 1024
+	maxNonceLength    = 128
+	maxNonces         = 100000
+)
+
+// nonceCache remembers the nonces of signed requests until their
+// timestamps are outside of the accepted clock skew
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	max  int
+}
+
+func newNonceCache(max int) *nonceCache {
+	return &nonceCache{
+		seen: make(map[string]time.Time),
+		max:  max,
+	}
+}
+
+// add stores nonce until expires. It returns an error if the nonce was
+// already seen or the cache is full.
+func (nc *nonceCache) add(nonce string, expires, now time.Time) error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if e, ok := nc.seen[nonce]; ok && e.After(now) {
+		return errors.New("Nonce has already been used")
+	}
+	if len(nc.seen) >= nc.max {
+		for n, e := range nc.seen {
+			if !e.After(now) {
+				delete(nc.seen, n)
+			}
+		}
+	}
+	if len(nc.seen) >= nc.max {
+		// Dropping unexpired nonces would allow replays
+		return errors.New("Too many signed requests")
+	}
+	nc.seen[nonce] = expires
+	return nil
+}
+
+// getSigningString returns the message that is signed by the client
+func getSigningString(req *http.Request, timestamp, nonce string, body []byte) string {
+	path := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	return req.Method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + string(body)
+}
+
+func sign(secret, msg string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// verifySignature checks the signature of a signed request and returns the
+// name of the auth key. Requests without signature return an empty name.
+// The body is read and restored for later handlers.
+func verifySignature(req *http.Request, authKeys map[string]controls.AuthKey, nonces *nonceCache, now time.Time) (string, error) {
+	signature := req.Header.Get(headerSignature)
+	if signature == "" {
+		return "", nil
+	}
+	name := req.Header.Get(headerAuthKey)
+	key, ok := authKeys[name]
+	if !ok {
+		return "", &unknownAuthKeyError{name: name}
+	}
+	if key.GetMode() != "hmac" {
+		return "", fmt.Errorf("AuthKey %s does not accept signed requests", name)
+	}
+	timestamp := req.Header.Get(headerTimestamp)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", errors.New("Invalid timestamp")
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > key.GetMaxClockSkew() {
+		return "", errors.New("Request timestamp is outside of the accepted clock skew")
+	}
+	nonce := req.Header.Get(headerNonce)
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return "", errors.New("Invalid nonce")
+	}
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, maxSignedBodySize))
+		if err != nil {
+			return "", errors.Wrap(err, "Cannot read request body")
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return "", errors.New("Invalid signature")
+	}
+	if !hmac.Equal(got, sign(key.Key, getSigningString(req, timestamp, nonce, body))) {
+		return "", errors.New("Invalid signature")
+	}
+	// Nonces are stored after the signature check so that unsigned
+	// requests cannot use up nonces
+	if err := nonces.add(name+":"+nonce, now.Add(2*key.GetMaxClockSkew()), now); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axxelG/loxwebhook/controls"
+)
+
+func newSignedRequest(method, target, body, keyName, secret string, ts time.Time, nonce string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	req.Header.Set(headerAuthKey, keyName)
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerNonce, nonce)
+	req.Header.Set(headerSignature, hex.EncodeToString(sign(secret, getSigningString(req, timestamp, nonce, []byte(body)))))
+	return req
+}
+
+func Test_verifySignature(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	authKeys := map[string]controls.AuthKey{
+		"plain":  {Key: "f7932d8a-b37f-46dc-84ee-276c545aec48"},
+		"signed": {Key: secret, Mode: "hmac", MaxClockSkew: 60},
+	}
+	now := time.Unix(1600000000, 0)
+	tests := []struct {
+		name    string
+		req     func() *http.Request
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Unsigned",
+			req: func() *http.Request {
+				return httptest.NewRequest("GET", "/dvi/test/on?k=f7932d8a-b37f-46dc-84ee-276c545aec48", nil)
+			},
+			want: "",
+		},
+		{
+			name: "Valid",
+			req: func() *http.Request {
+				return newSignedRequest("POST", "/vti/test", "Hello", "signed", secret, now, "n1")
+			},
+			want: "signed",
+		},
+		{
+			name: "ValidWithQuery",
+			req: func() *http.Request {
+				return newSignedRequest("GET", "/vti/test?text=Hello%20World", "", "signed", secret, now.Add(-30*time.Second), "n2")
+			},
+			want: "signed",
+		},
+		{
+			name: "WrongSecret",
+			req: func() *http.Request {
+				return newSignedRequest("POST", "/vti/test", "Hello", "signed", "wrongwrongwrongwrong", now, "n3")
+			},
+			wantErr: true,
+		},
+		{
+			name: "ModifiedBody",
+			req: func() *http.Request {
+				req := newSignedRequest("POST", "/vti/test", "Hello", "signed", secret, now, "n4")
+				req.Body = ioutil.NopCloser(strings.NewReader("Goodbye"))
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "ModifiedPath",
+			req: func() *http.Request {
+				req := newSignedRequest("GET", "/dvi/test/on", "", "signed", secret, now, "n5")
+				req.URL.Path = "/dvi/test/off"
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "OutsideClockSkew",
+			req: func() *http.Request {
+				return newSignedRequest("GET", "/dvi/test/on", "", "signed", secret, now.Add(-2*time.Minute), "n6")
+			},
+			wantErr: true,
+		},
+		{
+			name: "PlainKey",
+			req: func() *http.Request {
+				return newSignedRequest("GET", "/dvi/test/on", "", "plain", authKeys["plain"].Key, now, "n7")
+			},
+			wantErr: true,
+		},
+		{
+			name: "UnknownKey",
+			req: func() *http.Request {
+				return newSignedRequest("GET", "/dvi/test/on", "", "unknown", secret, now, "n8")
+			},
+			wantErr: true,
+		},
+		{
+			name: "NoNonce",
+			req: func() *http.Request {
+				return newSignedRequest("GET", "/dvi/test/on", "", "signed", secret, now, "")
+			},
+			wantErr: true,
+		},
+	}
+	nonces := newNonceCache(maxNonces)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifySignature(tt.req(), authKeys, nonces, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifySignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("verifySignature() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_verifySignature_Replay(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	authKeys := map[string]controls.AuthKey{
+		"signed": {Key: secret, Mode: "hmac"},
+	}
+	now := time.Now()
+	nonces := newNonceCache(maxNonces)
+	req := newSignedRequest("POST", "/vti/test", "Hello", "signed", secret, now, "once")
+	if _, err := verifySignature(req, authKeys, nonces, now); err != nil {
+		t.Fatalf("verifySignature() error = %v", err)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if string(body) != "Hello" {
+		t.Errorf("verifySignature() did not restore the body. Got: %s", body)
+	}
+	req = newSignedRequest("POST", "/vti/test", "Hello", "signed", secret, now, "once")
+	if _, err := verifySignature(req, authKeys, nonces, now.Add(time.Second)); err == nil {
+		t.Errorf("verifySignature() accepted a replayed request")
+	}
+}
+
+func Test_nonceCache(t *testing.T) {
+	now := time.Now()
+	nc := newNonceCache(2)
+	if err := nc.add("a", now.Add(time.Minute), now); err != nil {
+		t.Fatalf("add() error = %v", err)
+	}
+	if err := nc.add("b", now.Add(time.Hour), now); err != nil {
+		t.Fatalf("add() error = %v", err)
+	}
+	if err := nc.add("c", now.Add(time.Hour), now); err == nil {
+		t.Errorf("add() to a full cache returned no error")
+	}
+	// a is expired and makes room for c
+	later := now.Add(2 * time.Minute)
+	if err := nc.add("c", later.Add(time.Hour), later); err != nil {
+		t.Errorf("add() after expiry error = %v", err)
+	}
+	if err := nc.add("b", later.Add(time.Hour), later); err == nil {
+		t.Errorf("add() accepted a nonce twice")
+	}
+}
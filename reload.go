@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/axxelG/loxwebhook/controls"
+	"github.com/axxelG/loxwebhook/logging"
+	"github.com/axxelG/loxwebhook/metrics"
+	"github.com/axxelG/loxwebhook/proxy"
+)
+
+// watchDelay is the time without further changes before changed controls
+// files are reloaded. Editors and deployments write files in several steps.
+var watchDelay = time.Second
+
+// reloadControls reloads the controls on every signal from hup and after
+// changes reported by changed. If the new controls are invalid the active
+// ones are kept, the error is logged and reported by the readiness endpoint.
+func reloadControls(store *controls.Store, hup <-chan os.Signal, changed <-chan struct{}, m *metrics.Metrics, health *proxy.Health, live *liveness, logger *slog.Logger) {
+	ticker := time.NewTicker(beatInterval)
+	defer ticker.Stop()
+	var delay <-chan time.Time
+	for {
+		select {
+		case now := <-ticker.C:
+			live.beat("reload", now)
+			continue
+		case <-hup:
+		case _, ok := <-changed:
+			if !ok {
+				changed = nil
+				logger.Warn("Stopped watching controls files, reload them with SIGHUP")
+				continue
+			}
+			delay = time.After(watchDelay)
+			continue
+		case <-delay:
+			delay = nil
+		}
+		snap, err := store.Reload()
+		health.SetControls(err, time.Now())
+		if err != nil {
+			logger.Error("Error reloading controls, keeping the active controls", logging.Err(err))
+			continue
+		}
+		m.ControlsLoaded.Set(float64(time.Now().Unix()))
+		logger.Info("Reloaded controls", "controls", len(snap.Controls), "authKeys", len(snap.AuthKeys))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axxelG/loxwebhook/controls"
+	"github.com/axxelG/loxwebhook/metrics"
+	"github.com/axxelG/loxwebhook/proxy"
+)
+
+// syncBuffer is a bytes.Buffer that can be written by a logger and read by
+// the test at the same time
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func Test_reloadControls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loxwebhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "controls.toml")
+	write := func(ctls string) {
+		data := "[AuthKeys]\ntestOne = \"43b2c690-f281-42bb-af2d-979f5dbe9517\"\n" + ctls
+		if err := ioutil.WriteFile(fn, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	control := func(name string) string {
+		return "[Controls." + name + "]\nCategory = \"dvi\"\nID = 1\nAllowed = [\"on\"]\nAuthKeys = [\"testOne\"]\n"
+	}
+	write(control("one"))
+	store, err := controls.NewStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchDelay = 10 * time.Millisecond
+	hup := make(chan os.Signal)
+	changed := make(chan struct{})
+	logs := &syncBuffer{}
+	m := metrics.New()
+	health := proxy.NewHealth(nil, time.Now())
+	go reloadControls(store, hup, changed, m, health, newLiveness(), slog.New(slog.NewTextHandler(logs, nil)))
+	waitFor := func(what string, ok func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !ok() {
+			if time.Now().After(deadline) {
+				t.Fatalf("reloadControls() did not %s, log: %s", what, logs)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	write(control("one") + control("two"))
+	hup <- os.Interrupt
+	waitFor("reload on SIGHUP", func() bool { _, ok := store.Get().Controls["two"]; return ok })
+	waitFor("record the reload", func() bool { return m.ControlsLoaded.Get() > 0 })
+
+	write(control("three"))
+	changed <- struct{}{}
+	waitFor("reload on a change", func() bool { _, ok := store.Get().Controls["three"]; return ok })
+
+	write("[Controls.broken")
+	hup <- os.Interrupt
+	waitFor("log the error", func() bool { return strings.Contains(logs.String(), "keeping the active controls") })
+	if _, ok := store.Get().Controls["three"]; !ok {
+		t.Errorf("reloadControls() dropped the active controls after an error")
+	}
+}
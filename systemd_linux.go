@@ -0,0 +1,92 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/coreos/go-systemd/activation"
+	"github.com/coreos/go-systemd/daemon"
+	"github.com/pkg/errors"
+
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+// adminSocketName is the FileDescriptorName of the admin socket in the
+// systemd socket unit
+const adminSocketName = "admin"
+
+// activatedListeners returns the sockets passed by systemd socket
+// activation. The socket named admin is the admin listener, the other one is
+// the listener of the proxy. Both are nil without socket activation.
+func activatedListeners() (proxy, admin net.Listener, err error) {
+	named, err := activation.ListenersWithNames()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Error reading sockets from systemd")
+	}
+	for name, listeners := range named {
+		for _, l := range listeners {
+			if name == adminSocketName && admin == nil {
+				admin = l
+				continue
+			}
+			if proxy != nil || name == adminSocketName {
+				return nil, nil, errors.New("systemd passed more than one socket for proxy or admin listener")
+			}
+			proxy = l
+		}
+	}
+	return proxy, admin, nil
+}
+
+// notifyReady tells systemd that loxwebhook serves requests
+func notifyReady() {
+	daemon.SdNotify(false, daemon.SdNotifyReady)
+}
+
+// notifyStopping tells systemd that loxwebhook is shutting down
+func notifyStopping() {
+	daemon.SdNotify(false, daemon.SdNotifyStopping)
+}
+
+// startWatchdog starts watchdog if systemd enabled the watchdog for the
+// service. Pinging twice per interval keeps one failed check from
+// restarting loxwebhook.
+func startWatchdog(check func() error, logger *slog.Logger, done <-chan struct{}) {
+	if interval, err := daemon.SdWatchdogEnabled(false); err == nil && interval > 0 {
+		go watchdog(interval/2, check, logger, done)
+	}
+}
+
+// watchdog sends WATCHDOG=1 to systemd every interval as long as check
+// returns no error. systemd restarts loxwebhook if the pings stop for
+// WatchdogSec. It returns when done is closed.
+func watchdog(interval time.Duration, check func() error, logger *slog.Logger, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var failing bool
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if err := check(); err != nil {
+			if !failing {
+				logger.Error("Health check failed, watchdog pings stopped", logging.Err(err))
+			}
+			failing = true
+			continue
+		}
+		if failing {
+			logger.Info("Health check passed, watchdog pings resumed")
+			failing = false
+		}
+		if _, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog); err != nil {
+			logger.Warn("Error sending watchdog ping", logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/axxelG/loxwebhook/logging"
+)
+
+func Test_watchdog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loxwebhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	var healthy atomic.Value
+	healthy.Store(false)
+	check := func() error {
+		if !healthy.Load().(bool) {
+			return errors.New("Miniserver unreachable")
+		}
+		return nil
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go watchdog(10*time.Millisecond, check, logging.Discard(), done)
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := conn.Read(buf); err == nil {
+		t.Fatalf("watchdog() sent %q while the check failed", buf[:n])
+	}
+	healthy.Store(true)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("watchdog() sent no ping after the check passed: %v", err)
+	}
+	if got := string(buf[:n]); got != "WATCHDOG=1" {
+		t.Errorf("watchdog() sent %q, want WATCHDOG=1", got)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"log/slog"
+	"net"
+)
+
+// activatedListeners returns no sockets, socket activation is only
+// supported on Linux
+func activatedListeners() (proxy, admin net.Listener, err error) {
+	return nil, nil, nil
+}
+
+// notifyReady does nothing without systemd
+func notifyReady() {}
+
+// notifyStopping does nothing without systemd
+func notifyStopping() {}
+
+// startWatchdog does nothing without systemd
+func startWatchdog(check func() error, logger *slog.Logger, done <-chan struct{}) {}